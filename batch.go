@@ -0,0 +1,231 @@
+/*
+Copyright 2023 Alexandre Mahdhaoui
+
+Licensed under the Apache License, Version 2.0 (the "License");
+you may not use this file except in compliance with the License.
+You may obtain a copy of the License at
+
+	http://www.apache.org/licenses/LICENSE-2.0
+
+Unless required by applicable law or agreed to in writing, software
+distributed under the License is distributed on an "AS IS" BASIS,
+WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+See the License for the specific language governing permissions and
+limitations under the License.
+*/
+
+package bda
+
+import (
+	"context"
+	"fmt"
+	"sync"
+	"time"
+)
+
+//----------------------------------------------------------------------------------------------------------------------
+//- Batcher
+
+// Batcher is an adapter over any Queue yielding batches of items instead of single items.
+// A batch is complete when it holds MaxSize items, or when MaxWait elapsed since its first item was received.
+// A MaxSize lower than 1 is treated as 1. If MaxWait is not positive, batches only hold the items already available.
+// A Batcher is safe for concurrent use: each call to Next builds its own batch.
+type Batcher[T any] struct {
+	Queue   Queue[T]
+	MaxSize int
+	MaxWait time.Duration
+}
+
+// Next blocks until a batch is ready and returns it. Items are received through ReceiveContext and TryReceive, so
+// they are accounted for in the Stats of the Queue.
+// Returns false if the context is done or the queue is closed before any item was received.
+// A partial batch is returned if the queue is closed or the context is done after the first item.
+func (b *Batcher[T]) Next(ctx context.Context) ([]T, bool) {
+	first, ok := b.Queue.ReceiveContext(ctx)
+	if !ok {
+		return nil, false
+	}
+
+	maxSize := b.MaxSize
+	if maxSize < 1 {
+		maxSize = 1
+	}
+	batch := make([]T, 1, maxSize)
+	batch[0] = first
+
+	// without MaxWait, the batch is complete as soon as no item is available
+	if b.MaxWait <= 0 {
+		for len(batch) < maxSize {
+			item, ok := b.Queue.TryReceive(0)
+			if !ok {
+				break
+			}
+			batch = append(batch, item)
+		}
+		return batch, true
+	}
+
+	waitCtx, cancel := context.WithTimeout(ctx, b.MaxWait)
+	defer cancel()
+	for len(batch) < maxSize {
+		item, ok := b.Queue.ReceiveContext(waitCtx)
+		if !ok {
+			break
+		}
+		batch = append(batch, item)
+	}
+	return batch, true
+}
+
+// DefaultBatcher returns a new Batcher over the given Queue.
+func DefaultBatcher[T any](q Queue[T], maxSize int, maxWait time.Duration) *Batcher[T] {
+	if maxSize < 1 {
+		maxSize = 1
+	}
+	return &Batcher[T]{
+		Queue:   q,
+		MaxSize: maxSize,
+		MaxWait: maxWait,
+	}
+}
+
+//----------------------------------------------------------------------------------------------------------------------
+//- BatchReceptor
+
+// BatchReceptor is a Runtime able to process batches of items.
+type BatchReceptor[T any] interface {
+	Runtime
+	// RunBatch processes a batch of items. On partial failure, it returns the items that failed alongside the Error.
+	RunBatch(batch []T) ([]T, Error)
+}
+
+// BatchErrorPolicy defines what happens to the failed items of a batch, when the Worker could not handle the Error.
+type BatchErrorPolicy int
+
+const (
+	// BatchErrorPolicyDrop drops the failed items.
+	BatchErrorPolicyDrop BatchErrorPolicy = iota
+	// BatchErrorPolicyRequeue sends the failed items back to the Queue. Items are dropped if the Queue is full.
+	BatchErrorPolicyRequeue
+)
+
+// WorkerPoolStrategyRunBatchLoop returns a WorkerPoolStrategy feeding batches from the Batcher to the receptors of
+// the pool. Receptors must implement BatchReceptor[T].
+//
+// Batches go through the Strategy of the Worker, so its middlewares apply to RunBatch: the Strategy runs an adapter
+// over the receptor, whose Run method calls RunBatch. Strategies running the receptor out of process, such as
+// SubprocessStrategy, cannot run batches and return an Error.
+//
+// When a batch partially fails, the Error is first passed to Worker.HandleError. If the Worker handles it, the failed
+// items are considered processed. Otherwise, the failed items are dropped or requeued according to the policy.
+//
+// The loop of a worker stops and returns the Error if the worker cannot be spawned.
+func WorkerPoolStrategyRunBatchLoop[T any](batcher *Batcher[T], policy BatchErrorPolicy) WorkerPoolStrategy {
+	return WorkerPoolStrategyFunc(func(ctx context.Context, h WorkerHandle) Error {
+		p, i := h.Pool(), h.Index()
+		LogDebugf(p, LogOperationRun, LogStatusProgress, "starting batch loop for worker-%d", i)
+
+		for ctx.Err() == nil {
+			w, err := h.Worker()
+			if err != nil {
+				LogErrorf(p, LogOperationRun, LogStatusFailed, "cannot spawn worker-%d; stopping batch loop", i)
+				return err
+			}
+
+			receptor, ok := w.Receptor.(BatchReceptor[T])
+			if !ok {
+				LogErrorf(p, LogOperationRun, LogStatusFailed, "receptor of worker-%d does not implement BatchReceptor", i)
+				return NewError(
+					"RuntimeError",
+					fmt.Sprintf("receptor should implement BatchReceptor; got: %T", w.Receptor),
+					nil,
+				)
+			}
+
+			batch, ok := batcher.Next(ctx)
+			if !ok {
+				return nil
+			}
+
+			h.Report(runBatch(h, w, receptor, batcher.Queue, batch, policy))
+		}
+		return nil
+	})
+}
+
+func runBatch[T any](h WorkerHandle, w *Worker, receptor BatchReceptor[T], q Queue[T], batch []T, policy BatchErrorPolicy) Error {
+	p, i := h.Pool(), h.Index()
+	LogDebugf(p, LogOperationRun, LogStatusProgress, "running batch of %d items on worker-%d", len(batch), i)
+
+	runtime := &batchRuntime[T]{BatchReceptor: receptor, batch: batch, mutex: &sync.Mutex{}}
+	err := w.run(runtime)
+	if err == nil {
+		return nil
+	}
+
+	if err = w.HandleError(err); err == nil {
+		return nil
+	}
+
+	failed, ran := runtime.result()
+	if !ran {
+		// the Strategy failed before running the batch, or gave up waiting for it
+		failed = batch
+	}
+	LogDebugf(p, LogOperationRun, LogStatusProgress, "%d items of batch failed on worker-%d; %v", len(failed), i, err)
+	p.HandleError(err)
+
+	subErrors := []Error{err}
+	if policy == BatchErrorPolicyRequeue {
+		for _, item := range failed {
+			if requeueErr := q.TrySend(item, 0); requeueErr != nil {
+				subErrors = append(subErrors, requeueErr)
+			}
+		}
+	}
+
+	return NewError(
+		"BatchError",
+		fmt.Sprintf("%d out of %d items failed on worker-%d", len(failed), len(batch), i),
+		subErrors,
+	)
+}
+
+// batchRuntime is a Runtime running a batch on a BatchReceptor, so the batch goes through the Strategy of a Worker.
+// Other methods are those of the receptor.
+type batchRuntime[T any] struct {
+	BatchReceptor[T]
+	batch []T
+
+	// ran is true once RunBatch returned, failed holds the items it returned
+	ran    bool
+	failed []T
+	mutex  *sync.Mutex
+}
+
+func (r *batchRuntime[T]) Run() Error {
+	failed, err := r.BatchReceptor.RunBatch(r.batch)
+	r.mutex.Lock()
+	r.ran, r.failed = true, failed
+	r.mutex.Unlock()
+	return err
+}
+
+// result returns the failed items, and false if RunBatch did not return yet.
+func (r *batchRuntime[T]) result() ([]T, bool) {
+	r.mutex.Lock()
+	defer r.mutex.Unlock()
+	return r.failed, r.ran
+}
+
+func (r *batchRuntime[T]) GetContext() context.Context {
+	return RuntimeContext(r.BatchReceptor)
+}
+
+// isBatchRuntime marks the runtimes whose Run method runs a batch.
+func (r *batchRuntime[T]) isBatchRuntime() {}
+
+// batchRunner is implemented by batchRuntime, whatever the type of its items.
+type batchRunner interface {
+	isBatchRuntime()
+}
@@ -0,0 +1,93 @@
+/*
+Copyright 2023 Alexandre Mahdhaoui
+
+Licensed under the Apache License, Version 2.0 (the "License");
+you may not use this file except in compliance with the License.
+You may obtain a copy of the License at
+
+	http://www.apache.org/licenses/LICENSE-2.0
+
+Unless required by applicable law or agreed to in writing, software
+distributed under the License is distributed on an "AS IS" BASIS,
+WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+See the License for the specific language governing permissions and
+limitations under the License.
+*/
+
+package bda
+
+import (
+	"context"
+	"testing"
+	"time"
+)
+
+func TestBatcherNextAccountsForDequeues(t *testing.T) {
+	q := DefaultQueueWithCapacity[int]("queue", context.Background(), 10)
+	if err := q.Init(); err != nil {
+		t.Fatalf("Init() error = %v", err)
+	}
+	for i := 0; i < 5; i++ {
+		if err := q.Send(i); err != nil {
+			t.Fatalf("Send() error = %v", err)
+		}
+	}
+
+	b := DefaultBatcher(q, 3, 10*time.Millisecond)
+	if batch, ok := b.Next(context.Background()); !ok || len(batch) != 3 {
+		t.Fatalf("Next() = %v, %t; want 3 items", batch, ok)
+	}
+	if batch, ok := b.Next(context.Background()); !ok || len(batch) != 2 {
+		t.Fatalf("Next() = %v, %t; want 2 items", batch, ok)
+	}
+	if got := q.Stats().Dequeued; got != 5 {
+		t.Fatalf("Stats().Dequeued = %d; want 5", got)
+	}
+
+	ctx, cancel := context.WithTimeout(context.Background(), 10*time.Millisecond)
+	defer cancel()
+	if batch, ok := b.Next(ctx); ok {
+		t.Fatalf("Next() = %v, %t; want false once the context is done", batch, ok)
+	}
+}
+
+func TestRunBatchGoesThroughTheWorkerStrategy(t *testing.T) {
+	q := DefaultQueueWithCapacity[int]("queue", context.Background(), 10)
+	if err := q.Init(); err != nil {
+		t.Fatalf("Init() error = %v", err)
+	}
+	metrics := DefaultStrategyMetrics()
+	// the receptor fails the odd items of every batch
+	receptor := &fakeRuntime{name: "receptor", runBatch: func(batch []int) ([]int, Error) {
+		var failed []int
+		for _, item := range batch {
+			if item%2 == 1 {
+				failed = append(failed, item)
+			}
+		}
+		if len(failed) == 0 {
+			return nil, nil
+		}
+		return failed, NewError("TestError", "odd items", nil)
+	}}
+	w := &Worker{
+		Name:     "worker",
+		Strategy: ChainStrategy(DefaultStrategy(), MetricsStrategyMiddleware(metrics)),
+		Receptor: receptor,
+		Logger:   DefaultLogger(),
+		Context:  context.Background(),
+	}
+	h := &countingWorkerHandle{pool: &WorkerPool{Name: "pool"}}
+
+	err := runBatch[int](h, w, receptor, q, []int{1, 2, 3}, BatchErrorPolicyRequeue)
+	if err == nil || err.Type != "BatchError" {
+		t.Fatalf("runBatch() error = %v; want BatchError", err)
+	}
+	if got := metrics.Snapshot()[LogOperationRun]; got.Calls != 1 || got.Failures != 1 {
+		t.Fatalf("run metrics = %+v; want 1 failed call", got)
+	}
+	// the odd items failed and were requeued
+	if got := q.Len(); got != 2 {
+		t.Fatalf("Len() = %d; want 2 requeued items", got)
+	}
+}
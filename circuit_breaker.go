@@ -0,0 +1,365 @@
+/*
+Copyright 2023 Alexandre Mahdhaoui
+
+Licensed under the Apache License, Version 2.0 (the "License");
+you may not use this file except in compliance with the License.
+You may obtain a copy of the License at
+
+	http://www.apache.org/licenses/LICENSE-2.0
+
+Unless required by applicable law or agreed to in writing, software
+distributed under the License is distributed on an "AS IS" BASIS,
+WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+See the License for the specific language governing permissions and
+limitations under the License.
+*/
+
+package bda
+
+import (
+	"context"
+	"fmt"
+	"sync"
+	"time"
+)
+
+const (
+	// ErrorTypeCircuitOpen is the type of the Error returned when a CircuitBreaker rejects a call.
+	ErrorTypeCircuitOpen ErrorType = "CircuitOpenError"
+
+	DefaultCircuitFailureRateThreshold = 0.5
+	DefaultCircuitWindow               = 60 * time.Second
+	DefaultCircuitMinRequests          = 10
+	DefaultCircuitOpenTimeout          = 30 * time.Second
+	DefaultCircuitHalfOpenProbes       = 1
+
+	// circuitWindowBuckets is the number of buckets the sliding window is divided into.
+	circuitWindowBuckets = 10
+)
+
+type CircuitState string
+
+const (
+	// CircuitClosed lets calls through and records their outcome.
+	CircuitClosed CircuitState = "closed"
+	// CircuitOpen rejects calls until OpenTimeout elapsed.
+	CircuitOpen CircuitState = "open"
+	// CircuitHalfOpen lets up to HalfOpenProbes calls through: the circuit closes if they all succeed, and opens again
+	// if one fails.
+	CircuitHalfOpen CircuitState = "half-open"
+)
+
+// CircuitBreakerMetrics is a snapshot of the metrics of a CircuitBreaker.
+type CircuitBreakerMetrics struct {
+	State CircuitState
+	// Requests and Failures are counted over the sliding window.
+	Requests    int
+	Failures    int
+	FailureRate float64
+	// Rejected is the number of calls rejected since the creation of the CircuitBreaker.
+	Rejected uint64
+	// Opened is the number of times the circuit opened since the creation of the CircuitBreaker.
+	Opened uint64
+}
+
+// circuitBucket counts the outcomes of the calls made during a slice of the sliding window.
+type circuitBucket struct {
+	start    time.Time
+	requests int
+	failures int
+}
+
+// CircuitBreaker stops calling a failing dependency. While closed, it records the outcome of calls over a sliding
+// Window, and opens when the failure rate reaches FailureRateThreshold over at least MinRequests calls. While open,
+// calls are rejected with an Error of type ErrorTypeCircuitOpen. After OpenTimeout, the circuit is half-open and lets
+// HalfOpenProbes calls through to decide whether to close or open again.
+//
+// A CircuitBreaker may be built as a struct literal: fields that are not positive take their default value on first
+// use, e.g. DefaultCircuitHalfOpenProbes for HalfOpenProbes.
+type CircuitBreaker struct {
+	Name                 string
+	FailureRateThreshold float64
+	Window               time.Duration
+	MinRequests          int
+	OpenTimeout          time.Duration
+	HalfOpenProbes       int
+	// OnStateChange is called outside the lock of the breaker when its state changes. It may be nil.
+	OnStateChange func(from, to CircuitState)
+
+	state CircuitState
+	// generation is incremented on each state change. Outcomes are only recorded if the state did not change since
+	// their call was allowed.
+	generation uint64
+	openedAt   time.Time
+	buckets    []circuitBucket
+	// probes is the number of calls let through while half-open, and successes the number that succeeded.
+	probes    int
+	successes int
+	rejected  uint64
+	opened    uint64
+	mutex     *sync.Mutex
+	initOnce  sync.Once
+}
+
+// Execute calls fn if the circuit allows it, and records its outcome. A panic raised by fn is recorded as a failure,
+// then propagated.
+func (b *CircuitBreaker) Execute(fn func() Error) Error {
+	generation, err := b.Allow()
+	if err != nil {
+		return err
+	}
+	return b.call(generation, fn)
+}
+
+// call calls fn, allowed by Allow under generation, and records its outcome. Recording is deferred so that a panic
+// does not keep a half-open probe in flight forever.
+func (b *CircuitBreaker) call(generation uint64, fn func() Error) (err Error) {
+	completed := false
+	defer func() {
+		if !completed {
+			err = NewError(ErrorTypePanic, fmt.Sprintf("call through circuit breaker %s panicked", b.Name), nil)
+		}
+		b.Record(generation, err)
+	}()
+	err = fn()
+	completed = true
+	return err
+}
+
+// Allow returns an Error of type ErrorTypeCircuitOpen if the circuit rejects a call. Otherwise, it returns the
+// generation of the state the call is allowed under, and the caller must pass it to Record with the outcome of the
+// call.
+func (b *CircuitBreaker) Allow() (uint64, Error) {
+	b.init()
+	b.mutex.Lock()
+	from := b.state
+	if b.state == CircuitOpen && time.Since(b.openedAt) >= b.OpenTimeout {
+		b.setState(CircuitHalfOpen)
+	}
+
+	switch {
+	case b.state == CircuitOpen, b.state == CircuitHalfOpen && b.probes >= b.HalfOpenProbes:
+		b.rejected++
+		to := b.state
+		b.mutex.Unlock()
+		b.notify(from, to)
+		return 0, NewError(ErrorTypeCircuitOpen, fmt.Sprintf("circuit breaker %s is %s", b.Name, to), nil)
+	case b.state == CircuitHalfOpen:
+		b.probes++
+	}
+	to, generation := b.state, b.generation
+	b.mutex.Unlock()
+	b.notify(from, to)
+	return generation, nil
+}
+
+// Record records the outcome of a call allowed by Allow under generation. Outcomes of calls allowed before the last
+// state change are ignored, e.g. a call allowed while closed cannot close a half-open circuit.
+func (b *CircuitBreaker) Record(generation uint64, err Error) {
+	b.init()
+	b.mutex.Lock()
+	from := b.state
+	if generation != b.generation {
+		b.mutex.Unlock()
+		return
+	}
+
+	switch b.state {
+	case CircuitClosed:
+		bucket := b.bucket(time.Now())
+		bucket.requests++
+		if err != nil {
+			bucket.failures++
+		}
+		requests, failures := b.count(time.Now())
+		if requests >= b.MinRequests && float64(failures)/float64(requests) >= b.FailureRateThreshold {
+			b.setState(CircuitOpen)
+		}
+	case CircuitHalfOpen:
+		if err != nil {
+			b.setState(CircuitOpen)
+			break
+		}
+		b.successes++
+		if b.successes >= b.HalfOpenProbes {
+			b.setState(CircuitClosed)
+		}
+	}
+
+	to := b.state
+	b.mutex.Unlock()
+	b.notify(from, to)
+}
+
+// State returns the current state of the circuit.
+func (b *CircuitBreaker) State() CircuitState {
+	b.init()
+	b.mutex.Lock()
+	defer b.mutex.Unlock()
+	if b.state == CircuitOpen && time.Since(b.openedAt) >= b.OpenTimeout {
+		return CircuitHalfOpen
+	}
+	return b.state
+}
+
+// Metrics returns a snapshot of the metrics of the circuit.
+func (b *CircuitBreaker) Metrics() CircuitBreakerMetrics {
+	state := b.State()
+	b.mutex.Lock()
+	defer b.mutex.Unlock()
+	requests, failures := b.count(time.Now())
+	metrics := CircuitBreakerMetrics{
+		State:    state,
+		Requests: requests,
+		Failures: failures,
+		Rejected: b.rejected,
+		Opened:   b.opened,
+	}
+	if requests > 0 {
+		metrics.FailureRate = float64(failures) / float64(requests)
+	}
+	return metrics
+}
+
+// Health returns an Error of type ErrorTypeCircuitOpen while the circuit is open, and nil otherwise.
+func (b *CircuitBreaker) Health() Error {
+	if state := b.State(); state == CircuitOpen {
+		return NewError(ErrorTypeCircuitOpen, fmt.Sprintf("circuit breaker %s is %s", b.Name, state), nil)
+	}
+	return nil
+}
+
+// retryIn returns the time left before an open circuit becomes half-open.
+func (b *CircuitBreaker) retryIn() time.Duration {
+	b.init()
+	b.mutex.Lock()
+	defer b.mutex.Unlock()
+	if b.state != CircuitOpen {
+		return 0
+	}
+	return b.OpenTimeout - time.Since(b.openedAt)
+}
+
+// setState changes the state of the circuit. The mutex must be held.
+func (b *CircuitBreaker) setState(state CircuitState) {
+	switch state {
+	case CircuitOpen:
+		b.openedAt = time.Now()
+		b.opened++
+	case CircuitHalfOpen:
+		b.probes, b.successes = 0, 0
+	case CircuitClosed:
+		for i := range b.buckets {
+			b.buckets[i] = circuitBucket{}
+		}
+	}
+	b.state = state
+	b.generation++
+}
+
+// init applies the defaults of the fields that are not positive, so a CircuitBreaker may be built as a struct
+// literal.
+func (b *CircuitBreaker) init() {
+	b.initOnce.Do(func() {
+		if !(b.FailureRateThreshold > 0) {
+			b.FailureRateThreshold = DefaultCircuitFailureRateThreshold
+		}
+		b.Window = positiveOrDefault(b.Window, DefaultCircuitWindow)
+		if b.MinRequests < 1 {
+			b.MinRequests = DefaultCircuitMinRequests
+		}
+		b.OpenTimeout = positiveOrDefault(b.OpenTimeout, DefaultCircuitOpenTimeout)
+		if b.HalfOpenProbes < 1 {
+			b.HalfOpenProbes = DefaultCircuitHalfOpenProbes
+		}
+		if b.state == "" {
+			b.state = CircuitClosed
+		}
+		if len(b.buckets) == 0 {
+			b.buckets = make([]circuitBucket, circuitWindowBuckets)
+		}
+		if b.mutex == nil {
+			b.mutex = &sync.Mutex{}
+		}
+	})
+}
+
+func (b *CircuitBreaker) notify(from, to CircuitState) {
+	if from != to && b.OnStateChange != nil {
+		b.OnStateChange(from, to)
+	}
+}
+
+// bucket returns the bucket of the sliding window for now, resetting it if it holds outdated counts.
+func (b *CircuitBreaker) bucket(now time.Time) *circuitBucket {
+	width := b.Window / circuitWindowBuckets
+	if width <= 0 {
+		width = 1
+	}
+	start := now.Truncate(width)
+	bucket := &b.buckets[int(start.UnixNano()/int64(width))%len(b.buckets)]
+	if !bucket.start.Equal(start) {
+		*bucket = circuitBucket{start: start}
+	}
+	return bucket
+}
+
+// count returns the number of requests and failures over the sliding window.
+func (b *CircuitBreaker) count(now time.Time) (int, int) {
+	requests, failures := 0, 0
+	for _, bucket := range b.buckets {
+		if now.Sub(bucket.start) < b.Window {
+			requests += bucket.requests
+			failures += bucket.failures
+		}
+	}
+	return requests, failures
+}
+
+// DefaultCircuitBreaker returns a new, closed CircuitBreaker with the default thresholds.
+func DefaultCircuitBreaker(name string) *CircuitBreaker {
+	return &CircuitBreaker{
+		Name:                 name,
+		FailureRateThreshold: DefaultCircuitFailureRateThreshold,
+		Window:               DefaultCircuitWindow,
+		MinRequests:          DefaultCircuitMinRequests,
+		OpenTimeout:          DefaultCircuitOpenTimeout,
+		HalfOpenProbes:       DefaultCircuitHalfOpenProbes,
+		state:                CircuitClosed,
+		buckets:              make([]circuitBucket, circuitWindowBuckets),
+		mutex:                &sync.Mutex{},
+	}
+}
+
+// WorkerPoolStrategyCircuitBreaker returns a WorkerPoolStrategy running each worker in a loop, like
+// WorkerPoolStrategyRunLoop, through the CircuitBreaker. Share the CircuitBreaker between the workers of a pool so a
+// failing dependency stops them all: while the circuit is open, workers wait instead of running and respawning.
+func WorkerPoolStrategyCircuitBreaker(breaker *CircuitBreaker) WorkerPoolStrategy {
+	return WorkerPoolStrategyFunc(func(ctx context.Context, h WorkerHandle) Error {
+		p, i := h.Pool(), h.Index()
+		LogDebugf(p, LogOperationRun, LogStatusProgress, "starting circuit breaker loop for worker-%d", i)
+
+		for ctx.Err() == nil {
+			generation, err := breaker.Allow()
+			if err != nil {
+				delay := breaker.retryIn()
+				if delay <= 0 {
+					// half-open with every probe in flight
+					delay = rateLimitRetryDelay
+				}
+				LogDebugf(p, LogOperationRun, LogStatusProgress, "worker-%d short-circuited; retrying in %s", i, delay)
+
+				timer := time.NewTimer(delay)
+				select {
+				case <-ctx.Done():
+					timer.Stop()
+					return nil
+				case <-timer.C:
+				}
+				continue
+			}
+			h.Report(breaker.call(generation, h.RunOnce))
+		}
+		return nil
+	})
+}
@@ -0,0 +1,68 @@
+/*
+Copyright 2023 Alexandre Mahdhaoui
+
+Licensed under the Apache License, Version 2.0 (the "License");
+you may not use this file except in compliance with the License.
+You may obtain a copy of the License at
+
+	http://www.apache.org/licenses/LICENSE-2.0
+
+Unless required by applicable law or agreed to in writing, software
+distributed under the License is distributed on an "AS IS" BASIS,
+WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+See the License for the specific language governing permissions and
+limitations under the License.
+*/
+
+package bda
+
+import (
+	"testing"
+	"time"
+)
+
+func TestCircuitBreakerStructLiteral(t *testing.T) {
+	b := &CircuitBreaker{Name: "breaker", MinRequests: 1, OpenTimeout: time.Millisecond}
+
+	failure := NewError("TestError", "failed", nil)
+	if err := b.Execute(func() Error { return failure }); err != failure {
+		t.Fatalf("Execute() error = %v; want %v", err, failure)
+	}
+	if state := b.State(); state != CircuitOpen {
+		t.Fatalf("State() = %s; want %s", state, CircuitOpen)
+	}
+
+	// HalfOpenProbes defaults to 1: a successful probe closes the circuit
+	time.Sleep(2 * time.Millisecond)
+	if err := b.Execute(func() Error { return nil }); err != nil {
+		t.Fatalf("Execute() error = %v", err)
+	}
+	if state := b.State(); state != CircuitClosed {
+		t.Fatalf("State() = %s; want %s", state, CircuitClosed)
+	}
+}
+
+func TestCircuitBreakerIgnoresOutcomesOfPreviousStates(t *testing.T) {
+	b := DefaultCircuitBreaker("breaker")
+	b.MinRequests, b.OpenTimeout = 1, time.Millisecond
+
+	// a slow call is allowed while closed
+	slow, err := b.Allow()
+	if err != nil {
+		t.Fatalf("Allow() error = %v", err)
+	}
+
+	// meanwhile, the circuit opens, then becomes half-open
+	generation, _ := b.Allow()
+	b.Record(generation, NewError("TestError", "failed", nil))
+	time.Sleep(2 * time.Millisecond)
+	if _, err := b.Allow(); err != nil {
+		t.Fatalf("Allow() error = %v; want a half-open probe", err)
+	}
+
+	// the slow call succeeds: it was allowed while closed, so it does not close the circuit
+	b.Record(slow, nil)
+	if state := b.State(); state != CircuitHalfOpen {
+		t.Fatalf("State() = %s; want %s", state, CircuitHalfOpen)
+	}
+}
@@ -20,9 +20,34 @@ import (
 	"context"
 	"fmt"
 	"sync"
+	"sync/atomic"
 	"time"
 )
 
+//----------------------------------------------------------------------------------------------------------------------
+//- Locks
+
+// rwLocker is implemented by *sync.RWMutex. Data structures are guarded by a rwLocker, so they can be instantiated
+// either with a read-write mutex, or with a plain mutex through mutexLocker.
+type rwLocker interface {
+	sync.Locker
+	RLock()
+	RUnlock()
+}
+
+// mutexLocker adapts a *sync.Mutex to the rwLocker interface: read locks are exclusive.
+type mutexLocker struct {
+	*sync.Mutex
+}
+
+func (l mutexLocker) RLock() {
+	l.Lock()
+}
+
+func (l mutexLocker) RUnlock() {
+	l.Unlock()
+}
+
 //----------------------------------------------------------------------------------------------------------------------
 //- Map
 
@@ -31,19 +56,39 @@ type Map[T comparable, I any] interface {
 	Get(key T) (I, bool)
 	// Set method sets a value for a key and returns the key and value as a tuple
 	Set(key T, value I) (T, I)
+	// Delete method deletes a key and returns its previous value and a boolean indicating if the key existed
+	Delete(key T) (I, bool)
+	// Len method returns the number of keys in the map
+	Len() int
+	// Range method calls f for each key and value of a snapshot of the map, until f returns false.
+	// f may safely call other methods of the map.
+	Range(f func(key T, value I) bool)
+	// Snapshot method returns a copy of the map
+	Snapshot() map[T]I
+	// LoadOrStore method returns the existing value for the key if present. Otherwise, it stores and returns the given
+	// value. The boolean is true if the value was loaded, false if stored.
+	LoadOrStore(key T, value I) (I, bool)
+	// CompareAndSwap method swaps the value for a key if the current value is equal to old, and returns true if it did.
+	// Like sync.Map, it panics if the values are not comparable.
+	CompareAndSwap(key T, old, new I) bool
+	// Compute method atomically updates the value for a key. fn receives the current value and a boolean indicating if
+	// the key exists, and returns the new value and a boolean indicating if the key should be kept; returning false
+	// deletes the key. Compute returns the new value and whether the key is present.
+	// fn must not call methods of the map.
+	Compute(key T, fn func(value I, ok bool) (I, bool)) (I, bool)
 }
 
 // The inMemoryMap struct is a generic type that holds a map and a mutex for concurrent access.
 type inMemoryMap[T comparable, I any] struct {
 	store map[T]I
-	mutex *sync.Mutex
+	mutex rwLocker
 }
 
 // Get method returns the value and a boolean indicating if the key exists in the map
 func (m *inMemoryMap[T, I]) Get(key T) (I, bool) {
-	m.mutex.Lock()
+	m.mutex.RLock()
 	value, ok := m.store[key]
-	m.mutex.Unlock()
+	m.mutex.RUnlock()
 	return value, ok
 }
 
@@ -55,12 +100,95 @@ func (m *inMemoryMap[T, I]) Set(key T, value I) (T, I) {
 	return key, value
 }
 
+// Delete method deletes a key and returns its previous value and a boolean indicating if the key existed
+func (m *inMemoryMap[T, I]) Delete(key T) (I, bool) {
+	m.mutex.Lock()
+	value, ok := m.store[key]
+	delete(m.store, key)
+	m.mutex.Unlock()
+	return value, ok
+}
+
+// Len method returns the number of keys in the map
+func (m *inMemoryMap[T, I]) Len() int {
+	m.mutex.RLock()
+	length := len(m.store)
+	m.mutex.RUnlock()
+	return length
+}
+
+// Range method calls f for each key and value of a snapshot of the map, until f returns false.
+func (m *inMemoryMap[T, I]) Range(f func(key T, value I) bool) {
+	for key, value := range m.Snapshot() {
+		if !f(key, value) {
+			return
+		}
+	}
+}
+
+// Snapshot method returns a copy of the map
+func (m *inMemoryMap[T, I]) Snapshot() map[T]I {
+	m.mutex.RLock()
+	snapshot := make(map[T]I, len(m.store))
+	for key, value := range m.store {
+		snapshot[key] = value
+	}
+	m.mutex.RUnlock()
+	return snapshot
+}
+
+// LoadOrStore method returns the existing value for the key if present. Otherwise, it stores and returns the given value.
+func (m *inMemoryMap[T, I]) LoadOrStore(key T, value I) (I, bool) {
+	m.mutex.Lock()
+	defer m.mutex.Unlock()
+	if actual, ok := m.store[key]; ok {
+		return actual, true
+	}
+	m.store[key] = value
+	return value, false
+}
+
+// CompareAndSwap method swaps the value for a key if the current value is equal to old.
+func (m *inMemoryMap[T, I]) CompareAndSwap(key T, old, new I) bool {
+	m.mutex.Lock()
+	defer m.mutex.Unlock()
+	if current, ok := m.store[key]; !ok || any(current) != any(old) {
+		return false
+	}
+	m.store[key] = new
+	return true
+}
+
+// Compute method atomically updates the value for a key.
+func (m *inMemoryMap[T, I]) Compute(key T, fn func(value I, ok bool) (I, bool)) (I, bool) {
+	m.mutex.Lock()
+	defer m.mutex.Unlock()
+	current, ok := m.store[key]
+	value, keep := fn(current, ok)
+	if !keep {
+		delete(m.store, key)
+		var null I
+		return null, false
+	}
+	m.store[key] = value
+	return value, true
+}
+
 // DefaultMap function returns a new inMemoryMap with an initialized map and a mutex
 func DefaultMap[T comparable, I any]() Map[T, I] {
+	return newInMemoryMap[T, I](mutexLocker{&sync.Mutex{}})
+}
+
+// DefaultRWMap function returns a new inMemoryMap guarded by a read-write mutex, for read-heavy workloads
+func DefaultRWMap[T comparable, I any]() Map[T, I] {
+	return newInMemoryMap[T, I](&sync.RWMutex{})
+}
+
+func newInMemoryMap[T comparable, I any](mutex rwLocker) *inMemoryMap[T, I] {
 	store := make(map[T]I)
 	return &inMemoryMap[T, I]{
 		store: store,
-		mutex: &sync.Mutex{},
+		mutex: mutex,
 	}
 }
 
@@ -76,19 +204,29 @@ type Set[T comparable] interface {
 	// Returns true, if the key was not already set & sets it.
 	// Else return false.
 	TrySet(key T) bool
+	// Remove method removes a key from the Set.
+	// Returns true, if the key was set.
+	Remove(key T) bool
+	// Len method returns the number of keys in the Set
+	Len() int
+	// Range method calls f for each key of a snapshot of the Set, until f returns false.
+	// f may safely call other methods of the Set.
+	Range(f func(key T) bool)
+	// Values method returns a snapshot of the keys of the Set
+	Values() []T
 }
 
 // The inMemorySet struct is a generic type that holds a map and a mutex for concurrent access
 type inMemorySet[T comparable] struct {
 	store map[T]interface{}
-	mutex *sync.Mutex
+	mutex rwLocker
 }
 
 // Exist method checks if a key exists in the inMemorySet
 func (set *inMemorySet[T]) Exist(key T) bool {
-	set.mutex.Lock()
+	set.mutex.RLock()
 	_, ok := set.store[key]
-	set.mutex.Unlock()
+	set.mutex.RUnlock()
 	return ok
 }
 
@@ -113,13 +251,117 @@ func (set *inMemorySet[T]) TrySet(key T) bool {
 	return true
 }
 
+// Remove method removes a key from the inMemorySet.
+// Returns true, if the key was set.
+func (set *inMemorySet[T]) Remove(key T) bool {
+	set.mutex.Lock()
+	_, ok := set.store[key]
+	delete(set.store, key)
+	set.mutex.Unlock()
+	return ok
+}
+
+// Len method returns the number of keys in the inMemorySet
+func (set *inMemorySet[T]) Len() int {
+	set.mutex.RLock()
+	length := len(set.store)
+	set.mutex.RUnlock()
+	return length
+}
+
+// Range method calls f for each key of a snapshot of the inMemorySet, until f returns false.
+func (set *inMemorySet[T]) Range(f func(key T) bool) {
+	for _, key := range set.Values() {
+		if !f(key) {
+			return
+		}
+	}
+}
+
+// Values method returns a snapshot of the keys of the inMemorySet
+func (set *inMemorySet[T]) Values() []T {
+	set.mutex.RLock()
+	values := make([]T, 0, len(set.store))
+	for key := range set.store {
+		values = append(values, key)
+	}
+	set.mutex.RUnlock()
+	return values
+}
+
 // DefaultSet function returns a new inMemorySet with an initialized map and a mutex
 func DefaultSet[T comparable]() Set[T] {
+	return newInMemorySet[T](mutexLocker{&sync.Mutex{}})
+}
+
+// DefaultRWSet function returns a new inMemorySet guarded by a read-write mutex, for read-heavy workloads
+func DefaultRWSet[T comparable]() Set[T] {
+	return newInMemorySet[T](&sync.RWMutex{})
+}
+
+func newInMemorySet[T comparable](mutex rwLocker) *inMemorySet[T] {
 	store := make(map[T]interface{})
 	return &inMemorySet[T]{
 		store: store,
-		mutex: &sync.Mutex{},
+		mutex: mutex,
+	}
+}
+
+// SetUnion function returns a new Set holding the keys present in any of the sets
+func SetUnion[T comparable](sets ...Set[T]) Set[T] {
+	result := DefaultSet[T]()
+	for _, s := range sets {
+		s.Range(func(key T) bool {
+			result.Set(key)
+			return true
+		})
 	}
+	return result
+}
+
+// SetIntersection function returns a new Set holding the keys present in every set
+func SetIntersection[T comparable](sets ...Set[T]) Set[T] {
+	result := DefaultSet[T]()
+	if len(sets) == 0 {
+		return result
+	}
+	sets[0].Range(func(key T) bool {
+		for _, s := range sets[1:] {
+			if !s.Exist(key) {
+				return true
+			}
+		}
+		result.Set(key)
+		return true
+	})
+	return result
+}
+
+// SetDifference function returns a new Set holding the keys of a that are not present in b
+func SetDifference[T comparable](a, b Set[T]) Set[T] {
+	result := DefaultSet[T]()
+	a.Range(func(key T) bool {
+		if !b.Exist(key) {
+			result.Set(key)
+		}
+		return true
+	})
+	return result
+}
+
+// SetSymmetricDifference function returns a new Set holding the keys present in exactly one of a and b
+func SetSymmetricDifference[T comparable](a, b Set[T]) Set[T] {
+	return SetUnion(SetDifference(a, b), SetDifference(b, a))
+}
+
+// SetIsSubset function returns true if every key of a is present in b
+func SetIsSubset[T comparable](a, b Set[T]) bool {
+	subset := true
+	a.Range(func(key T) bool {
+		subset = b.Exist(key)
+		return subset
+	})
+	return subset
 }
 
 //----------------------------------------------------------------------------------------------------------------------
@@ -129,7 +371,20 @@ type SafeArray[T any] interface {
 	Append(item T)
 	Get(i int) (T, bool)
 	Set(i int, item T) bool
+	// Slice method returns a new SafeArray holding the items from start (inclusive) to end (exclusive).
+	// A negative stepInterval iterates backward, e.g. Slice(Length()-1, -1, -1) reverses the array.
 	Slice(start, end int, stepInterval ...int) SafeArray[T]
+	// Remove method removes the item at index i, shifting subsequent items to the left.
+	// Returns the removed item and false if i is out of range.
+	Remove(i int) (T, bool)
+	// Insert method inserts an item at index i, shifting subsequent items to the right. i may be equal to Length().
+	// Returns false if i is out of range.
+	Insert(i int, item T) bool
+	// Range method calls f for each index and item of a snapshot of the array, until f returns false.
+	// f may safely call other methods of the array.
+	Range(f func(i int, item T) bool)
+	// Snapshot method returns a copy of the underlying slice
+	Snapshot() []T
 
 	Length() int
 }
@@ -147,50 +402,72 @@ func (a *inMemorySafeArray[T]) Append(item T) {
 
 func (a *inMemorySafeArray[T]) Get(i int) (T, bool) {
 	a.mutex.Lock()
-	if i > len(a.array) {
-		var null T
-		a.mutex.Unlock()
-		return null, false
-	}
-	item := a.array[i]
-	a.mutex.Unlock()
-	return item, true
+	defer a.mutex.Unlock()
+	return getItem(a.array, i)
 }
 
 func (a *inMemorySafeArray[T]) Set(i int, item T) bool {
 	a.mutex.Lock()
-	if i > len(a.array) {
-		a.mutex.Unlock()
+	defer a.mutex.Unlock()
+	if i < 0 || i >= len(a.array) {
 		return false
 	}
 	a.array[i] = item
-	a.mutex.Unlock()
 	return true
 }
 
 func (a *inMemorySafeArray[T]) Slice(start, end int, step ...int) SafeArray[T] {
-	var stepInterval int
-	if len(step) < 1 {
-		stepInterval = 1
-	} else {
-		stepInterval = step[0]
-	}
-	arr := make([]T, 0)
 	a.mutex.Lock()
-	for i := start; i != end; i += stepInterval {
-		if item, ok := a.Get(i); ok {
-			arr = append(arr, item)
-		} else {
-			break
-		}
-	}
+	arr := sliceItems(a.array, start, end, step...)
+	a.mutex.Unlock()
 
-	newSafeArray := &inMemorySafeArray[T]{
+	return &inMemorySafeArray[T]{
 		array: arr,
 		mutex: &sync.Mutex{},
 	}
-	a.mutex.Unlock()
-	return newSafeArray
+}
+
+func (a *inMemorySafeArray[T]) Remove(i int) (T, bool) {
+	a.mutex.Lock()
+	defer a.mutex.Unlock()
+	item, ok := getItem(a.array, i)
+	if !ok {
+		return item, false
+	}
+	var null T
+	copy(a.array[i:], a.array[i+1:])
+	a.array[len(a.array)-1] = null
+	a.array = a.array[:len(a.array)-1]
+	return item, true
+}
+
+func (a *inMemorySafeArray[T]) Insert(i int, item T) bool {
+	a.mutex.Lock()
+	defer a.mutex.Unlock()
+	if i < 0 || i > len(a.array) {
+		return false
+	}
+	var null T
+	a.array = append(a.array, null)
+	copy(a.array[i+1:], a.array[i:])
+	a.array[i] = item
+	return true
+}
+
+func (a *inMemorySafeArray[T]) Range(f func(i int, item T) bool) {
+	for i, item := range a.Snapshot() {
+		if !f(i, item) {
+			return
+		}
+	}
+}
+
+func (a *inMemorySafeArray[T]) Snapshot() []T {
+	a.mutex.Lock()
+	defer a.mutex.Unlock()
+	snapshot := make([]T, len(a.array))
+	copy(snapshot, a.array)
+	return snapshot
 }
 
 func (a *inMemorySafeArray[T]) Length() int {
@@ -211,13 +488,188 @@ func DefaultSafeArrayWithSize[T any](size int) SafeArray[T] {
 	}
 }
 
+// getItem returns the item at index i, and false if i is out of range.
+func getItem[T any](array []T, i int) (T, bool) {
+	if i < 0 || i >= len(array) {
+		var null T
+		return null, false
+	}
+	return array[i], true
+}
+
+// sliceItems returns a new slice holding the items of array from start (inclusive) to end (exclusive) every step.
+// Iteration stops at the first index out of range.
+func sliceItems[T any](array []T, start, end int, step ...int) []T {
+	stepInterval := 1
+	if len(step) > 0 {
+		stepInterval = step[0]
+	}
+
+	arr := make([]T, 0)
+	if stepInterval == 0 {
+		return arr
+	}
+	for i := start; (stepInterval > 0 && i < end) || (stepInterval < 0 && i > end); i += stepInterval {
+		item, ok := getItem(array, i)
+		if !ok {
+			break
+		}
+		arr = append(arr, item)
+	}
+	return arr
+}
+
+// cowSafeArray is a copy-on-write SafeArray: reads access an immutable snapshot without locking, while writes copy
+// the whole array. It suits read-heavy workloads with rare writes, such as worker registries.
+type cowSafeArray[T any] struct {
+	array *atomic.Pointer[[]T]
+	// mutex serializes writers
+	mutex *sync.Mutex
+}
+
+func (a *cowSafeArray[T]) load() []T {
+	return *a.array.Load()
+}
+
+// update replaces the array with the result of f, called with a copy of the current array.
+func (a *cowSafeArray[T]) update(f func(array []T) ([]T, bool)) bool {
+	a.mutex.Lock()
+	defer a.mutex.Unlock()
+	current := a.load()
+	next := make([]T, len(current), len(current)+1)
+	copy(next, current)
+	next, ok := f(next)
+	if ok {
+		a.array.Store(&next)
+	}
+	return ok
+}
+
+func (a *cowSafeArray[T]) Append(item T) {
+	a.update(func(array []T) ([]T, bool) {
+		return append(array, item), true
+	})
+}
+
+func (a *cowSafeArray[T]) Get(i int) (T, bool) {
+	return getItem(a.load(), i)
+}
+
+func (a *cowSafeArray[T]) Set(i int, item T) bool {
+	return a.update(func(array []T) ([]T, bool) {
+		if i < 0 || i >= len(array) {
+			return array, false
+		}
+		array[i] = item
+		return array, true
+	})
+}
+
+func (a *cowSafeArray[T]) Slice(start, end int, step ...int) SafeArray[T] {
+	return newCOWSafeArray(sliceItems(a.load(), start, end, step...))
+}
+
+func (a *cowSafeArray[T]) Remove(i int) (T, bool) {
+	var item T
+	ok := a.update(func(array []T) ([]T, bool) {
+		var ok bool
+		if item, ok = getItem(array, i); !ok {
+			return array, false
+		}
+		return append(array[:i], array[i+1:]...), true
+	})
+	return item, ok
+}
+
+func (a *cowSafeArray[T]) Insert(i int, item T) bool {
+	return a.update(func(array []T) ([]T, bool) {
+		if i < 0 || i > len(array) {
+			return array, false
+		}
+		var null T
+		array = append(array, null)
+		copy(array[i+1:], array[i:])
+		array[i] = item
+		return array, true
+	})
+}
+
+func (a *cowSafeArray[T]) Range(f func(i int, item T) bool) {
+	for i, item := range a.load() {
+		if !f(i, item) {
+			return
+		}
+	}
+}
+
+func (a *cowSafeArray[T]) Snapshot() []T {
+	current := a.load()
+	snapshot := make([]T, len(current))
+	copy(snapshot, current)
+	return snapshot
+}
+
+func (a *cowSafeArray[T]) Length() int {
+	return len(a.load())
+}
+
+// DefaultCOWSafeArray returns a new copy-on-write SafeArray, for read-heavy workloads.
+func DefaultCOWSafeArray[T any]() SafeArray[T] {
+	return DefaultCOWSafeArrayWithSize[T](0)
+}
+
+// DefaultCOWSafeArrayWithSize returns a new copy-on-write SafeArray of the given size, for read-heavy workloads.
+func DefaultCOWSafeArrayWithSize[T any](size int) SafeArray[T] {
+	return newCOWSafeArray(make([]T, size))
+}
+
+func newCOWSafeArray[T any](array []T) *cowSafeArray[T] {
+	a := &cowSafeArray[T]{
+		array: &atomic.Pointer[[]T]{},
+		mutex: &sync.Mutex{},
+	}
+	a.array.Store(&array)
+	return a
+}
+
 //----------------------------------------------------------------------------------------------------------------------
 //- Leaser
 
-// Leaser interface provides methods to get and reset lease time for an ID.
+const (
+	// ErrorTypeLeaseHeld is the type of the Error returned when a lease is held by another owner.
+	ErrorTypeLeaseHeld ErrorType = "LeaseHeldError"
+	// ErrorTypeLeaseLost is the type of the Error returned when a lease expired or was acquired by another owner.
+	ErrorTypeLeaseLost ErrorType = "LeaseLostError"
+)
+
+// Lease describes the ownership of an ID by an owner until ExpiresAt.
+type Lease struct {
+	ID    string
+	Owner string
+	// Token is a fencing token. Tokens are monotonically increasing across acquisitions of a Leaser, so downstream
+	// systems can reject writes carrying a token older than the last one they saw.
+	Token     uint64
+	ExpiresAt time.Time
+}
+
+// Expired returns true if the lease expired.
+func (l Lease) Expired() bool {
+	return !time.Now().Before(l.ExpiresAt)
+}
+
+// Leaser interface provides methods to acquire, renew and release leases identified by an ID.
 type Leaser interface {
-	GetLease(id string) (time.Time, error)
-	ResetLease(id string, allegedLeaseTime time.Time) (time.Time, error)
+	// Acquire method acquires the lease for an ID on behalf of an owner, and returns it with a new fencing token.
+	// If the owner already holds the lease, it is renewed and keeps its token.
+	// Returns an Error if the lease is held by another owner.
+	Acquire(id, owner string) (Lease, Error)
+	// Renew method extends the lease for an ID, if the token matches the current holder's.
+	// Returns an Error if the lease expired or was acquired by another owner.
+	Renew(id string, token uint64) (Lease, Error)
+	// Release method releases the lease for an ID, if the token matches the current holder's.
+	Release(id string, token uint64) Error
+	// Holder method returns the current lease for an ID, and false if the lease is not held.
+	Holder(id string) (Lease, bool)
 }
 
 // LeaserBuilder interface provides a method to build a Leaser instance.
@@ -225,39 +677,115 @@ type LeaserBuilder interface {
 	Build() Leaser
 }
 
+// DefaultLeaseDuration is the lease duration used by LeaserBuilders when WithLeaseDuration is not provided.
+const DefaultLeaseDuration = 15 * time.Second
+
+// LeaserOption configures a LeaserBuilder.
+type LeaserOption func(*leaserOptions)
+
+type leaserOptions struct {
+	LeaseDuration time.Duration
+}
+
+// WithLeaseDuration sets the duration of the leases acquired or renewed by the built Leaser. Durations that are not
+// positive would produce leases that are already expired: they fall back to DefaultLeaseDuration.
+func WithLeaseDuration(d time.Duration) LeaserOption {
+	return func(o *leaserOptions) {
+		o.LeaseDuration = d
+	}
+}
+
+func newLeaserOptions(opts []LeaserOption) *leaserOptions {
+	o := &leaserOptions{
+		LeaseDuration: DefaultLeaseDuration,
+	}
+	for _, opt := range opts {
+		opt(o)
+	}
+	o.LeaseDuration = positiveOrDefault(o.LeaseDuration, DefaultLeaseDuration)
+	return o
+}
+
 // inMemoryLeaser is an implementation of the Leaser interface using an in-memory store.
 type inMemoryLeaser struct {
-	store         Map[string, time.Time]
+	store         Map[string, Lease]
 	LeaseDuration time.Duration
+	token         uint64
 	mutex         *sync.Mutex
 }
 
-// GetLease gets the lease time for an ID and sets it if not already set.
-func (l *inMemoryLeaser) GetLease(id string) (time.Time, error) {
+// Acquire acquires the lease for an ID if it is not held, expired, or already held by the owner.
+func (l *inMemoryLeaser) Acquire(id, owner string) (Lease, Error) {
+	return l.acquire(id, owner, l.LeaseDuration)
+}
+
+// Renew extends the lease for an ID if the token matches and the lease did not expire.
+func (l *inMemoryLeaser) Renew(id string, token uint64) (Lease, Error) {
+	return l.renew(id, token, l.LeaseDuration)
+}
+
+func (l *inMemoryLeaser) acquire(id, owner string, leaseDuration time.Duration) (Lease, Error) {
 	l.mutex.Lock()
-	if currentLeaseTime, ok := l.store.Get(id); ok {
-		if time.Now().Before(currentLeaseTime) {
-			l.mutex.Unlock()
-			return time.Time{}, fmt.Errorf("cannot get lease for id: %s", id)
+	defer l.mutex.Unlock()
+
+	current, ok := l.store.Get(id)
+	if ok && !current.Expired() {
+		if current.Owner != owner {
+			return Lease{}, NewError(ErrorTypeLeaseHeld, fmt.Sprintf("cannot acquire lease for id: %s; held by: %s", id, current.Owner), nil)
 		}
+		current.ExpiresAt = time.Now().Add(leaseDuration)
+		_, v := l.store.Set(id, current)
+		return v, nil
 	}
-	_, v := l.store.Set(id, time.Now().Local().Add(l.LeaseDuration))
-	l.mutex.Unlock()
+
+	l.token++
+	_, v := l.store.Set(id, Lease{
+		ID:        id,
+		Owner:     owner,
+		Token:     l.token,
+		ExpiresAt: time.Now().Add(leaseDuration),
+	})
 	return v, nil
 }
 
-// ResetLease resets the lease time for an ID if the current lease time matches the alleged lease time.
-func (l *inMemoryLeaser) ResetLease(id string, allegedLeaseTime time.Time) (time.Time, error) {
+func (l *inMemoryLeaser) renew(id string, token uint64, leaseDuration time.Duration) (Lease, Error) {
 	l.mutex.Lock()
-	if realLeaseTime, ok := l.store.Get(id); ok {
-		if realLeaseTime == allegedLeaseTime {
-			_, v := l.store.Set(id, time.Now().Local().Add(l.LeaseDuration))
-			l.mutex.Unlock()
-			return v, nil
-		}
+	defer l.mutex.Unlock()
+
+	current, ok := l.store.Get(id)
+	if !ok || current.Token != token || current.Expired() {
+		return Lease{}, NewError(ErrorTypeLeaseLost, fmt.Sprintf("cannot renew lease for id: %s; token: %d", id, token), nil)
+	}
+	current.ExpiresAt = time.Now().Add(leaseDuration)
+	_, v := l.store.Set(id, current)
+	return v, nil
+}
+
+// Release releases the lease for an ID if the token matches.
+func (l *inMemoryLeaser) Release(id string, token uint64) Error {
+	l.mutex.Lock()
+	defer l.mutex.Unlock()
+
+	current, ok := l.store.Get(id)
+	if !ok || current.Token != token {
+		return NewError(ErrorTypeLeaseLost, fmt.Sprintf("cannot release lease for id: %s; token: %d", id, token), nil)
 	}
-	l.mutex.Unlock()
-	return time.Time{}, fmt.Errorf("cannot reset lease for id: %s", id)
+	// Keep the entry with an elapsed expiry rather than deleting it, so the holder can be queried until re-acquired.
+	current.ExpiresAt = time.Now()
+	l.store.Set(id, current)
+	return nil
+}
+
+// Holder returns the current lease for an ID, and false if the lease is not held or expired.
+func (l *inMemoryLeaser) Holder(id string) (Lease, bool) {
+	l.mutex.Lock()
+	defer l.mutex.Unlock()
+
+	current, ok := l.store.Get(id)
+	if !ok || current.Expired() {
+		return Lease{}, false
+	}
+	return current, true
 }
 
 // inMemoryLeaserBuilder is a builder implementation for inMemoryLeaser.
@@ -267,17 +795,24 @@ type inMemoryLeaserBuilder struct {
 
 // Build creates and returns a new instance of inMemoryLeaser.
 func (b *inMemoryLeaserBuilder) Build() Leaser {
-	leaser := DefaultMap[string, time.Time]()
+	return newInMemoryLeaser(b.LeaseDuration)
+}
+
+func newInMemoryLeaser(leaseDuration time.Duration) *inMemoryLeaser {
 	return &inMemoryLeaser{
-		store:         leaser,
-		LeaseDuration: b.LeaseDuration,
+		store:         DefaultMap[string, Lease](),
+		LeaseDuration: leaseDuration,
 		mutex:         &sync.Mutex{},
 	}
 }
 
 // NewInMemoryLeaserBuilder returns a new instance of inMemoryLeaserBuilder.
-func NewInMemoryLeaserBuilder() LeaserBuilder {
-	return &inMemoryLeaserBuilder{}
+// Leases last DefaultLeaseDuration unless WithLeaseDuration is provided.
+func NewInMemoryLeaserBuilder(opts ...LeaserOption) LeaserBuilder {
+	o := newLeaserOptions(opts)
+	return &inMemoryLeaserBuilder{
+		LeaseDuration: o.LeaseDuration,
+	}
 }
 
 //----------------------------------------------------------------------------------------------------------------------
@@ -289,11 +824,75 @@ func NewInMemoryLeaserBuilder() LeaserBuilder {
 //
 // Even though DefaultQueue is now a strange wrapper over a channel, It enables us to provide different types of queues.
 // Queue is intended for multiple uses cases, such as abstracting a clustered Queue over the network.
+//
+// On top of the raw channels, Queue exposes introspection and non-blocking operations, so autoscaling, metrics and
+// backpressure logic can be built uniformly across implementations. Note that items sent or received directly through
+// the raw channels are not accounted for in Stats.
+//
+// Stopping a Queue is safe under load: Stop is idempotent, Send & TrySend return an Error once the queue is closed,
+// and the underlying channel is only closed after every in-flight Send returned and every registered producer
+// unregistered.
 type Queue[T any] interface {
 	Runtime
 	Receiver() <-chan T
 	// Sender methods returns a reference to a chan T
+	// Goroutines writing directly to the returned channel must be registered with RegisterProducer, and must stop
+	// sending once Done is closed, otherwise they may send on a closed channel.
 	Sender() chan<- T
+
+	// RegisterProducer method registers a producer holding a reference to Sender.
+	// Stop waits until every registered producer called UnregisterProducer before closing the channel.
+	// Returns an Error if the queue is already closed.
+	RegisterProducer() Error
+	// UnregisterProducer method unregisters a producer previously registered with RegisterProducer.
+	UnregisterProducer()
+	// Done method returns a channel that is closed when the queue starts stopping.
+	Done() <-chan struct{}
+	// IsClosed method returns true if the queue is stopping or stopped.
+	IsClosed() bool
+
+	// Send method blocks until the item is enqueued.
+	// Returns an Error if the queue is closed.
+	Send(item T) Error
+	// TrySend method tries to enqueue the item, waiting at most timeout. A zero timeout never blocks.
+	// Returns an Error if the queue is closed, or still full after the timeout.
+	TrySend(item T, timeout time.Duration) Error
+	// Receive method blocks until an item is dequeued. Returns false if the queue is closed.
+	Receive() (T, bool)
+	// TryReceive method tries to dequeue an item, waiting at most timeout. A zero timeout never blocks.
+	// Returns false if no item could be dequeued.
+	TryReceive(timeout time.Duration) (T, bool)
+	// ReceiveContext method blocks until an item is dequeued or the context is done.
+	// Returns false if the queue is closed or the context is done.
+	ReceiveContext(ctx context.Context) (T, bool)
+
+	// Len method returns the number of items waiting in the queue.
+	Len() int
+	// Cap method returns the maximum number of items the queue can hold.
+	Cap() int
+	// Stats method returns a snapshot of the queue statistics.
+	Stats() QueueStats
+}
+
+const (
+	// ErrorTypeQueueFull is the type of the Error returned when a Queue is full.
+	ErrorTypeQueueFull ErrorType = "QueueFullError"
+	// ErrorTypeQueueClosed is the type of the Error returned when sending to a closed Queue.
+	ErrorTypeQueueClosed ErrorType = "QueueClosedError"
+)
+
+// QueueStats is a snapshot of the statistics of a Queue.
+type QueueStats struct {
+	// Length is the number of items waiting in the queue.
+	Length int
+	// Capacity is the maximum number of items the queue can hold.
+	Capacity int
+	// Enqueued is the total number of items sent through Send & TrySend.
+	Enqueued uint64
+	// Dequeued is the total number of items received through Receive, TryReceive & ReceiveContext.
+	Dequeued uint64
+	// Rejected is the total number of items TrySend failed to enqueue.
+	Rejected uint64
 }
 
 // The inMemoryQueue struct is a generic type that holds a channel for concurrent access
@@ -304,6 +903,16 @@ type inMemoryQueue[T any] struct {
 	capacity int
 	channel  chan T
 	logger   *Logger
+
+	enqueued *atomic.Uint64
+	dequeued *atomic.Uint64
+	rejected *atomic.Uint64
+
+	closed    bool
+	done      chan struct{}
+	producers *sync.WaitGroup
+	stopOnce  *sync.Once
+	mutex     *sync.Mutex
 }
 
 func (q *inMemoryQueue[T]) Init() Error {
@@ -331,10 +940,24 @@ func (q *inMemoryQueue[T]) Run() Error {
 	return nil
 }
 
+// Stop method closes the queue. It is idempotent and safe to call concurrently.
+// New sends are rejected immediately, then the channel is closed once in-flight sends returned and registered
+// producers unregistered.
 func (q *inMemoryQueue[T]) Stop() Error {
-	LogInfof(q, LogOperationStop, LogStatusStart, "stopping queue: %s", q.GetName())
-	close(q.channel)
-	LogInfof(q, LogOperationStop, LogStatusSuccess, "successfully stopped queue: %s", q.GetName())
+	q.stopOnce.Do(func() {
+		LogInfof(q, LogOperationStop, LogStatusStart, "stopping queue: %s", q.GetName())
+
+		q.mutex.Lock()
+		q.closed = true
+		close(q.done)
+		q.mutex.Unlock()
+
+		q.producers.Wait()
+		if q.channel != nil {
+			close(q.channel)
+		}
+		LogInfof(q, LogOperationStop, LogStatusSuccess, "successfully stopped queue: %s", q.GetName())
+	})
 	return nil
 }
 
@@ -362,6 +985,162 @@ func (q *inMemoryQueue[T]) Sender() chan<- T {
 	return q.channel
 }
 
+func (q *inMemoryQueue[T]) RegisterProducer() Error {
+	q.mutex.Lock()
+	defer q.mutex.Unlock()
+	if q.closed {
+		return q.closedError()
+	}
+	q.producers.Add(1)
+	return nil
+}
+
+func (q *inMemoryQueue[T]) UnregisterProducer() {
+	q.producers.Done()
+}
+
+func (q *inMemoryQueue[T]) Done() <-chan struct{} {
+	return q.done
+}
+
+func (q *inMemoryQueue[T]) IsClosed() bool {
+	q.mutex.Lock()
+	defer q.mutex.Unlock()
+	return q.closed
+}
+
+// Send method blocks until the item is enqueued.
+// Returns an Error if the queue is closed, or gets closed while waiting.
+func (q *inMemoryQueue[T]) Send(item T) Error {
+	if err := q.RegisterProducer(); err != nil {
+		return err
+	}
+	defer q.UnregisterProducer()
+
+	select {
+	case q.channel <- item:
+		q.enqueued.Add(1)
+		return nil
+	case <-q.done:
+		return q.closedError()
+	}
+}
+
+// TrySend method tries to enqueue the item, waiting at most timeout. A zero timeout never blocks.
+// Returns an Error if the queue is closed, or still full after the timeout.
+func (q *inMemoryQueue[T]) TrySend(item T, timeout time.Duration) Error {
+	if err := q.RegisterProducer(); err != nil {
+		return err
+	}
+	defer q.UnregisterProducer()
+
+	select {
+	case q.channel <- item:
+		q.enqueued.Add(1)
+		return nil
+	default:
+	}
+
+	if timeout > 0 {
+		timer := time.NewTimer(timeout)
+		defer timer.Stop()
+		select {
+		case q.channel <- item:
+			q.enqueued.Add(1)
+			return nil
+		case <-q.done:
+			return q.closedError()
+		case <-timer.C:
+		}
+	}
+
+	q.rejected.Add(1)
+	return NewError(ErrorTypeQueueFull, fmt.Sprintf("queue is full: %s", q.GetName()), nil)
+}
+
+func (q *inMemoryQueue[T]) closedError() Error {
+	return NewError(ErrorTypeQueueClosed, fmt.Sprintf("queue is closed: %s", q.GetName()), nil)
+}
+
+// Receive method blocks until an item is dequeued. Returns false if the queue is closed.
+func (q *inMemoryQueue[T]) Receive() (T, bool) {
+	item, ok := <-q.channel
+	if ok {
+		q.dequeued.Add(1)
+	}
+	return item, ok
+}
+
+// TryReceive method tries to dequeue an item, waiting at most timeout. A zero timeout never blocks.
+func (q *inMemoryQueue[T]) TryReceive(timeout time.Duration) (T, bool) {
+	select {
+	case item, ok := <-q.channel:
+		if ok {
+			q.dequeued.Add(1)
+		}
+		return item, ok
+	default:
+	}
+
+	if timeout > 0 {
+		timer := time.NewTimer(timeout)
+		defer timer.Stop()
+		select {
+		case item, ok := <-q.channel:
+			if ok {
+				q.dequeued.Add(1)
+			}
+			return item, ok
+		case <-timer.C:
+		}
+	}
+
+	var null T
+	return null, false
+}
+
+// ReceiveContext method blocks until an item is dequeued or the context is done. An item already waiting in the queue
+// is dequeued even if the context is done.
+func (q *inMemoryQueue[T]) ReceiveContext(ctx context.Context) (T, bool) {
+	select {
+	case item, ok := <-q.channel:
+		if ok {
+			q.dequeued.Add(1)
+		}
+		return item, ok
+	default:
+	}
+
+	select {
+	case item, ok := <-q.channel:
+		if ok {
+			q.dequeued.Add(1)
+		}
+		return item, ok
+	case <-ctx.Done():
+		var null T
+		return null, false
+	}
+}
+
+func (q *inMemoryQueue[T]) Len() int {
+	return len(q.channel)
+}
+
+func (q *inMemoryQueue[T]) Cap() int {
+	return q.capacity
+}
+
+func (q *inMemoryQueue[T]) Stats() QueueStats {
+	return QueueStats{
+		Length:   q.Len(),
+		Capacity: q.Cap(),
+		Enqueued: q.enqueued.Load(),
+		Dequeued: q.dequeued.Load(),
+		Rejected: q.rejected.Load(),
+	}
+}
+
 // DefaultQueue function returns a new inMemoryQueue with an initialized channel
 func DefaultQueue[T any](name string, ctx context.Context) Queue[T] {
 	return DefaultQueueWithCapacity[T](name, ctx, 1)
@@ -373,5 +1152,13 @@ func DefaultQueueWithCapacity[T any](name string, ctx context.Context, capacity
 		Name:     name,
 		ctx:      ctx,
 		capacity: capacity,
+		enqueued: &atomic.Uint64{},
+		dequeued: &atomic.Uint64{},
+		rejected: &atomic.Uint64{},
+
+		done:      make(chan struct{}),
+		producers: &sync.WaitGroup{},
+		stopOnce:  &sync.Once{},
+		mutex:     &sync.Mutex{},
 	}
 }
@@ -0,0 +1,369 @@
+/*
+Copyright 2023 Alexandre Mahdhaoui
+
+Licensed under the Apache License, Version 2.0 (the "License");
+you may not use this file except in compliance with the License.
+You may obtain a copy of the License at
+
+	http://www.apache.org/licenses/LICENSE-2.0
+
+Unless required by applicable law or agreed to in writing, software
+distributed under the License is distributed on an "AS IS" BASIS,
+WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+See the License for the specific language governing permissions and
+limitations under the License.
+*/
+
+package bda
+
+import (
+	"container/list"
+	"sync"
+)
+
+//----------------------------------------------------------------------------------------------------------------------
+//- Cache
+
+// Cache is a memory-bounded Map. When a new key is set while the Cache is full, a key is evicted according to the
+// eviction policy of the implementation (see DefaultLRUCache and DefaultLFUCache).
+type Cache[T comparable, I any] interface {
+	Map[T, I]
+	// Capacity method returns the maximum number of keys the cache can hold
+	Capacity() int
+	// Stats method returns a snapshot of the cache statistics
+	Stats() CacheStats
+}
+
+// CacheStats is a snapshot of the statistics of a Cache.
+type CacheStats struct {
+	Length   int
+	Capacity int
+	// Hits is the number of Get calls that found their key.
+	Hits uint64
+	// Misses is the number of Get calls that did not find their key.
+	Misses uint64
+	// Evictions is the number of keys evicted to respect the capacity.
+	Evictions uint64
+}
+
+// cachePolicy tracks the keys of a boundedCache and elects the key to evict.
+type cachePolicy[T comparable] interface {
+	// add starts tracking a new key
+	add(key T)
+	// touch records an access to a tracked key
+	touch(key T)
+	// remove stops tracking a key
+	remove(key T)
+	// victim returns the key to evict
+	victim() (T, bool)
+}
+
+// boundedCache is a Cache whose eviction policy is delegated to a cachePolicy.
+type boundedCache[T comparable, I any] struct {
+	capacity int
+	store    map[T]I
+	policy   cachePolicy[T]
+	onEvict  func(key T, value I)
+
+	hits      uint64
+	misses    uint64
+	evictions uint64
+	mutex     *sync.Mutex
+}
+
+func (c *boundedCache[T, I]) Get(key T) (I, bool) {
+	c.mutex.Lock()
+	defer c.mutex.Unlock()
+	value, ok := c.store[key]
+	if !ok {
+		c.misses++
+		return value, false
+	}
+	c.hits++
+	c.policy.touch(key)
+	return value, true
+}
+
+func (c *boundedCache[T, I]) Set(key T, value I) (T, I) {
+	c.mutex.Lock()
+	evictedKey, evictedValue, evicted := c.setLocked(key, value)
+	c.mutex.Unlock()
+
+	c.evict(evictedKey, evictedValue, evicted)
+	return key, value
+}
+
+func (c *boundedCache[T, I]) Delete(key T) (I, bool) {
+	c.mutex.Lock()
+	defer c.mutex.Unlock()
+	value, ok := c.store[key]
+	if ok {
+		delete(c.store, key)
+		c.policy.remove(key)
+	}
+	return value, ok
+}
+
+func (c *boundedCache[T, I]) Len() int {
+	c.mutex.Lock()
+	defer c.mutex.Unlock()
+	return len(c.store)
+}
+
+func (c *boundedCache[T, I]) Range(f func(key T, value I) bool) {
+	for key, value := range c.Snapshot() {
+		if !f(key, value) {
+			return
+		}
+	}
+}
+
+// Snapshot method returns a copy of the cache. It does not count as an access to its keys.
+func (c *boundedCache[T, I]) Snapshot() map[T]I {
+	c.mutex.Lock()
+	defer c.mutex.Unlock()
+	snapshot := make(map[T]I, len(c.store))
+	for key, value := range c.store {
+		snapshot[key] = value
+	}
+	return snapshot
+}
+
+func (c *boundedCache[T, I]) LoadOrStore(key T, value I) (I, bool) {
+	c.mutex.Lock()
+	if actual, ok := c.store[key]; ok {
+		c.policy.touch(key)
+		c.mutex.Unlock()
+		return actual, true
+	}
+	evictedKey, evictedValue, evicted := c.setLocked(key, value)
+	c.mutex.Unlock()
+
+	c.evict(evictedKey, evictedValue, evicted)
+	return value, false
+}
+
+func (c *boundedCache[T, I]) CompareAndSwap(key T, old, new I) bool {
+	c.mutex.Lock()
+	defer c.mutex.Unlock()
+	if current, ok := c.store[key]; !ok || any(current) != any(old) {
+		return false
+	}
+	c.store[key] = new
+	c.policy.touch(key)
+	return true
+}
+
+func (c *boundedCache[T, I]) Compute(key T, fn func(value I, ok bool) (I, bool)) (I, bool) {
+	c.mutex.Lock()
+	current, ok := c.store[key]
+	value, keep := fn(current, ok)
+	if !keep {
+		if ok {
+			delete(c.store, key)
+			c.policy.remove(key)
+		}
+		c.mutex.Unlock()
+		var null I
+		return null, false
+	}
+	evictedKey, evictedValue, evicted := c.setLocked(key, value)
+	c.mutex.Unlock()
+
+	c.evict(evictedKey, evictedValue, evicted)
+	return value, true
+}
+
+func (c *boundedCache[T, I]) Capacity() int {
+	return c.capacity
+}
+
+func (c *boundedCache[T, I]) Stats() CacheStats {
+	c.mutex.Lock()
+	defer c.mutex.Unlock()
+	return CacheStats{
+		Length:    len(c.store),
+		Capacity:  c.capacity,
+		Hits:      c.hits,
+		Misses:    c.misses,
+		Evictions: c.evictions,
+	}
+}
+
+// setLocked sets a value for a key and returns the evicted key and value, if the cache was full.
+func (c *boundedCache[T, I]) setLocked(key T, value I) (T, I, bool) {
+	var (
+		evictedKey   T
+		evictedValue I
+		evicted      bool
+	)
+
+	if _, ok := c.store[key]; ok {
+		c.policy.touch(key)
+	} else {
+		if len(c.store) >= c.capacity {
+			if evictedKey, evicted = c.policy.victim(); evicted {
+				evictedValue = c.store[evictedKey]
+				delete(c.store, evictedKey)
+				c.policy.remove(evictedKey)
+				c.evictions++
+			}
+		}
+		c.policy.add(key)
+	}
+	c.store[key] = value
+	return evictedKey, evictedValue, evicted
+}
+
+// evict calls the eviction hook outside the mutex, so the hook may use the cache.
+func (c *boundedCache[T, I]) evict(key T, value I, evicted bool) {
+	if evicted && c.onEvict != nil {
+		c.onEvict(key, value)
+	}
+}
+
+func newBoundedCache[T comparable, I any](capacity int, policy cachePolicy[T], onEvict func(key T, value I)) *boundedCache[T, I] {
+	if capacity < 1 {
+		capacity = 1
+	}
+	return &boundedCache[T, I]{
+		capacity: capacity,
+		store:    make(map[T]I, capacity),
+		policy:   policy,
+		onEvict:  onEvict,
+		mutex:    &sync.Mutex{},
+	}
+}
+
+//----------------------------------------------------------------------------------------------------------------------
+//- LRU
+
+// lruPolicy evicts the least recently used key.
+type lruPolicy[T comparable] struct {
+	// order holds keys from the most to the least recently used.
+	order    *list.List
+	elements map[T]*list.Element
+}
+
+func (p *lruPolicy[T]) add(key T) {
+	p.elements[key] = p.order.PushFront(key)
+}
+
+func (p *lruPolicy[T]) touch(key T) {
+	if e, ok := p.elements[key]; ok {
+		p.order.MoveToFront(e)
+	}
+}
+
+func (p *lruPolicy[T]) remove(key T) {
+	if e, ok := p.elements[key]; ok {
+		p.order.Remove(e)
+		delete(p.elements, key)
+	}
+}
+
+func (p *lruPolicy[T]) victim() (T, bool) {
+	e := p.order.Back()
+	if e == nil {
+		var null T
+		return null, false
+	}
+	return e.Value.(T), true
+}
+
+// DefaultLRUCache function returns a new in-memory Cache evicting the least recently used key when full.
+// onEvict may be nil; it is called for evicted keys only, not deleted ones.
+func DefaultLRUCache[T comparable, I any](capacity int, onEvict func(key T, value I)) Cache[T, I] {
+	return newBoundedCache[T, I](capacity, &lruPolicy[T]{
+		order:    list.New(),
+		elements: make(map[T]*list.Element),
+	}, onEvict)
+}
+
+//----------------------------------------------------------------------------------------------------------------------
+//- LFU
+
+type lfuEntry[T comparable] struct {
+	key       T
+	frequency int
+}
+
+// lfuPolicy evicts the least frequently used key. Ties are broken by evicting the least recently used key among the
+// least frequently used ones. All operations run in constant time.
+type lfuPolicy[T comparable] struct {
+	// buckets holds, for each frequency, the keys from the most to the least recently used.
+	buckets      map[int]*list.List
+	elements     map[T]*list.Element
+	minFrequency int
+}
+
+func (p *lfuPolicy[T]) add(key T) {
+	p.elements[key] = p.bucket(1).PushFront(&lfuEntry[T]{key: key, frequency: 1})
+	p.minFrequency = 1
+}
+
+func (p *lfuPolicy[T]) touch(key T) {
+	e, ok := p.elements[key]
+	if !ok {
+		return
+	}
+	entry := e.Value.(*lfuEntry[T])
+	p.unlink(e, entry.frequency)
+	if entry.frequency == p.minFrequency {
+		if _, ok := p.buckets[p.minFrequency]; !ok {
+			p.minFrequency++
+		}
+	}
+	entry.frequency++
+	p.elements[key] = p.bucket(entry.frequency).PushFront(entry)
+}
+
+func (p *lfuPolicy[T]) remove(key T) {
+	e, ok := p.elements[key]
+	if !ok {
+		return
+	}
+	p.unlink(e, e.Value.(*lfuEntry[T]).frequency)
+	delete(p.elements, key)
+	// minFrequency is recomputed lazily by victim.
+}
+
+func (p *lfuPolicy[T]) victim() (T, bool) {
+	if len(p.elements) == 0 {
+		var null T
+		return null, false
+	}
+	for {
+		if b, ok := p.buckets[p.minFrequency]; ok {
+			return b.Back().Value.(*lfuEntry[T]).key, true
+		}
+		p.minFrequency++
+	}
+}
+
+func (p *lfuPolicy[T]) bucket(frequency int) *list.List {
+	b, ok := p.buckets[frequency]
+	if !ok {
+		b = list.New()
+		p.buckets[frequency] = b
+	}
+	return b
+}
+
+// unlink removes an element from its bucket, and drops the bucket if empty.
+func (p *lfuPolicy[T]) unlink(e *list.Element, frequency int) {
+	b := p.buckets[frequency]
+	b.Remove(e)
+	if b.Len() == 0 {
+		delete(p.buckets, frequency)
+	}
+}
+
+// DefaultLFUCache function returns a new in-memory Cache evicting the least frequently used key when full.
+// onEvict may be nil; it is called for evicted keys only, not deleted ones.
+func DefaultLFUCache[T comparable, I any](capacity int, onEvict func(key T, value I)) Cache[T, I] {
+	return newBoundedCache[T, I](capacity, &lfuPolicy[T]{
+		buckets:  make(map[int]*list.List),
+		elements: make(map[T]*list.Element),
+	}, onEvict)
+}
@@ -0,0 +1,130 @@
+/*
+Copyright 2023 Alexandre Mahdhaoui
+
+Licensed under the Apache License, Version 2.0 (the "License");
+you may not use this file except in compliance with the License.
+You may obtain a copy of the License at
+
+	http://www.apache.org/licenses/LICENSE-2.0
+
+Unless required by applicable law or agreed to in writing, software
+distributed under the License is distributed on an "AS IS" BASIS,
+WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+See the License for the specific language governing permissions and
+limitations under the License.
+*/
+
+package bda
+
+import (
+	"fmt"
+	"reflect"
+	"testing"
+)
+
+func TestLRUCacheEvictsLeastRecentlyUsed(t *testing.T) {
+	evicted := make([]string, 0)
+	c := DefaultLRUCache[string, int](2, func(key string, _ int) {
+		evicted = append(evicted, key)
+	})
+
+	c.Set("a", 1)
+	c.Set("b", 2)
+	// reading a makes b the least recently used key
+	if _, ok := c.Get("a"); !ok {
+		t.Fatalf("Get(\"a\") = false; want true")
+	}
+	c.Set("c", 3)
+
+	if _, ok := c.Get("b"); ok {
+		t.Fatalf("Get(\"b\") = true; want b evicted")
+	}
+	if got, want := c.Snapshot(), map[string]int{"a": 1, "c": 3}; !reflect.DeepEqual(got, want) {
+		t.Fatalf("Snapshot() = %v; want %v", got, want)
+	}
+	if want := []string{"b"}; !reflect.DeepEqual(evicted, want) {
+		t.Fatalf("evicted = %v; want %v", evicted, want)
+	}
+}
+
+func TestLFUCacheBreaksTiesByRecency(t *testing.T) {
+	evicted := make([]string, 0)
+	c := DefaultLFUCache[string, int](3, func(key string, _ int) {
+		evicted = append(evicted, key)
+	})
+
+	c.Set("a", 1)
+	c.Set("b", 2)
+	c.Set("c", 3)
+	// a is used the most; b and c are used as often, b less recently than c
+	c.Get("a")
+	c.Get("a")
+	c.Get("b")
+	c.Get("c")
+	c.Set("d", 4)
+
+	if _, ok := c.Get("b"); ok {
+		t.Fatalf("Get(\"b\") = true; want b evicted")
+	}
+	// d is the least frequently used key
+	c.Set("e", 5)
+	if _, ok := c.Get("d"); ok {
+		t.Fatalf("Get(\"d\") = true; want d evicted")
+	}
+	if want := []string{"b", "d"}; !reflect.DeepEqual(evicted, want) {
+		t.Fatalf("evicted = %v; want %v", evicted, want)
+	}
+}
+
+func TestCacheNeverExceedsCapacity(t *testing.T) {
+	for _, tc := range []struct {
+		name  string
+		cache func(onEvict func(key, value int)) Cache[int, int]
+	}{
+		{name: "lru", cache: func(onEvict func(key, value int)) Cache[int, int] { return DefaultLRUCache[int, int](8, onEvict) }},
+		{name: "lfu", cache: func(onEvict func(key, value int)) Cache[int, int] { return DefaultLFUCache[int, int](8, onEvict) }},
+	} {
+		t.Run(tc.name, func(t *testing.T) {
+			evictions := 0
+			c := tc.cache(func(key, value int) {
+				if key != value {
+					t.Errorf("evicted %d: %d; want the value of the key", key, value)
+				}
+				evictions++
+			})
+
+			for i := 0; i < 100; i++ {
+				c.Set(i, i)
+				c.Get(i % 5)
+				c.LoadOrStore(i+1000, i+1000)
+				c.Compute(i+2000, func(int, bool) (int, bool) { return i + 2000, true })
+				if c.Len() > c.Capacity() {
+					t.Fatalf("Len() = %d after %d sets; want at most %d", c.Len(), i+1, c.Capacity())
+				}
+			}
+
+			stats := c.Stats()
+			if stats.Length != 8 || stats.Capacity != 8 {
+				t.Fatalf("Stats() = %+v; want length and capacity 8", stats)
+			}
+			// every key set but the ones held by the cache was evicted
+			if want := 300 - 8; evictions != want || stats.Evictions != uint64(want) {
+				t.Fatalf("evictions = %d, Stats().Evictions = %d; want %d", evictions, stats.Evictions, want)
+			}
+			if stats.Hits+stats.Misses != 100 {
+				t.Fatalf("Stats() = %+v; want 100 Get calls", stats)
+			}
+		})
+	}
+}
+
+func TestCacheDeleteDoesNotCallOnEvict(t *testing.T) {
+	c := DefaultLRUCache[string, int](1, func(key string, _ int) {
+		panic(fmt.Sprintf("evicted %s", key))
+	})
+	c.Set("a", 1)
+	if value, ok := c.Delete("a"); !ok || value != 1 {
+		t.Fatalf("Delete(\"a\") = %d, %t; want 1, true", value, ok)
+	}
+	c.Set("b", 2)
+}
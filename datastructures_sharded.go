@@ -0,0 +1,262 @@
+/*
+Copyright 2023 Alexandre Mahdhaoui
+
+Licensed under the Apache License, Version 2.0 (the "License");
+you may not use this file except in compliance with the License.
+You may obtain a copy of the License at
+
+	http://www.apache.org/licenses/LICENSE-2.0
+
+Unless required by applicable law or agreed to in writing, software
+distributed under the License is distributed on an "AS IS" BASIS,
+WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+See the License for the specific language governing permissions and
+limitations under the License.
+*/
+
+package bda
+
+import (
+	"hash/maphash"
+	"math"
+	"sync"
+)
+
+// DefaultShardCount is the number of shards used by DefaultShardedMap and DefaultShardedSet.
+const DefaultShardCount = 32
+
+// Hasher hashes a key to select the shard holding it.
+type Hasher[T comparable] func(key T) uint64
+
+// DefaultHasher returns a Hasher supporting any comparable key: keys equal under == are hashed to the same value.
+// Strings, integers, floats and booleans are hashed directly; other keys are hashed with maphash.Comparable. Before
+// go1.24, keys of other types are not supported: the Hasher panics, and a Hasher must be given to NewShardedMap or
+// NewShardedSet.
+func DefaultHasher[T comparable]() Hasher[T] {
+	seed := maphash.MakeSeed()
+	fallback := comparableHasher[T](seed)
+	return func(key T) uint64 {
+		switch k := any(key).(type) {
+		case string:
+			return maphash.String(seed, k)
+		case int:
+			return mixUint64(uint64(k))
+		case int8:
+			return mixUint64(uint64(k))
+		case int16:
+			return mixUint64(uint64(k))
+		case int32:
+			return mixUint64(uint64(k))
+		case int64:
+			return mixUint64(uint64(k))
+		case uint:
+			return mixUint64(uint64(k))
+		case uint8:
+			return mixUint64(uint64(k))
+		case uint16:
+			return mixUint64(uint64(k))
+		case uint32:
+			return mixUint64(uint64(k))
+		case uint64:
+			return mixUint64(k)
+		case uintptr:
+			return mixUint64(uint64(k))
+		case float32:
+			return mixUint64(math.Float64bits(normalizeFloat(float64(k))))
+		case float64:
+			return mixUint64(math.Float64bits(normalizeFloat(k)))
+		case bool:
+			if k {
+				return 1
+			}
+			return 0
+		default:
+			return fallback(key)
+		}
+	}
+}
+
+// normalizeFloat returns 0 for -0, so both are hashed to the same value like they are equal under ==.
+func normalizeFloat(f float64) float64 {
+	if f == 0 {
+		return 0
+	}
+	return f
+}
+
+// mixUint64 spreads the bits of integer keys, so sequential keys do not land in sequential shards.
+func mixUint64(x uint64) uint64 {
+	x ^= x >> 33
+	x *= 0xff51afd7ed558ccd
+	x ^= x >> 33
+	x *= 0xc4ceb9fe1a85ec53
+	x ^= x >> 33
+	return x
+}
+
+//----------------------------------------------------------------------------------------------------------------------
+//- ShardedMap
+
+// shardedMap is a lock-striped Map: keys are spread over shards, each guarded by its own read-write mutex, so
+// workers accessing different keys rarely contend on the same lock.
+type shardedMap[T comparable, I any] struct {
+	shards []*inMemoryMap[T, I]
+	hasher Hasher[T]
+}
+
+func (m *shardedMap[T, I]) shard(key T) *inMemoryMap[T, I] {
+	return m.shards[m.hasher(key)%uint64(len(m.shards))]
+}
+
+func (m *shardedMap[T, I]) Get(key T) (I, bool) {
+	return m.shard(key).Get(key)
+}
+
+func (m *shardedMap[T, I]) Set(key T, value I) (T, I) {
+	return m.shard(key).Set(key, value)
+}
+
+func (m *shardedMap[T, I]) Delete(key T) (I, bool) {
+	return m.shard(key).Delete(key)
+}
+
+// Len method returns the number of keys in the map. Shards are counted one after the other, so the result may not
+// reflect concurrent writes.
+func (m *shardedMap[T, I]) Len() int {
+	length := 0
+	for _, shard := range m.shards {
+		length += shard.Len()
+	}
+	return length
+}
+
+func (m *shardedMap[T, I]) Range(f func(key T, value I) bool) {
+	for _, shard := range m.shards {
+		for key, value := range shard.Snapshot() {
+			if !f(key, value) {
+				return
+			}
+		}
+	}
+}
+
+// Snapshot method returns a copy of the map. Shards are copied one after the other.
+func (m *shardedMap[T, I]) Snapshot() map[T]I {
+	snapshot := make(map[T]I)
+	for _, shard := range m.shards {
+		for key, value := range shard.Snapshot() {
+			snapshot[key] = value
+		}
+	}
+	return snapshot
+}
+
+func (m *shardedMap[T, I]) LoadOrStore(key T, value I) (I, bool) {
+	return m.shard(key).LoadOrStore(key, value)
+}
+
+func (m *shardedMap[T, I]) CompareAndSwap(key T, old, new I) bool {
+	return m.shard(key).CompareAndSwap(key, old, new)
+}
+
+func (m *shardedMap[T, I]) Compute(key T, fn func(value I, ok bool) (I, bool)) (I, bool) {
+	return m.shard(key).Compute(key, fn)
+}
+
+// DefaultShardedMap function returns a new lock-striped Map with DefaultShardCount shards
+func DefaultShardedMap[T comparable, I any]() Map[T, I] {
+	return NewShardedMap[T, I](DefaultShardCount, DefaultHasher[T]())
+}
+
+// NewShardedMap function returns a new lock-striped Map with the given number of shards and Hasher
+func NewShardedMap[T comparable, I any](shards int, hasher Hasher[T]) Map[T, I] {
+	if shards < 1 {
+		shards = 1
+	}
+	m := &shardedMap[T, I]{
+		shards: make([]*inMemoryMap[T, I], shards),
+		hasher: hasher,
+	}
+	for i := range m.shards {
+		m.shards[i] = newInMemoryMap[T, I](&sync.RWMutex{})
+	}
+	return m
+}
+
+//----------------------------------------------------------------------------------------------------------------------
+//- ShardedSet
+
+// shardedSet is a lock-striped Set: keys are spread over shards, each guarded by its own read-write mutex.
+type shardedSet[T comparable] struct {
+	shards []*inMemorySet[T]
+	hasher Hasher[T]
+}
+
+func (s *shardedSet[T]) shard(key T) *inMemorySet[T] {
+	return s.shards[s.hasher(key)%uint64(len(s.shards))]
+}
+
+func (s *shardedSet[T]) Exist(key T) bool {
+	return s.shard(key).Exist(key)
+}
+
+func (s *shardedSet[T]) Set(key T) {
+	s.shard(key).Set(key)
+}
+
+func (s *shardedSet[T]) TrySet(key T) bool {
+	return s.shard(key).TrySet(key)
+}
+
+func (s *shardedSet[T]) Remove(key T) bool {
+	return s.shard(key).Remove(key)
+}
+
+// Len method returns the number of keys in the Set. Shards are counted one after the other, so the result may not
+// reflect concurrent writes.
+func (s *shardedSet[T]) Len() int {
+	length := 0
+	for _, shard := range s.shards {
+		length += shard.Len()
+	}
+	return length
+}
+
+func (s *shardedSet[T]) Range(f func(key T) bool) {
+	for _, shard := range s.shards {
+		for _, key := range shard.Values() {
+			if !f(key) {
+				return
+			}
+		}
+	}
+}
+
+// Values method returns a snapshot of the keys of the Set. Shards are copied one after the other.
+func (s *shardedSet[T]) Values() []T {
+	values := make([]T, 0)
+	for _, shard := range s.shards {
+		values = append(values, shard.Values()...)
+	}
+	return values
+}
+
+// DefaultShardedSet function returns a new lock-striped Set with DefaultShardCount shards
+func DefaultShardedSet[T comparable]() Set[T] {
+	return NewShardedSet[T](DefaultShardCount, DefaultHasher[T]())
+}
+
+// NewShardedSet function returns a new lock-striped Set with the given number of shards and Hasher
+func NewShardedSet[T comparable](shards int, hasher Hasher[T]) Set[T] {
+	if shards < 1 {
+		shards = 1
+	}
+	s := &shardedSet[T]{
+		shards: make([]*inMemorySet[T], shards),
+		hasher: hasher,
+	}
+	for i := range s.shards {
+		s.shards[i] = newInMemorySet[T](&sync.RWMutex{})
+	}
+	return s
+}
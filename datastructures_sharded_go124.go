@@ -0,0 +1,28 @@
+//go:build go1.24
+
+/*
+Copyright 2023 Alexandre Mahdhaoui
+
+Licensed under the Apache License, Version 2.0 (the "License");
+you may not use this file except in compliance with the License.
+You may obtain a copy of the License at
+
+	http://www.apache.org/licenses/LICENSE-2.0
+
+Unless required by applicable law or agreed to in writing, software
+distributed under the License is distributed on an "AS IS" BASIS,
+WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+See the License for the specific language governing permissions and
+limitations under the License.
+*/
+
+package bda
+
+import "hash/maphash"
+
+// comparableHasher hashes keys with maphash.Comparable, which is consistent with ==.
+func comparableHasher[T comparable](seed maphash.Seed) Hasher[T] {
+	return func(key T) uint64 {
+		return maphash.Comparable(seed, key)
+	}
+}
@@ -0,0 +1,32 @@
+//go:build !go1.24
+
+/*
+Copyright 2023 Alexandre Mahdhaoui
+
+Licensed under the Apache License, Version 2.0 (the "License");
+you may not use this file except in compliance with the License.
+You may obtain a copy of the License at
+
+	http://www.apache.org/licenses/LICENSE-2.0
+
+Unless required by applicable law or agreed to in writing, software
+distributed under the License is distributed on an "AS IS" BASIS,
+WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+See the License for the specific language governing permissions and
+limitations under the License.
+*/
+
+package bda
+
+import (
+	"fmt"
+	"hash/maphash"
+)
+
+// comparableHasher panics: keys that are not strings, numbers or booleans cannot be hashed consistently with ==
+// before go1.24.
+func comparableHasher[T comparable](seed maphash.Seed) Hasher[T] {
+	return func(key T) uint64 {
+		panic(fmt.Sprintf("DefaultHasher does not support keys of type %T before go1.24; use NewShardedMap or NewShardedSet with a Hasher", key))
+	}
+}
@@ -0,0 +1,329 @@
+/*
+Copyright 2023 Alexandre Mahdhaoui
+
+Licensed under the Apache License, Version 2.0 (the "License");
+you may not use this file except in compliance with the License.
+You may obtain a copy of the License at
+
+	http://www.apache.org/licenses/LICENSE-2.0
+
+Unless required by applicable law or agreed to in writing, software
+distributed under the License is distributed on an "AS IS" BASIS,
+WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+See the License for the specific language governing permissions and
+limitations under the License.
+*/
+
+package bda
+
+import (
+	"fmt"
+	"math"
+	"sync"
+	"testing"
+)
+
+func TestShardedMapRoutesKeysToShards(t *testing.T) {
+	// route keys by value, so the shard of each key is known
+	m := NewShardedMap[int, string](4, func(key int) uint64 { return uint64(key) }).(*shardedMap[int, string])
+	for key := 0; key < 8; key++ {
+		m.Set(key, fmt.Sprint(key))
+	}
+
+	for i, shard := range m.shards {
+		if shard.Len() != 2 {
+			t.Fatalf("shard %d holds %d keys; want 2", i, shard.Len())
+		}
+		for _, key := range []int{i, i + 4} {
+			if value, ok := shard.Get(key); !ok || value != fmt.Sprint(key) {
+				t.Fatalf("shard %d Get(%d) = %q, %t; want %q, true", i, key, value, ok, fmt.Sprint(key))
+			}
+		}
+	}
+
+	if value, ok := m.Delete(5); !ok || value != "5" {
+		t.Fatalf("Delete(5) = %q, %t; want \"5\", true", value, ok)
+	}
+	if m.shards[1].Len() != 1 || m.Len() != 7 {
+		t.Fatalf("after Delete(5): shard 1 holds %d keys, map holds %d; want 1 and 7", m.shards[1].Len(), m.Len())
+	}
+}
+
+func TestShardedMapRange(t *testing.T) {
+	m := DefaultShardedMap[int, int]()
+	for key := 0; key < 100; key++ {
+		m.Set(key, key*2)
+	}
+
+	seen := make(map[int]bool)
+	m.Range(func(key, value int) bool {
+		if value != key*2 {
+			t.Fatalf("Range yielded %d: %d; want %d", key, value, key*2)
+		}
+		seen[key] = true
+		// f may call other methods of the map
+		m.Set(key, value)
+		return true
+	})
+	if len(seen) != 100 {
+		t.Fatalf("Range yielded %d keys; want 100", len(seen))
+	}
+
+	calls := 0
+	m.Range(func(int, int) bool {
+		calls++
+		return calls < 3
+	})
+	if calls != 3 {
+		t.Fatalf("Range called f %d times after it returned false; want 3", calls)
+	}
+}
+
+func TestShardedMapCompute(t *testing.T) {
+	m := DefaultShardedMap[string, int]()
+	wg := &sync.WaitGroup{}
+	for i := 0; i < 100; i++ {
+		wg.Add(1)
+		go func() {
+			defer wg.Done()
+			for j := 0; j < 100; j++ {
+				m.Compute("counter", func(value int, ok bool) (int, bool) {
+					return value + 1, true
+				})
+			}
+		}()
+	}
+	wg.Wait()
+
+	if value, _ := m.Get("counter"); value != 10000 {
+		t.Fatalf("Get(\"counter\") = %d; want 10000", value)
+	}
+
+	value, ok := m.Compute("counter", func(int, bool) (int, bool) { return 0, false })
+	if ok || value != 0 {
+		t.Fatalf("Compute() deleting = %d, %t; want 0, false", value, ok)
+	}
+	if _, ok := m.Get("counter"); ok {
+		t.Fatal("Get(\"counter\") found a key deleted by Compute")
+	}
+}
+
+func TestDefaultHasherIsConsistentWithEquality(t *testing.T) {
+	floats := DefaultShardedMap[float64, string]()
+	floats.Set(0.0, "zero")
+	negativeZero := math.Copysign(0, -1)
+	if value, ok := floats.Get(negativeZero); !ok || value != "zero" {
+		t.Fatalf("Get(-0) = %q, %t; want \"zero\", true", value, ok)
+	}
+	floats.Set(negativeZero, "negative zero")
+	if floats.Len() != 1 {
+		t.Fatalf("Len() = %d; want 1", floats.Len())
+	}
+
+	type key struct {
+		Name string
+		ID   int
+	}
+	structs := DefaultShardedSet[key]()
+	for i := 0; i < 100; i++ {
+		structs.Set(key{Name: "key", ID: i})
+	}
+	for i := 0; i < 100; i++ {
+		if !structs.Exist(key{Name: "key", ID: i}) {
+			t.Fatalf("Exist(%d) = false; want true", i)
+		}
+	}
+	if structs.Len() != 100 {
+		t.Fatalf("Len() = %d; want 100", structs.Len())
+	}
+}
+
+func TestShardedSetRoutesKeysToShards(t *testing.T) {
+	// route keys by value, so the shard of each key is known
+	s := NewShardedSet[int](4, func(key int) uint64 { return uint64(key) }).(*shardedSet[int])
+	for key := 0; key < 8; key++ {
+		s.Set(key)
+	}
+
+	for i, shard := range s.shards {
+		if shard.Len() != 2 || !shard.Exist(i) || !shard.Exist(i+4) {
+			t.Fatalf("shard %d holds %v; want [%d %d]", i, shard.Values(), i, i+4)
+		}
+	}
+
+	if s.TrySet(5) {
+		t.Fatal("TrySet(5) = true for an existing key; want false")
+	}
+	if !s.Remove(5) {
+		t.Fatal("Remove(5) = false; want true")
+	}
+	if s.shards[1].Len() != 1 || s.Len() != 7 {
+		t.Fatalf("after Remove(5): shard 1 holds %d keys, set holds %d; want 1 and 7", s.shards[1].Len(), s.Len())
+	}
+}
+
+func TestShardedSetRange(t *testing.T) {
+	s := DefaultShardedSet[int]()
+	for key := 0; key < 100; key++ {
+		s.Set(key)
+	}
+
+	seen := make(map[int]bool)
+	s.Range(func(key int) bool {
+		seen[key] = true
+		// f may call other methods of the set
+		s.Set(key)
+		return true
+	})
+	if len(seen) != 100 {
+		t.Fatalf("Range yielded %d keys; want 100", len(seen))
+	}
+
+	calls := 0
+	s.Range(func(int) bool {
+		calls++
+		return calls < 3
+	})
+	if calls != 3 {
+		t.Fatalf("Range called f %d times after it returned false; want 3", calls)
+	}
+}
+
+func TestRWMapAndSetConcurrentAccess(t *testing.T) {
+	m := DefaultRWMap[int, int]()
+	s := DefaultRWSet[int]()
+	wg := &sync.WaitGroup{}
+	for i := 0; i < 10; i++ {
+		i := i
+		wg.Add(2)
+		// writers
+		go func() {
+			defer wg.Done()
+			for j := 0; j < 100; j++ {
+				m.Compute(j, func(value int, ok bool) (int, bool) {
+					return value + 1, true
+				})
+				s.Set(i*100 + j)
+			}
+		}()
+		// readers
+		go func() {
+			defer wg.Done()
+			for j := 0; j < 100; j++ {
+				m.Get(j)
+				m.Len()
+				s.Exist(j)
+				s.Values()
+			}
+		}()
+	}
+	wg.Wait()
+
+	if m.Len() != 100 || s.Len() != 1000 {
+		t.Fatalf("map holds %d keys, set holds %d; want 100 and 1000", m.Len(), s.Len())
+	}
+	m.Range(func(key, value int) bool {
+		if value != 10 {
+			t.Fatalf("Get(%d) = %d; want 10", key, value)
+		}
+		return true
+	})
+}
+
+// benchmarkContendedMap runs a read-mostly workload on m from 100 goroutines per CPU.
+func benchmarkContendedMap(b *testing.B, m Map[int, int]) {
+	const keys = 1024
+	for key := 0; key < keys; key++ {
+		m.Set(key, key)
+	}
+
+	b.SetParallelism(100)
+	b.ResetTimer()
+	b.RunParallel(func(pb *testing.PB) {
+		i := 0
+		for pb.Next() {
+			key := (i * 7919) % keys
+			if i%10 == 0 {
+				m.Set(key, i)
+			} else {
+				m.Get(key)
+			}
+			i++
+		}
+	})
+}
+
+func BenchmarkMapContended(b *testing.B) {
+	benchmarkContendedMap(b, DefaultMap[int, int]())
+}
+
+func BenchmarkRWMapContended(b *testing.B) {
+	benchmarkContendedMap(b, DefaultRWMap[int, int]())
+}
+
+func BenchmarkShardedMapContended(b *testing.B) {
+	benchmarkContendedMap(b, DefaultShardedMap[int, int]())
+}
+
+// benchmarkContendedMapCompute increments counters with Compute from 100 goroutines per CPU.
+func benchmarkContendedMapCompute(b *testing.B, m Map[int, int]) {
+	const keys = 1024
+	b.SetParallelism(100)
+	b.ResetTimer()
+	b.RunParallel(func(pb *testing.PB) {
+		i := 0
+		for pb.Next() {
+			m.Compute((i*7919)%keys, func(value int, ok bool) (int, bool) {
+				return value + 1, true
+			})
+			i++
+		}
+	})
+}
+
+func BenchmarkMapContendedCompute(b *testing.B) {
+	benchmarkContendedMapCompute(b, DefaultMap[int, int]())
+}
+
+func BenchmarkRWMapContendedCompute(b *testing.B) {
+	benchmarkContendedMapCompute(b, DefaultRWMap[int, int]())
+}
+
+func BenchmarkShardedMapContendedCompute(b *testing.B) {
+	benchmarkContendedMapCompute(b, DefaultShardedMap[int, int]())
+}
+
+// benchmarkContendedSet runs a read-mostly workload on s from 100 goroutines per CPU.
+func benchmarkContendedSet(b *testing.B, s Set[int]) {
+	const keys = 1024
+	for key := 0; key < keys; key += 2 {
+		s.Set(key)
+	}
+
+	b.SetParallelism(100)
+	b.ResetTimer()
+	b.RunParallel(func(pb *testing.PB) {
+		i := 0
+		for pb.Next() {
+			key := (i * 7919) % keys
+			if i%10 == 0 {
+				s.Set(key)
+			} else {
+				s.Exist(key)
+			}
+			i++
+		}
+	})
+}
+
+func BenchmarkSetContended(b *testing.B) {
+	benchmarkContendedSet(b, DefaultSet[int]())
+}
+
+func BenchmarkRWSetContended(b *testing.B) {
+	benchmarkContendedSet(b, DefaultRWSet[int]())
+}
+
+func BenchmarkShardedSetContended(b *testing.B) {
+	benchmarkContendedSet(b, DefaultShardedSet[int]())
+}
@@ -0,0 +1,405 @@
+/*
+Copyright 2023 Alexandre Mahdhaoui
+
+Licensed under the Apache License, Version 2.0 (the "License");
+you may not use this file except in compliance with the License.
+You may obtain a copy of the License at
+
+	http://www.apache.org/licenses/LICENSE-2.0
+
+Unless required by applicable law or agreed to in writing, software
+distributed under the License is distributed on an "AS IS" BASIS,
+WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+See the License for the specific language governing permissions and
+limitations under the License.
+*/
+
+package bda
+
+import (
+	"bufio"
+	"encoding/json"
+	"net"
+	"sync"
+	"time"
+)
+
+//----------------------------------------------------------------------------------------------------------------------
+//- TCPMap
+
+// TCPMap is an ObservableMap backed by a bucket of a KVServer (see DefaultTCPMap).
+type TCPMap[T comparable, I any] interface {
+	ObservableMap[T, I]
+	// Close method closes the connection of the map to the KVServer. Watchers have their own connection and are
+	// stopped separately. The next operation dials a new connection.
+	Close() Error
+}
+
+// tcpMap is an ObservableMap backed by a bucket of a KVServer, so processes can share state through the Map
+// interface. Keys and values are JSON-encoded: they must be JSON-serializable, and values are compared by their
+// encoding. The Map interface cannot return errors: operations failing because the server is unreachable are logged
+// through the logger of the client, reads behave as if the key did not exist, and writes are lost.
+type tcpMap[T comparable, I any] struct {
+	client *kvClient
+	Bucket string
+}
+
+func (m *tcpMap[T, I]) Get(key T) (I, bool) {
+	resp, ok := m.do(kvRequest{Op: kvOpMapGet}, key)
+	if !ok || !resp.Ok {
+		var null I
+		return null, false
+	}
+	return decodeKVValue[I](resp.Value)
+}
+
+func (m *tcpMap[T, I]) Set(key T, value I) (T, I) {
+	encoded, err := json.Marshal(value)
+	if err != nil {
+		LogWarnf(m.client, LogOperationRun, LogStatusFailed, "cannot encode value of bucket %s; write lost; %v", m.Bucket, err)
+		return key, value
+	}
+	if _, ok := m.do(kvRequest{Op: kvOpMapSet, Value: encoded}, key); !ok {
+		LogWarnf(m.client, LogOperationRun, LogStatusFailed, "write to bucket %s lost", m.Bucket)
+	}
+	return key, value
+}
+
+func (m *tcpMap[T, I]) Delete(key T) (I, bool) {
+	resp, ok := m.do(kvRequest{Op: kvOpMapDelete}, key)
+	if !ok || !resp.Ok {
+		var null I
+		return null, false
+	}
+	return decodeKVValue[I](resp.Value)
+}
+
+func (m *tcpMap[T, I]) Len() int {
+	resp, err := m.client.do(kvRequest{Op: kvOpMapLen, Bucket: m.Bucket})
+	if err != nil {
+		return 0
+	}
+	return resp.Count
+}
+
+func (m *tcpMap[T, I]) Range(f func(key T, value I) bool) {
+	for key, value := range m.Snapshot() {
+		if !f(key, value) {
+			return
+		}
+	}
+}
+
+// Snapshot method returns a copy of the bucket. Entries that cannot be decoded are skipped.
+func (m *tcpMap[T, I]) Snapshot() map[T]I {
+	snapshot := make(map[T]I)
+	resp, err := m.client.do(kvRequest{Op: kvOpMapEntries, Bucket: m.Bucket})
+	if err != nil {
+		return snapshot
+	}
+	for _, entry := range resp.Entries {
+		key, ok := decodeKVKey[T](entry.Key)
+		if !ok {
+			continue
+		}
+		if value, ok := decodeKVValue[I](entry.Value); ok {
+			snapshot[key] = value
+		}
+	}
+	return snapshot
+}
+
+// LoadOrStore method returns the zero value and true if the value could not be stored, e.g. because the server is
+// unreachable: callers must not assume their value was stored.
+func (m *tcpMap[T, I]) LoadOrStore(key T, value I) (I, bool) {
+	var null I
+	encoded, err := json.Marshal(value)
+	if err != nil {
+		LogWarnf(m.client, LogOperationRun, LogStatusFailed, "cannot encode value of bucket %s; write lost; %v", m.Bucket, err)
+		return null, true
+	}
+	resp, ok := m.do(kvRequest{Op: kvOpMapLoadOrStore, Value: encoded}, key)
+	if !ok {
+		LogWarnf(m.client, LogOperationRun, LogStatusFailed, "write to bucket %s lost", m.Bucket)
+		return null, true
+	}
+	if !resp.Ok {
+		return value, false
+	}
+	actual, _ := decodeKVValue[I](resp.Value)
+	return actual, true
+}
+
+// CompareAndSwap method swaps the value of a key if its current value has the same JSON encoding as old. Unlike the
+// in-memory Map, it does not panic on values that are not comparable.
+func (m *tcpMap[T, I]) CompareAndSwap(key T, old, new I) bool {
+	encodedOld, err := json.Marshal(old)
+	if err != nil {
+		return false
+	}
+	encodedNew, err := json.Marshal(new)
+	if err != nil {
+		return false
+	}
+	resp, ok := m.do(kvRequest{Op: kvOpMapCompareAndSwap, Old: encodedOld, Value: encodedNew}, key)
+	return ok && resp.Ok
+}
+
+// Compute method is implemented optimistically: fn may be called several times if the key is changed concurrently,
+// and must not have side effects.
+func (m *tcpMap[T, I]) Compute(key T, fn func(value I, ok bool) (I, bool)) (I, bool) {
+	var null I
+	for {
+		resp, ok := m.do(kvRequest{Op: kvOpMapGet}, key)
+		if !ok {
+			return null, false
+		}
+		current, exists := null, resp.Ok
+		if exists {
+			if current, ok = decodeKVValue[I](resp.Value); !ok {
+				return null, false
+			}
+		}
+
+		value, keep := fn(current, exists)
+		switch {
+		case keep && exists:
+			encoded, err := json.Marshal(value)
+			if err != nil {
+				return null, false
+			}
+			swapped, ok := m.do(kvRequest{Op: kvOpMapCompareAndSwap, Old: resp.Value, Value: encoded}, key)
+			if !ok {
+				return null, false
+			}
+			if swapped.Ok {
+				return value, true
+			}
+		case keep:
+			encoded, err := json.Marshal(value)
+			if err != nil {
+				return null, false
+			}
+			loaded, ok := m.do(kvRequest{Op: kvOpMapLoadOrStore, Value: encoded}, key)
+			if !ok {
+				return null, false
+			}
+			if !loaded.Ok {
+				return value, true
+			}
+		case exists:
+			deleted, ok := m.do(kvRequest{Op: kvOpMapCompareAndDelete, Old: resp.Value}, key)
+			if !ok || deleted.Ok {
+				return null, false
+			}
+		default:
+			return null, false
+		}
+	}
+}
+
+// Watch method opens a dedicated connection to the KVServer streaming the changes of the bucket. The Watcher is
+// stopped, and its channel closed, when the connection is lost. Events dropped by the KVServer are signaled with a
+// WatchEventResync event, but are not accounted for in Dropped.
+func (m *tcpMap[T, I]) Watch(filter WatchFilter[T], opts ...WatchOption) Watcher[T, I] {
+	o := newWatchOptions(opts)
+	w := &tcpWatcher[T, I]{
+		watchChannel: newWatchChannel[T, I](o.BufferSize),
+		filter:       filter,
+		once:         &sync.Once{},
+	}
+
+	conn, err := net.DialTimeout("tcp", m.client.Addr, defaultKVDialTimeout)
+	if err != nil {
+		LogWarnf(m.client, LogOperationRun, LogStatusFailed, "cannot watch bucket %s; %v", m.Bucket, err)
+		close(w.channel)
+		return w
+	}
+	w.conn = conn
+
+	// only the handshake has a deadline: the connection then waits for events indefinitely
+	decoder := json.NewDecoder(bufio.NewReader(conn))
+	var resp kvResponse
+	conn.SetDeadline(time.Now().Add(m.client.Timeout))
+	if err := json.NewEncoder(conn).Encode(kvRequest{Op: kvOpWatch, Bucket: m.Bucket}); err != nil {
+		LogWarnf(m.client, LogOperationRun, LogStatusFailed, "cannot watch bucket %s; %v", m.Bucket, err)
+		conn.Close()
+		close(w.channel)
+		return w
+	}
+	if err := decoder.Decode(&resp); err != nil || !resp.Ok {
+		LogWarnf(m.client, LogOperationRun, LogStatusFailed, "cannot watch bucket %s; %v", m.Bucket, err)
+		conn.Close()
+		close(w.channel)
+		return w
+	}
+	conn.SetDeadline(time.Time{})
+
+	go w.receive(decoder)
+	return w
+}
+
+func (m *tcpMap[T, I]) Close() Error {
+	return m.client.Stop()
+}
+
+// do encodes the key of a map operation and sends it. Returns false if the request could not be sent; the failure is
+// logged.
+func (m *tcpMap[T, I]) do(req kvRequest, key T) (kvResponse, bool) {
+	encoded, err := json.Marshal(key)
+	if err != nil {
+		LogWarnf(m.client, LogOperationRun, LogStatusFailed, "cannot encode key of bucket %s; %v", m.Bucket, err)
+		return kvResponse{}, false
+	}
+	req.Bucket, req.Key = m.Bucket, string(encoded)
+	resp, e := m.client.do(req)
+	return resp, e == nil
+}
+
+func decodeKVKey[T comparable](encoded string) (T, bool) {
+	var key T
+	if err := json.Unmarshal([]byte(encoded), &key); err != nil {
+		return key, false
+	}
+	return key, true
+}
+
+func decodeKVValue[I any](encoded json.RawMessage) (I, bool) {
+	var value I
+	if err := json.Unmarshal(encoded, &value); err != nil {
+		return value, false
+	}
+	return value, true
+}
+
+// DefaultTCPMap function returns a new TCPMap backed by the bucket of the KVServer listening on addr.
+// Maps using the same server and bucket share their content. Call Close to release the connection of the map.
+func DefaultTCPMap[T comparable, I any](addr, bucket string) TCPMap[T, I] {
+	return &tcpMap[T, I]{
+		client: newKVClient(addr),
+		Bucket: bucket,
+	}
+}
+
+//----------------------------------------------------------------------------------------------------------------------
+//- TCPSet
+
+// TCPSet is an ObservableSet backed by a bucket of a KVServer (see DefaultTCPSet).
+type TCPSet[T comparable] interface {
+	ObservableSet[T]
+	// Close method closes the connection of the set to the KVServer. See TCPMap.
+	Close() Error
+}
+
+// tcpSet is an ObservableSet backed by a bucket of a KVServer. See tcpMap.
+type tcpSet[T comparable] struct {
+	m *tcpMap[T, struct{}]
+}
+
+func (s *tcpSet[T]) Exist(key T) bool {
+	_, ok := s.m.Get(key)
+	return ok
+}
+
+func (s *tcpSet[T]) Set(key T) {
+	s.m.Set(key, struct{}{})
+}
+
+// TrySet method returns true if the key was set, false if it was already present. If the server is unreachable, the
+// failure is logged and TrySet returns true: callers deduplicating work may process a key twice, but never drop it.
+func (s *tcpSet[T]) TrySet(key T) bool {
+	resp, ok := s.m.do(kvRequest{Op: kvOpMapLoadOrStore, Value: json.RawMessage("{}")}, key)
+	if !ok {
+		LogWarnf(s.m.client, LogOperationRun, LogStatusFailed, "cannot set key of bucket %s; assuming it is not set", s.m.Bucket)
+		return true
+	}
+	return !resp.Ok
+}
+
+func (s *tcpSet[T]) Remove(key T) bool {
+	_, ok := s.m.Delete(key)
+	return ok
+}
+
+func (s *tcpSet[T]) Len() int {
+	return s.m.Len()
+}
+
+func (s *tcpSet[T]) Range(f func(key T) bool) {
+	s.m.Range(func(key T, _ struct{}) bool {
+		return f(key)
+	})
+}
+
+func (s *tcpSet[T]) Values() []T {
+	snapshot := s.m.Snapshot()
+	values := make([]T, 0, len(snapshot))
+	for key := range snapshot {
+		values = append(values, key)
+	}
+	return values
+}
+
+func (s *tcpSet[T]) Watch(filter WatchFilter[T], opts ...WatchOption) Watcher[T, struct{}] {
+	return s.m.Watch(filter, opts...)
+}
+
+func (s *tcpSet[T]) Close() Error {
+	return s.m.Close()
+}
+
+// DefaultTCPSet function returns a new TCPSet backed by the bucket of the KVServer listening on addr.
+// Sets using the same server and bucket share their content. Call Close to release the connection of the set.
+func DefaultTCPSet[T comparable](addr, bucket string) TCPSet[T] {
+	return &tcpSet[T]{
+		m: &tcpMap[T, struct{}]{
+			client: newKVClient(addr),
+			Bucket: bucket,
+		},
+	}
+}
+
+//----------------------------------------------------------------------------------------------------------------------
+//- tcpWatcher
+
+// tcpWatcher is a Watcher receiving events from a watch connection to a KVServer. Keys are filtered client-side.
+type tcpWatcher[T comparable, I any] struct {
+	watchChannel[T, I]
+	filter WatchFilter[T]
+	conn   net.Conn
+	once   *sync.Once
+}
+
+// Stop method closes the watch connection. The channel is closed once the receiving goroutine returns.
+func (w *tcpWatcher[T, I]) Stop() {
+	w.once.Do(func() {
+		if w.conn != nil {
+			w.conn.Close()
+		}
+	})
+}
+
+func (w *tcpWatcher[T, I]) receive(decoder *json.Decoder) {
+	defer close(w.channel)
+	for {
+		var resp kvResponse
+		if err := decoder.Decode(&resp); err != nil {
+			w.Stop()
+			return
+		}
+		if resp.Event == nil {
+			continue
+		}
+		if resp.Event.Type == WatchEventResync {
+			w.resync()
+			continue
+		}
+		key, ok := decodeKVKey[T](resp.Event.Key)
+		if !ok || !w.filter(key) {
+			continue
+		}
+		value, _ := decodeKVValue[I](resp.Event.Value)
+
+		w.send(WatchEvent[T, I]{Type: resp.Event.Type, Key: key, Value: value})
+	}
+}
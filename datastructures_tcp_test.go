@@ -0,0 +1,122 @@
+/*
+Copyright 2023 Alexandre Mahdhaoui
+
+Licensed under the Apache License, Version 2.0 (the "License");
+you may not use this file except in compliance with the License.
+You may obtain a copy of the License at
+
+	http://www.apache.org/licenses/LICENSE-2.0
+
+Unless required by applicable law or agreed to in writing, software
+distributed under the License is distributed on an "AS IS" BASIS,
+WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+See the License for the specific language governing permissions and
+limitations under the License.
+*/
+
+package bda
+
+import (
+	"context"
+	"fmt"
+	"net"
+	"testing"
+	"time"
+)
+
+func TestTCPMapLoadOrStore(t *testing.T) {
+	s := startKVServer(t)
+	m := DefaultTCPMap[string, int](s.ListenAddr(), "bucket")
+	defer m.Close()
+
+	if value, loaded := m.LoadOrStore("key", 1); loaded || value != 1 {
+		t.Fatalf("LoadOrStore() = %d, %t; want 1, false", value, loaded)
+	}
+	if value, loaded := m.LoadOrStore("key", 2); !loaded || value != 1 {
+		t.Fatalf("LoadOrStore() = %d, %t; want 1, true", value, loaded)
+	}
+
+	// the connection is dialed again after Close
+	if err := m.Close(); err != nil {
+		t.Fatalf("Close() error = %v", err)
+	}
+	if value, ok := m.Get("key"); !ok || value != 1 {
+		t.Fatalf("Get() = %d, %t; want 1, true", value, ok)
+	}
+}
+
+func TestTCPMapLoadOrStoreUnreachable(t *testing.T) {
+	// reserve a port, then free it so nothing listens on it
+	listener, err := net.Listen("tcp", "127.0.0.1:0")
+	if err != nil {
+		t.Fatalf("Listen() error = %v", err)
+	}
+	addr := listener.Addr().String()
+	listener.Close()
+
+	m := DefaultTCPMap[string, int](addr, "bucket")
+	defer m.Close()
+	// nothing was stored: the value must not be reported as stored
+	if value, loaded := m.LoadOrStore("key", 1); !loaded || value != 0 {
+		t.Fatalf("LoadOrStore() = %d, %t; want 0, true", value, loaded)
+	}
+}
+
+func TestTCPWatcherOverflowSendsOneResync(t *testing.T) {
+	s := startKVServer(t)
+	m := DefaultTCPMap[string, int](s.ListenAddr(), "bucket")
+	defer m.Close()
+	w := m.Watch(WatchAll[string](), WithWatchBufferSize(2))
+	defer w.Stop()
+
+	for i := 0; i < 5; i++ {
+		m.Set(fmt.Sprintf("key-%d", i), i)
+	}
+	// events are received asynchronously: wait for the watcher to drop the last ones and buffer the resync
+	deadline := time.Now().Add(5 * time.Second)
+	for w.Dropped() < 3 || len(w.Receiver()) < 3 {
+		if time.Now().After(deadline) {
+			t.Fatalf("Dropped() = %d; want 3", w.Dropped())
+		}
+		time.Sleep(time.Millisecond)
+	}
+	assertWatchEvents(t, w, WatchEventPut, WatchEventPut, WatchEventResync)
+}
+
+func TestTCPWatcherStopIsIdempotent(t *testing.T) {
+	s := startKVServer(t)
+	m := DefaultTCPMap[string, int](s.ListenAddr(), "bucket")
+	defer m.Close()
+	w := m.Watch(WatchAll[string]())
+
+	w.Stop()
+	w.Stop()
+	select {
+	case _, ok := <-w.Receiver():
+		if ok {
+			t.Fatalf("Receiver() is open after Stop()")
+		}
+	case <-time.After(5 * time.Second):
+		t.Fatalf("Receiver() was not closed after Stop()")
+	}
+}
+
+// startKVServer starts a KVServer on a free port, stopped at the end of the test.
+func startKVServer(t *testing.T) *KVServer {
+	t.Helper()
+	ctx, cancel := context.WithCancel(context.Background())
+	s := DefaultKVServer("kv", "127.0.0.1:0", ctx)
+	if err := s.Init(); err != nil {
+		t.Fatalf("Init() error = %v", err)
+	}
+	ran := make(chan struct{})
+	go func() {
+		defer close(ran)
+		s.Run()
+	}()
+	t.Cleanup(func() {
+		cancel()
+		<-ran
+	})
+	return s
+}
@@ -0,0 +1,548 @@
+/*
+Copyright 2023 Alexandre Mahdhaoui
+
+Licensed under the Apache License, Version 2.0 (the "License");
+you may not use this file except in compliance with the License.
+You may obtain a copy of the License at
+
+	http://www.apache.org/licenses/LICENSE-2.0
+
+Unless required by applicable law or agreed to in writing, software
+distributed under the License is distributed on an "AS IS" BASIS,
+WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+See the License for the specific language governing permissions and
+limitations under the License.
+*/
+
+package bda
+
+import (
+	"context"
+	"fmt"
+	"reflect"
+	"sort"
+	"sync"
+	"testing"
+	"time"
+)
+
+// safeArrayImplementations returns a constructor for every SafeArray implementation.
+func safeArrayImplementations() []struct {
+	name  string
+	build func(items ...int) SafeArray[int]
+} {
+	return []struct {
+		name  string
+		build func(items ...int) SafeArray[int]
+	}{
+		{name: "in-memory", build: func(items ...int) SafeArray[int] {
+			a := DefaultSafeArray[int]()
+			for _, item := range items {
+				a.Append(item)
+			}
+			return a
+		}},
+		{name: "copy-on-write", build: func(items ...int) SafeArray[int] {
+			a := DefaultCOWSafeArray[int]()
+			for _, item := range items {
+				a.Append(item)
+			}
+			return a
+		}},
+	}
+}
+
+func TestSafeArrayBounds(t *testing.T) {
+	for _, impl := range safeArrayImplementations() {
+		t.Run(impl.name, func(t *testing.T) {
+			for _, tc := range []struct {
+				name  string
+				index int
+				ok    bool
+			}{
+				{name: "first", index: 0, ok: true},
+				{name: "last", index: 2, ok: true},
+				{name: "length", index: 3, ok: false},
+				{name: "negative", index: -1, ok: false},
+			} {
+				t.Run(tc.name, func(t *testing.T) {
+					a := impl.build(10, 20, 30)
+					item, ok := a.Get(tc.index)
+					if ok != tc.ok {
+						t.Fatalf("Get(%d) = %d, %t; want ok %t", tc.index, item, ok, tc.ok)
+					}
+					if !ok && item != 0 {
+						t.Fatalf("Get(%d) = %d; want the zero value", tc.index, item)
+					}
+					if ok := a.Set(tc.index, 99); ok != tc.ok {
+						t.Fatalf("Set(%d) = %t; want %t", tc.index, ok, tc.ok)
+					}
+					if a.Length() != 3 {
+						t.Fatalf("Length() = %d after Set(%d); want 3", a.Length(), tc.index)
+					}
+				})
+			}
+		})
+	}
+}
+
+func TestSafeArraySlice(t *testing.T) {
+	for _, impl := range safeArrayImplementations() {
+		t.Run(impl.name, func(t *testing.T) {
+			a := impl.build(0, 1, 2, 3, 4, 5)
+			for _, tc := range []struct {
+				name             string
+				start, end, step int
+				want             []int
+			}{
+				{name: "forward", start: 1, end: 4, step: 1, want: []int{1, 2, 3}},
+				{name: "step", start: 0, end: 6, step: 2, want: []int{0, 2, 4}},
+				{name: "reverse", start: 5, end: -1, step: -1, want: []int{5, 4, 3, 2, 1, 0}},
+				{name: "end out of range", start: 4, end: 10, step: 1, want: []int{4, 5}},
+				{name: "zero step", start: 0, end: 6, step: 0, want: []int{}},
+			} {
+				t.Run(tc.name, func(t *testing.T) {
+					if got := a.Slice(tc.start, tc.end, tc.step).Snapshot(); !reflect.DeepEqual(got, tc.want) {
+						t.Fatalf("Slice(%d, %d, %d) = %v; want %v", tc.start, tc.end, tc.step, got, tc.want)
+					}
+				})
+			}
+		})
+	}
+}
+
+func TestSafeArraySliceConcurrentWrites(t *testing.T) {
+	for _, impl := range safeArrayImplementations() {
+		t.Run(impl.name, func(t *testing.T) {
+			a := impl.build(0, 1, 2, 3)
+			wg := &sync.WaitGroup{}
+			for i := 0; i < 10; i++ {
+				wg.Add(2)
+				go func() {
+					defer wg.Done()
+					for j := 0; j < 100; j++ {
+						a.Append(j)
+						a.Set(0, j)
+					}
+				}()
+				go func() {
+					defer wg.Done()
+					for j := 0; j < 100; j++ {
+						// Slice must not deadlock nor race with writers
+						if s := a.Slice(0, a.Length()); s.Length() < 4 {
+							t.Errorf("Slice() holds %d items; want at least 4", s.Length())
+							return
+						}
+					}
+				}()
+			}
+			wg.Wait()
+			if a.Length() != 1004 {
+				t.Fatalf("Length() = %d; want 1004", a.Length())
+			}
+		})
+	}
+}
+
+func TestQueueSendAfterStop(t *testing.T) {
+	q := DefaultQueueWithCapacity[int]("queue", context.Background(), 1)
+	q.Init()
+	if err := q.Stop(); err != nil {
+		t.Fatalf("Stop() error = %v", err)
+	}
+
+	if err := q.Send(1); err == nil || err.Type != ErrorTypeQueueClosed {
+		t.Fatalf("Send() error = %v; want %s", err, ErrorTypeQueueClosed)
+	}
+	if err := q.TrySend(1, 0); err == nil || err.Type != ErrorTypeQueueClosed {
+		t.Fatalf("TrySend() error = %v; want %s", err, ErrorTypeQueueClosed)
+	}
+	if err := q.RegisterProducer(); err == nil || err.Type != ErrorTypeQueueClosed {
+		t.Fatalf("RegisterProducer() error = %v; want %s", err, ErrorTypeQueueClosed)
+	}
+	if !q.IsClosed() {
+		t.Fatalf("IsClosed() = false after Stop()")
+	}
+}
+
+func TestQueueStopIsIdempotent(t *testing.T) {
+	q := DefaultQueueWithCapacity[int]("queue", context.Background(), 1)
+	q.Init()
+
+	wg := &sync.WaitGroup{}
+	for i := 0; i < 10; i++ {
+		wg.Add(1)
+		go func() {
+			defer wg.Done()
+			if err := q.Stop(); err != nil {
+				t.Errorf("Stop() error = %v", err)
+			}
+		}()
+	}
+	wg.Wait()
+	if err := q.Stop(); err != nil {
+		t.Fatalf("Stop() error = %v", err)
+	}
+	if _, ok := q.Receive(); ok {
+		t.Fatalf("Receive() = true after Stop(); want false")
+	}
+}
+
+func TestQueueReceiveDrainsAfterStopWhileProducersAreRegistered(t *testing.T) {
+	q := DefaultQueueWithCapacity[int]("queue", context.Background(), 2)
+	q.Init()
+	for i := 1; i <= 2; i++ {
+		if err := q.Send(i); err != nil {
+			t.Fatalf("Send(%d) error = %v", i, err)
+		}
+	}
+	if err := q.RegisterProducer(); err != nil {
+		t.Fatalf("RegisterProducer() error = %v", err)
+	}
+
+	stopped := make(chan struct{})
+	go func() {
+		defer close(stopped)
+		q.Stop()
+	}()
+	<-q.Done()
+
+	// the queue rejects new items, but buffered items can still be received
+	if err := q.Send(3); err == nil || err.Type != ErrorTypeQueueClosed {
+		t.Fatalf("Send() error = %v; want %s", err, ErrorTypeQueueClosed)
+	}
+	for i := 1; i <= 2; i++ {
+		if item, ok := q.Receive(); !ok || item != i {
+			t.Fatalf("Receive() = %d, %t; want %d, true", item, ok, i)
+		}
+	}
+
+	// the channel is only closed once the producer unregistered
+	select {
+	case <-stopped:
+		t.Fatalf("Stop() returned while a producer is registered")
+	case <-time.After(20 * time.Millisecond):
+	}
+	q.UnregisterProducer()
+	<-stopped
+	if _, ok := q.Receive(); ok {
+		t.Fatalf("Receive() = true after the queue is drained and stopped; want false")
+	}
+}
+
+func TestQueueTrySendRejectsWhenFull(t *testing.T) {
+	q := DefaultQueueWithCapacity[int]("queue", context.Background(), 1)
+	q.Init()
+	if err := q.TrySend(1, 0); err != nil {
+		t.Fatalf("TrySend(1) error = %v", err)
+	}
+
+	if err := q.TrySend(2, 0); err == nil || err.Type != ErrorTypeQueueFull {
+		t.Fatalf("TrySend(2) error = %v; want %s", err, ErrorTypeQueueFull)
+	}
+	if err := q.TrySend(2, 10*time.Millisecond); err == nil || err.Type != ErrorTypeQueueFull {
+		t.Fatalf("TrySend(2, 10ms) error = %v; want %s", err, ErrorTypeQueueFull)
+	}
+	if item, ok := q.Receive(); !ok || item != 1 {
+		t.Fatalf("Receive() = %d, %t; want 1, true", item, ok)
+	}
+}
+
+func TestQueueStats(t *testing.T) {
+	q := DefaultQueueWithCapacity[int]("queue", context.Background(), 3)
+	q.Init()
+
+	for i := 0; i < 2; i++ {
+		if err := q.Send(i); err != nil {
+			t.Fatalf("Send(%d) error = %v", i, err)
+		}
+	}
+	if err := q.TrySend(2, 0); err != nil {
+		t.Fatalf("TrySend(2) error = %v", err)
+	}
+	// the queue is full: both items are rejected
+	q.TrySend(3, 0)
+	q.TrySend(4, time.Millisecond)
+	q.Receive()
+	q.TryReceive(0)
+	q.ReceiveContext(context.Background())
+	// the queue is empty: nothing is dequeued
+	q.TryReceive(0)
+
+	want := QueueStats{Length: 0, Capacity: 3, Enqueued: 3, Dequeued: 3, Rejected: 2}
+	if got := q.Stats(); got != want {
+		t.Fatalf("Stats() = %+v; want %+v", got, want)
+	}
+
+	if err := q.Send(5); err != nil {
+		t.Fatalf("Send(5) error = %v", err)
+	}
+	want = QueueStats{Length: 1, Capacity: 3, Enqueued: 4, Dequeued: 3, Rejected: 2}
+	if got := q.Stats(); got != want {
+		t.Fatalf("Stats() = %+v; want %+v", got, want)
+	}
+}
+
+// mapImplementations returns a constructor for every Map implementation. Each call returns a new, empty Map.
+func mapImplementations(t *testing.T) []struct {
+	name  string
+	build func() Map[string, int]
+} {
+	s := startKVServer(t)
+	buckets := 0
+	return []struct {
+		name  string
+		build func() Map[string, int]
+	}{
+		{name: "in-memory", build: DefaultMap[string, int]},
+		{name: "read-write", build: DefaultRWMap[string, int]},
+		{name: "sharded", build: DefaultShardedMap[string, int]},
+		{name: "ttl", build: func() Map[string, int] {
+			return DefaultTTLMap[string, int]("ttl", context.Background(), time.Hour, nil)
+		}},
+		{name: "lru", build: func() Map[string, int] { return DefaultLRUCache[string, int](1024, nil) }},
+		{name: "lfu", build: func() Map[string, int] { return DefaultLFUCache[string, int](1024, nil) }},
+		{name: "observable", build: func() Map[string, int] { return DefaultObservableMap(DefaultMap[string, int]()) }},
+		{name: "tcp", build: func() Map[string, int] {
+			buckets++
+			m := DefaultTCPMap[string, int](s.ListenAddr(), fmt.Sprintf("map-%d", buckets))
+			t.Cleanup(func() { m.Close() })
+			return m
+		}},
+	}
+}
+
+func TestMapContract(t *testing.T) {
+	for _, impl := range mapImplementations(t) {
+		t.Run(impl.name, func(t *testing.T) {
+			t.Run("get set delete", func(t *testing.T) {
+				m := impl.build()
+				if value, ok := m.Get("a"); ok || value != 0 {
+					t.Fatalf("Get() of a missing key = %d, %t; want 0, false", value, ok)
+				}
+				if key, value := m.Set("a", 1); key != "a" || value != 1 {
+					t.Fatalf("Set() = %q, %d; want \"a\", 1", key, value)
+				}
+				m.Set("b", 2)
+				m.Set("a", 3)
+				if value, ok := m.Get("a"); !ok || value != 3 {
+					t.Fatalf("Get() = %d, %t; want 3, true", value, ok)
+				}
+				if m.Len() != 2 {
+					t.Fatalf("Len() = %d; want 2", m.Len())
+				}
+				if value, ok := m.Delete("a"); !ok || value != 3 {
+					t.Fatalf("Delete() = %d, %t; want 3, true", value, ok)
+				}
+				if _, ok := m.Delete("a"); ok {
+					t.Fatalf("Delete() of a missing key = true; want false")
+				}
+				if got, want := m.Snapshot(), map[string]int{"b": 2}; !reflect.DeepEqual(got, want) {
+					t.Fatalf("Snapshot() = %v; want %v", got, want)
+				}
+			})
+
+			t.Run("range", func(t *testing.T) {
+				m := impl.build()
+				for i, key := range []string{"a", "b", "c"} {
+					m.Set(key, i)
+				}
+				seen := make(map[string]int)
+				m.Range(func(key string, value int) bool {
+					seen[key] = value
+					// f may call other methods of the map
+					m.Set(key, value+10)
+					return true
+				})
+				if want := map[string]int{"a": 0, "b": 1, "c": 2}; !reflect.DeepEqual(seen, want) {
+					t.Fatalf("Range yielded %v; want %v", seen, want)
+				}
+				if value, _ := m.Get("b"); value != 11 {
+					t.Fatalf("Get() after Range = %d; want 11", value)
+				}
+
+				calls := 0
+				m.Range(func(string, int) bool {
+					calls++
+					return false
+				})
+				if calls != 1 {
+					t.Fatalf("Range called f %d times after it returned false; want 1", calls)
+				}
+			})
+
+			t.Run("load or store", func(t *testing.T) {
+				m := impl.build()
+				if value, loaded := m.LoadOrStore("a", 1); loaded || value != 1 {
+					t.Fatalf("LoadOrStore() of a missing key = %d, %t; want 1, false", value, loaded)
+				}
+				if value, loaded := m.LoadOrStore("a", 2); !loaded || value != 1 {
+					t.Fatalf("LoadOrStore() of an existing key = %d, %t; want 1, true", value, loaded)
+				}
+			})
+
+			t.Run("compare and swap", func(t *testing.T) {
+				m := impl.build()
+				if m.CompareAndSwap("a", 0, 1) {
+					t.Fatalf("CompareAndSwap() of a missing key = true; want false")
+				}
+				m.Set("a", 1)
+				if m.CompareAndSwap("a", 2, 3) {
+					t.Fatalf("CompareAndSwap() with another old value = true; want false")
+				}
+				if !m.CompareAndSwap("a", 1, 3) {
+					t.Fatalf("CompareAndSwap() = false; want true")
+				}
+				if value, _ := m.Get("a"); value != 3 {
+					t.Fatalf("Get() after CompareAndSwap() = %d; want 3", value)
+				}
+			})
+
+			t.Run("compute", func(t *testing.T) {
+				m := impl.build()
+				increment := func(value int, ok bool) (int, bool) {
+					return value + 1, true
+				}
+				if value, ok := m.Compute("a", increment); !ok || value != 1 {
+					t.Fatalf("Compute() of a missing key = %d, %t; want 1, true", value, ok)
+				}
+				if value, ok := m.Compute("a", increment); !ok || value != 2 {
+					t.Fatalf("Compute() = %d, %t; want 2, true", value, ok)
+				}
+				if value, ok := m.Compute("a", func(int, bool) (int, bool) { return 0, false }); ok || value != 0 {
+					t.Fatalf("Compute() deleting = %d, %t; want 0, false", value, ok)
+				}
+				if _, ok := m.Get("a"); ok {
+					t.Fatalf("Get() found a key deleted by Compute()")
+				}
+				if _, ok := m.Compute("b", func(int, bool) (int, bool) { return 0, false }); ok || m.Len() != 0 {
+					t.Fatalf("Compute() not storing a missing key = %t, Len() = %d; want false, 0", ok, m.Len())
+				}
+			})
+		})
+	}
+}
+
+// setImplementations returns a constructor for every Set implementation. Each call returns a new, empty Set.
+func setImplementations(t *testing.T) []struct {
+	name  string
+	build func() Set[string]
+} {
+	s := startKVServer(t)
+	buckets := 0
+	return []struct {
+		name  string
+		build func() Set[string]
+	}{
+		{name: "in-memory", build: DefaultSet[string]},
+		{name: "read-write", build: DefaultRWSet[string]},
+		{name: "sharded", build: DefaultShardedSet[string]},
+		{name: "ttl", build: func() Set[string] {
+			return DefaultTTLSet[string]("ttl", context.Background(), time.Hour, nil)
+		}},
+		{name: "observable", build: func() Set[string] { return DefaultObservableSet(DefaultSet[string]()) }},
+		{name: "tcp", build: func() Set[string] {
+			buckets++
+			set := DefaultTCPSet[string](s.ListenAddr(), fmt.Sprintf("set-%d", buckets))
+			t.Cleanup(func() { set.Close() })
+			return set
+		}},
+	}
+}
+
+func TestSetContract(t *testing.T) {
+	for _, impl := range setImplementations(t) {
+		t.Run(impl.name, func(t *testing.T) {
+			// build returns a new Set of the implementation holding keys
+			build := func(keys ...string) Set[string] {
+				set := impl.build()
+				for _, key := range keys {
+					set.Set(key)
+				}
+				return set
+			}
+
+			t.Run("set remove", func(t *testing.T) {
+				set := build("a")
+				if !set.Exist("a") || set.Exist("b") {
+					t.Fatalf("Exist(a), Exist(b) = %t, %t; want true, false", set.Exist("a"), set.Exist("b"))
+				}
+				if set.TrySet("a") {
+					t.Fatalf("TrySet() of an existing key = true; want false")
+				}
+				if !set.TrySet("b") {
+					t.Fatalf("TrySet() of a missing key = false; want true")
+				}
+				set.Set("b")
+				if set.Len() != 2 {
+					t.Fatalf("Len() = %d; want 2", set.Len())
+				}
+				if !set.Remove("a") {
+					t.Fatalf("Remove() of an existing key = false; want true")
+				}
+				if set.Remove("a") {
+					t.Fatalf("Remove() of a missing key = true; want false")
+				}
+				if got := sortedValues(set); !reflect.DeepEqual(got, []string{"b"}) {
+					t.Fatalf("Values() = %v; want [b]", got)
+				}
+			})
+
+			t.Run("range", func(t *testing.T) {
+				set := build("a", "b", "c")
+				seen := make([]string, 0)
+				set.Range(func(key string) bool {
+					seen = append(seen, key)
+					// f may call other methods of the set
+					set.Remove(key)
+					return true
+				})
+				sort.Strings(seen)
+				if want := []string{"a", "b", "c"}; !reflect.DeepEqual(seen, want) {
+					t.Fatalf("Range yielded %v; want %v", seen, want)
+				}
+				if set.Len() != 0 {
+					t.Fatalf("Len() after removing every key = %d; want 0", set.Len())
+				}
+
+				set = build("a", "b", "c")
+				calls := 0
+				set.Range(func(string) bool {
+					calls++
+					return false
+				})
+				if calls != 1 {
+					t.Fatalf("Range called f %d times after it returned false; want 1", calls)
+				}
+			})
+
+			t.Run("algebra", func(t *testing.T) {
+				a, b := build("a", "b", "c"), build("b", "c", "d")
+				for _, tc := range []struct {
+					name string
+					got  Set[string]
+					want []string
+				}{
+					{name: "union", got: SetUnion(a, b), want: []string{"a", "b", "c", "d"}},
+					{name: "intersection", got: SetIntersection(a, b), want: []string{"b", "c"}},
+					{name: "difference", got: SetDifference(a, b), want: []string{"a"}},
+					{name: "symmetric difference", got: SetSymmetricDifference(a, b), want: []string{"a", "d"}},
+				} {
+					if got := sortedValues(tc.got); !reflect.DeepEqual(got, tc.want) {
+						t.Fatalf("%s = %v; want %v", tc.name, got, tc.want)
+					}
+				}
+				if SetIsSubset(a, b) || !SetIsSubset(build("b"), a) {
+					t.Fatalf("SetIsSubset() = %t, %t; want false, true", SetIsSubset(a, b), SetIsSubset(build("b"), a))
+				}
+			})
+		})
+	}
+}
+
+// sortedValues returns the sorted keys of a Set.
+func sortedValues(set Set[string]) []string {
+	values := set.Values()
+	sort.Strings(values)
+	return values
+}
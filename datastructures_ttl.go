@@ -0,0 +1,435 @@
+/*
+Copyright 2023 Alexandre Mahdhaoui
+
+Licensed under the Apache License, Version 2.0 (the "License");
+you may not use this file except in compliance with the License.
+You may obtain a copy of the License at
+
+	http://www.apache.org/licenses/LICENSE-2.0
+
+Unless required by applicable law or agreed to in writing, software
+distributed under the License is distributed on an "AS IS" BASIS,
+WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+See the License for the specific language governing permissions and
+limitations under the License.
+*/
+
+package bda
+
+import (
+	"context"
+	"sync"
+	"time"
+)
+
+// DefaultTTLEvictionInterval is the interval at which TTL data structures evict expired entries in the background.
+const DefaultTTLEvictionInterval = time.Second
+
+//----------------------------------------------------------------------------------------------------------------------
+//- TTLMap
+
+// TTLMap is a Map whose entries expire. Expired entries are never returned: they are evicted lazily when accessed, and
+// in the background while the TTLMap runs. Eviction callbacks are called for expired entries only, not deleted ones.
+type TTLMap[T comparable, I any] interface {
+	Map[T, I]
+	Runtime
+	// SetWithTTL method sets a value for a key, expiring after ttl. A zero ttl never expires.
+	SetWithTTL(key T, value I, ttl time.Duration) (T, I)
+	// TTL method returns the remaining time to live of a key, and false if the key does not exist.
+	// A key that never expires has a zero TTL.
+	TTL(key T) (time.Duration, bool)
+}
+
+type ttlEntry[I any] struct {
+	value     I
+	expiresAt time.Time
+}
+
+func (e ttlEntry[I]) expired(now time.Time) bool {
+	return !e.expiresAt.IsZero() && !now.Before(e.expiresAt)
+}
+
+// ttlMap is an in-memory implementation of TTLMap.
+type ttlMap[T comparable, I any] struct {
+	Name       string
+	DefaultTTL time.Duration
+	// EvictionInterval is the interval at which expired entries are evicted in the background. Defaults to
+	// DefaultTTLEvictionInterval if not positive.
+	EvictionInterval time.Duration
+	OnEvict          func(key T, value I)
+
+	ctx    context.Context
+	logger *Logger
+	// now returns the current time. It is time.Now, unless replaced by tests.
+	now      func() time.Time
+	store    map[T]ttlEntry[I]
+	stop     chan struct{}
+	stopOnce *sync.Once
+	mutex    *sync.Mutex
+}
+
+func (m *ttlMap[T, I]) Init() Error {
+	return nil
+}
+
+// Run evicts expired entries every EvictionInterval until the context is done or Stop is called.
+func (m *ttlMap[T, I]) Run() Error {
+	LogDebug(m, LogOperationRun, LogStatusStart)
+	interval := m.EvictionInterval
+	if interval <= 0 {
+		LogWarnf(m, LogOperationRun, LogStatusProgress, "eviction interval should be positive; got: %s; using %s", interval, DefaultTTLEvictionInterval)
+		interval = DefaultTTLEvictionInterval
+	}
+	ticker := time.NewTicker(interval)
+	defer ticker.Stop()
+
+	for {
+		select {
+		case <-m.ctx.Done():
+			LogDebug(m, LogOperationRun, LogStatusSuccess)
+			return nil
+		case <-m.stop:
+			LogDebug(m, LogOperationRun, LogStatusSuccess)
+			return nil
+		case <-ticker.C:
+			m.evictExpired()
+		}
+	}
+}
+
+// Stop stops the background eviction. The map can still be used; expired entries are then only evicted lazily.
+func (m *ttlMap[T, I]) Stop() Error {
+	m.stopOnce.Do(func() {
+		close(m.stop)
+	})
+	return nil
+}
+
+func (m *ttlMap[T, I]) HandleError(err Error) Error {
+	return nil
+}
+
+func (m *ttlMap[T, I]) GetName() string {
+	return m.Name
+}
+
+func (m *ttlMap[T, I]) GetType() string {
+	return "map-ttl"
+}
+
+func (m *ttlMap[T, I]) GetLogger() *Logger {
+	return m.logger
+}
+
+func (m *ttlMap[T, I]) Get(key T) (I, bool) {
+	m.mutex.Lock()
+	entry, ok := m.loadLocked(key, m.now())
+	m.mutex.Unlock()
+
+	if !ok {
+		m.evictLazily(key, entry)
+		var null I
+		return null, false
+	}
+	return entry.value, true
+}
+
+func (m *ttlMap[T, I]) Set(key T, value I) (T, I) {
+	return m.SetWithTTL(key, value, m.DefaultTTL)
+}
+
+func (m *ttlMap[T, I]) SetWithTTL(key T, value I, ttl time.Duration) (T, I) {
+	m.mutex.Lock()
+	m.store[key] = m.newEntry(value, ttl)
+	m.mutex.Unlock()
+	return key, value
+}
+
+func (m *ttlMap[T, I]) TTL(key T) (time.Duration, bool) {
+	now := m.now()
+	m.mutex.Lock()
+	entry, ok := m.loadLocked(key, now)
+	m.mutex.Unlock()
+
+	if !ok {
+		m.evictLazily(key, entry)
+		return 0, false
+	}
+	if entry.expiresAt.IsZero() {
+		return 0, true
+	}
+	return entry.expiresAt.Sub(now), true
+}
+
+func (m *ttlMap[T, I]) Delete(key T) (I, bool) {
+	m.mutex.Lock()
+	entry, ok := m.loadLocked(key, m.now())
+	delete(m.store, key)
+	m.mutex.Unlock()
+
+	if !ok {
+		m.evictLazily(key, entry)
+		var null I
+		return null, false
+	}
+	return entry.value, true
+}
+
+func (m *ttlMap[T, I]) Len() int {
+	now := m.now()
+	m.mutex.Lock()
+	defer m.mutex.Unlock()
+	length := 0
+	for _, entry := range m.store {
+		if !entry.expired(now) {
+			length++
+		}
+	}
+	return length
+}
+
+func (m *ttlMap[T, I]) Range(f func(key T, value I) bool) {
+	for key, value := range m.Snapshot() {
+		if !f(key, value) {
+			return
+		}
+	}
+}
+
+func (m *ttlMap[T, I]) Snapshot() map[T]I {
+	now := m.now()
+	m.mutex.Lock()
+	defer m.mutex.Unlock()
+	snapshot := make(map[T]I, len(m.store))
+	for key, entry := range m.store {
+		if !entry.expired(now) {
+			snapshot[key] = entry.value
+		}
+	}
+	return snapshot
+}
+
+func (m *ttlMap[T, I]) LoadOrStore(key T, value I) (I, bool) {
+	m.mutex.Lock()
+	entry, ok := m.loadLocked(key, m.now())
+	if ok {
+		m.mutex.Unlock()
+		return entry.value, true
+	}
+	m.store[key] = m.newEntry(value, m.DefaultTTL)
+	m.mutex.Unlock()
+
+	m.evictLazily(key, entry)
+	return value, false
+}
+
+func (m *ttlMap[T, I]) CompareAndSwap(key T, old, new I) bool {
+	m.mutex.Lock()
+	entry, ok := m.loadLocked(key, m.now())
+	if !ok || any(entry.value) != any(old) {
+		m.mutex.Unlock()
+		m.evictLazily(key, entry)
+		return false
+	}
+	entry.value = new
+	m.store[key] = entry
+	m.mutex.Unlock()
+	return true
+}
+
+// Compute method atomically updates the value for a key. Existing keys keep their expiry; new keys expire after
+// DefaultTTL.
+func (m *ttlMap[T, I]) Compute(key T, fn func(value I, ok bool) (I, bool)) (I, bool) {
+	m.mutex.Lock()
+	entry, ok := m.loadLocked(key, m.now())
+	var current I
+	if ok {
+		current = entry.value
+	}
+	value, keep := fn(current, ok)
+	if !keep {
+		delete(m.store, key)
+	} else if ok {
+		entry.value = value
+		m.store[key] = entry
+	} else {
+		m.store[key] = m.newEntry(value, m.DefaultTTL)
+	}
+	m.mutex.Unlock()
+
+	if !ok {
+		m.evictLazily(key, entry)
+	}
+	if !keep {
+		var null I
+		return null, false
+	}
+	return value, true
+}
+
+func (m *ttlMap[T, I]) newEntry(value I, ttl time.Duration) ttlEntry[I] {
+	entry := ttlEntry[I]{value: value}
+	if ttl > 0 {
+		entry.expiresAt = m.now().Add(ttl)
+	}
+	return entry
+}
+
+// loadLocked returns the entry for a key and true if it exists and did not expire.
+// If the entry expired, it is removed from the store and returned with false, so the caller can call evictLazily once
+// the mutex is released. Otherwise, a zero entry is returned.
+func (m *ttlMap[T, I]) loadLocked(key T, now time.Time) (ttlEntry[I], bool) {
+	entry, ok := m.store[key]
+	if !ok {
+		return ttlEntry[I]{}, false
+	}
+	if entry.expired(now) {
+		delete(m.store, key)
+		return entry, false
+	}
+	return entry, true
+}
+
+// evictLazily calls OnEvict for an entry returned by loadLocked, if it was removed because it expired.
+func (m *ttlMap[T, I]) evictLazily(key T, entry ttlEntry[I]) {
+	if entry.expiresAt.IsZero() || m.OnEvict == nil {
+		return
+	}
+	m.OnEvict(key, entry.value)
+}
+
+func (m *ttlMap[T, I]) evictExpired() {
+	now := m.now()
+	evicted := make(map[T]I)
+
+	m.mutex.Lock()
+	for key, entry := range m.store {
+		if entry.expired(now) {
+			delete(m.store, key)
+			evicted[key] = entry.value
+		}
+	}
+	m.mutex.Unlock()
+
+	if len(evicted) > 0 {
+		LogDebugf(m, LogOperationRun, LogStatusProgress, "evicted %d expired entries", len(evicted))
+	}
+	if m.OnEvict == nil {
+		return
+	}
+	for key, value := range evicted {
+		m.OnEvict(key, value)
+	}
+}
+
+// DefaultTTLMap function returns a new in-memory TTLMap whose entries expire after defaultTTL, unless set with
+// SetWithTTL. onEvict may be nil. Call Run to evict expired entries in the background.
+func DefaultTTLMap[T comparable, I any](name string, ctx context.Context, defaultTTL time.Duration, onEvict func(key T, value I)) TTLMap[T, I] {
+	return newTTLMap[T, I](name, ctx, defaultTTL, onEvict)
+}
+
+func newTTLMap[T comparable, I any](name string, ctx context.Context, defaultTTL time.Duration, onEvict func(key T, value I)) *ttlMap[T, I] {
+	return &ttlMap[T, I]{
+		Name:             name,
+		DefaultTTL:       defaultTTL,
+		EvictionInterval: DefaultTTLEvictionInterval,
+		OnEvict:          onEvict,
+		ctx:              ctx,
+		logger:           DefaultLogger(),
+		now:              time.Now,
+		store:            make(map[T]ttlEntry[I]),
+		stop:             make(chan struct{}),
+		stopOnce:         &sync.Once{},
+		mutex:            &sync.Mutex{},
+	}
+}
+
+//----------------------------------------------------------------------------------------------------------------------
+//- TTLSet
+
+// TTLSet is a Set whose keys expire, e.g. to implement deduplication windows.
+type TTLSet[T comparable] interface {
+	Set[T]
+	Runtime
+	// SetWithTTL method adds a key to the Set, expiring after ttl. A zero ttl never expires.
+	SetWithTTL(key T, ttl time.Duration)
+	// TrySetWithTTL method tries to add a key to the Set, expiring after ttl.
+	// Returns true, if the key was not already set & sets it.
+	TrySetWithTTL(key T, ttl time.Duration) bool
+}
+
+// ttlSet is an in-memory implementation of TTLSet, built on top of ttlMap.
+type ttlSet[T comparable] struct {
+	*ttlMap[T, struct{}]
+}
+
+func (s *ttlSet[T]) GetType() string {
+	return "set-ttl"
+}
+
+func (s *ttlSet[T]) Exist(key T) bool {
+	_, ok := s.Get(key)
+	return ok
+}
+
+func (s *ttlSet[T]) Set(key T) {
+	s.ttlMap.Set(key, struct{}{})
+}
+
+func (s *ttlSet[T]) SetWithTTL(key T, ttl time.Duration) {
+	s.ttlMap.SetWithTTL(key, struct{}{}, ttl)
+}
+
+func (s *ttlSet[T]) TrySet(key T) bool {
+	return s.TrySetWithTTL(key, s.DefaultTTL)
+}
+
+func (s *ttlSet[T]) TrySetWithTTL(key T, ttl time.Duration) bool {
+	s.mutex.Lock()
+	entry, ok := s.loadLocked(key, s.now())
+	if !ok {
+		s.store[key] = s.newEntry(struct{}{}, ttl)
+	}
+	s.mutex.Unlock()
+
+	if !ok {
+		s.evictLazily(key, entry)
+	}
+	return !ok
+}
+
+func (s *ttlSet[T]) Remove(key T) bool {
+	_, ok := s.Delete(key)
+	return ok
+}
+
+func (s *ttlSet[T]) Range(f func(key T) bool) {
+	for _, key := range s.Values() {
+		if !f(key) {
+			return
+		}
+	}
+}
+
+func (s *ttlSet[T]) Values() []T {
+	snapshot := s.Snapshot()
+	values := make([]T, 0, len(snapshot))
+	for key := range snapshot {
+		values = append(values, key)
+	}
+	return values
+}
+
+// DefaultTTLSet function returns a new in-memory TTLSet whose keys expire after defaultTTL, unless set with
+// SetWithTTL. onEvict may be nil. Call Run to evict expired keys in the background.
+func DefaultTTLSet[T comparable](name string, ctx context.Context, defaultTTL time.Duration, onEvict func(key T)) TTLSet[T] {
+	var onEvictEntry func(key T, _ struct{})
+	if onEvict != nil {
+		onEvictEntry = func(key T, _ struct{}) {
+			onEvict(key)
+		}
+	}
+	return &ttlSet[T]{
+		ttlMap: newTTLMap[T, struct{}](name, ctx, defaultTTL, onEvictEntry),
+	}
+}
@@ -0,0 +1,165 @@
+/*
+Copyright 2023 Alexandre Mahdhaoui
+
+Licensed under the Apache License, Version 2.0 (the "License");
+you may not use this file except in compliance with the License.
+You may obtain a copy of the License at
+
+	http://www.apache.org/licenses/LICENSE-2.0
+
+Unless required by applicable law or agreed to in writing, software
+distributed under the License is distributed on an "AS IS" BASIS,
+WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+See the License for the specific language governing permissions and
+limitations under the License.
+*/
+
+package bda
+
+import (
+	"context"
+	"reflect"
+	"sort"
+	"sync"
+	"testing"
+	"time"
+)
+
+func TestTTLMapExpiresEntries(t *testing.T) {
+	evicted := make(map[string]int)
+	m := newTTLMap[string, int]("ttl", context.Background(), time.Minute, func(key string, value int) {
+		evicted[key] = value
+	})
+	clock := newFakeClock()
+	m.now = clock.Now
+
+	m.Set("a", 1)
+	clock.Advance(59 * time.Second)
+	if value, ok := m.Get("a"); !ok || value != 1 {
+		t.Fatalf("Get(\"a\") = %d, %t before expiry; want 1, true", value, ok)
+	}
+	if ttl, ok := m.TTL("a"); !ok || ttl != time.Second {
+		t.Fatalf("TTL(\"a\") = %s, %t; want 1s, true", ttl, ok)
+	}
+
+	clock.Advance(time.Second)
+	if _, ok := m.Get("a"); ok {
+		t.Fatalf("Get(\"a\") = true after expiry; want false")
+	}
+	if want := map[string]int{"a": 1}; !reflect.DeepEqual(evicted, want) {
+		t.Fatalf("evicted = %v; want %v", evicted, want)
+	}
+}
+
+func TestTTLMapSetWithTTLOverridesDefault(t *testing.T) {
+	m := newTTLMap[string, int]("ttl", context.Background(), time.Minute, nil)
+	clock := newFakeClock()
+	m.now = clock.Now
+
+	m.Set("default", 1)
+	m.SetWithTTL("short", 2, time.Second)
+	m.SetWithTTL("long", 3, time.Hour)
+	m.SetWithTTL("forever", 4, 0)
+
+	clock.Advance(time.Second)
+	if _, ok := m.Get("short"); ok {
+		t.Fatalf("Get(\"short\") = true after its TTL; want false")
+	}
+	if _, ok := m.Get("default"); !ok {
+		t.Fatalf("Get(\"default\") = false before the default TTL; want true")
+	}
+
+	clock.Advance(time.Minute)
+	if _, ok := m.Get("default"); ok {
+		t.Fatalf("Get(\"default\") = true after the default TTL; want false")
+	}
+	if _, ok := m.Get("long"); !ok {
+		t.Fatalf("Get(\"long\") = false before its TTL; want true")
+	}
+
+	clock.Advance(24 * time.Hour)
+	if ttl, ok := m.TTL("forever"); !ok || ttl != 0 {
+		t.Fatalf("TTL(\"forever\") = %s, %t; want 0, true", ttl, ok)
+	}
+}
+
+func TestTTLMapLenAndRangeSkipExpiredEntries(t *testing.T) {
+	m := newTTLMap[string, int]("ttl", context.Background(), time.Minute, nil)
+	clock := newFakeClock()
+	m.now = clock.Now
+
+	m.SetWithTTL("expired", 1, time.Second)
+	m.Set("live", 2)
+	clock.Advance(time.Second)
+
+	if m.Len() != 1 {
+		t.Fatalf("Len() = %d; want 1", m.Len())
+	}
+	keys := make([]string, 0)
+	m.Range(func(key string, _ int) bool {
+		keys = append(keys, key)
+		return true
+	})
+	if want := []string{"live"}; !reflect.DeepEqual(keys, want) {
+		t.Fatalf("Range yielded %v; want %v", keys, want)
+	}
+}
+
+func TestTTLSetExpiresKeys(t *testing.T) {
+	evicted := make([]string, 0)
+	s := DefaultTTLSet[string]("ttl", context.Background(), time.Minute, func(key string) {
+		evicted = append(evicted, key)
+	}).(*ttlSet[string])
+	clock := newFakeClock()
+	s.now = clock.Now
+
+	s.Set("default")
+	s.SetWithTTL("short", time.Second)
+	if s.TrySet("default") {
+		t.Fatalf("TrySet(\"default\") = true for a live key; want false")
+	}
+
+	clock.Advance(time.Second)
+	if values := s.Values(); !reflect.DeepEqual(values, []string{"default"}) {
+		t.Fatalf("Values() = %v; want [default]", values)
+	}
+	// an expired key can be set again
+	if !s.TrySetWithTTL("short", time.Second) {
+		t.Fatalf("TrySetWithTTL(\"short\") = false for an expired key; want true")
+	}
+
+	clock.Advance(time.Minute)
+	if s.Len() != 0 {
+		t.Fatalf("Len() = %d; want 0", s.Len())
+	}
+	s.evictExpired()
+	sort.Strings(evicted)
+	if want := []string{"default", "short", "short"}; !reflect.DeepEqual(evicted, want) {
+		t.Fatalf("evicted = %v; want %v", evicted, want)
+	}
+}
+
+// fakeClock is a clock advancing only when told to.
+type fakeClock struct {
+	now   time.Time
+	mutex *sync.Mutex
+}
+
+func (c *fakeClock) Now() time.Time {
+	c.mutex.Lock()
+	defer c.mutex.Unlock()
+	return c.now
+}
+
+func (c *fakeClock) Advance(d time.Duration) {
+	c.mutex.Lock()
+	defer c.mutex.Unlock()
+	c.now = c.now.Add(d)
+}
+
+func newFakeClock() *fakeClock {
+	return &fakeClock{
+		now:   time.Date(2023, 1, 1, 0, 0, 0, 0, time.UTC),
+		mutex: &sync.Mutex{},
+	}
+}
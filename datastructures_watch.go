@@ -0,0 +1,383 @@
+/*
+Copyright 2023 Alexandre Mahdhaoui
+
+Licensed under the Apache License, Version 2.0 (the "License");
+you may not use this file except in compliance with the License.
+You may obtain a copy of the License at
+
+	http://www.apache.org/licenses/LICENSE-2.0
+
+Unless required by applicable law or agreed to in writing, software
+distributed under the License is distributed on an "AS IS" BASIS,
+WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+See the License for the specific language governing permissions and
+limitations under the License.
+*/
+
+package bda
+
+import (
+	"strings"
+	"sync"
+	"sync/atomic"
+)
+
+// DefaultWatchBufferSize is the number of events a Watcher buffers before dropping new ones, when
+// WithWatchBufferSize is not provided.
+const DefaultWatchBufferSize = 64
+
+//----------------------------------------------------------------------------------------------------------------------
+//- Watch
+
+type WatchEventType string
+
+const (
+	WatchEventPut    WatchEventType = "put"
+	WatchEventDelete WatchEventType = "delete"
+	// WatchEventResync is sent once after a Watcher dropped events. Key and Value are empty: the Watcher missed
+	// changes, and the consumer should read the current state of the data structure again.
+	WatchEventResync WatchEventType = "resync"
+)
+
+// WatchEvent describes a change of a key. Value is the new value for put events, and the previous value for delete
+// events.
+type WatchEvent[T comparable, I any] struct {
+	Type  WatchEventType
+	Key   T
+	Value I
+}
+
+// WatchFilter selects the keys a Watcher receives events for.
+type WatchFilter[T comparable] func(key T) bool
+
+// WatchAll returns a WatchFilter selecting every key.
+func WatchAll[T comparable]() WatchFilter[T] {
+	return func(T) bool {
+		return true
+	}
+}
+
+// WatchKey returns a WatchFilter selecting a single key.
+func WatchKey[T comparable](key T) WatchFilter[T] {
+	return func(k T) bool {
+		return k == key
+	}
+}
+
+// WatchPrefix returns a WatchFilter selecting the keys starting with prefix.
+func WatchPrefix[T ~string](prefix string) WatchFilter[T] {
+	return func(k T) bool {
+		return strings.HasPrefix(string(k), prefix)
+	}
+}
+
+// WatchOption configures a Watcher.
+type WatchOption func(*watchOptions)
+
+type watchOptions struct {
+	BufferSize int
+}
+
+// WithWatchBufferSize sets the number of events a Watcher buffers before dropping new ones.
+// Values below 1 default to DefaultWatchBufferSize.
+func WithWatchBufferSize(n int) WatchOption {
+	return func(o *watchOptions) {
+		o.BufferSize = n
+	}
+}
+
+func newWatchOptions(opts []WatchOption) *watchOptions {
+	o := &watchOptions{BufferSize: DefaultWatchBufferSize}
+	for _, opt := range opts {
+		opt(o)
+	}
+	if o.BufferSize < 1 {
+		o.BufferSize = DefaultWatchBufferSize
+	}
+	return o
+}
+
+// Watcher receives the events of a Watchable data structure matching its filter.
+type Watcher[T comparable, I any] interface {
+	// Receiver method returns the channel events are delivered to. It is closed when the Watcher is stopped.
+	Receiver() <-chan WatchEvent[T, I]
+	// Dropped method returns the number of events dropped because the Watcher did not consume them fast enough.
+	// Dropping events sends a WatchEventResync event.
+	Dropped() uint64
+	// Stop method stops the Watcher and closes its channel. It is idempotent.
+	Stop()
+}
+
+// Watchable is implemented by data structures notifying changes to Watchers.
+type Watchable[T comparable, I any] interface {
+	// Watch method returns a new Watcher receiving the events for the keys selected by filter.
+	Watch(filter WatchFilter[T], opts ...WatchOption) Watcher[T, I]
+}
+
+// ObservableMap is a Map notifying changes to Watchers.
+type ObservableMap[T comparable, I any] interface {
+	Map[T, I]
+	Watchable[T, I]
+}
+
+// ObservableSet is a Set notifying changes to Watchers. Values of events are always empty.
+type ObservableSet[T comparable] interface {
+	Set[T]
+	Watchable[T, struct{}]
+}
+
+// watchChannel buffers the events of a Watcher. Events are sent without blocking: if the buffer is full, the event is
+// dropped, accounted for in dropped, and a WatchEventResync event is sent. The channel has room for one more event
+// than the buffer size, so that the resync event always fits. Sends must be serialized.
+type watchChannel[T comparable, I any] struct {
+	channel chan WatchEvent[T, I]
+	size    int
+	dropped *atomic.Uint64
+	// resyncing is true from the time a resync event is sent until an event is sent again.
+	resyncing bool
+}
+
+func (c *watchChannel[T, I]) Receiver() <-chan WatchEvent[T, I] {
+	return c.channel
+}
+
+func (c *watchChannel[T, I]) Dropped() uint64 {
+	return c.dropped.Load()
+}
+
+func (c *watchChannel[T, I]) send(event WatchEvent[T, I]) {
+	if len(c.channel) >= c.size {
+		c.dropped.Add(1)
+		c.resync()
+		return
+	}
+	c.channel <- event
+	c.resyncing = false
+}
+
+// resync sends a WatchEventResync event, unless one was sent since the last event.
+func (c *watchChannel[T, I]) resync() {
+	if c.resyncing {
+		return
+	}
+	select {
+	case c.channel <- WatchEvent[T, I]{Type: WatchEventResync}:
+		c.resyncing = true
+	default:
+	}
+}
+
+func newWatchChannel[T comparable, I any](size int) watchChannel[T, I] {
+	return watchChannel[T, I]{
+		channel: make(chan WatchEvent[T, I], size+1),
+		size:    size,
+		dropped: &atomic.Uint64{},
+	}
+}
+
+// watcher is the in-memory implementation of Watcher.
+type watcher[T comparable, I any] struct {
+	watchChannel[T, I]
+	id     uint64
+	filter WatchFilter[T]
+	hub    *watchHub[T, I]
+}
+
+func (w *watcher[T, I]) Stop() {
+	w.hub.remove(w.id)
+}
+
+// watchHub dispatches events to registered watchers.
+type watchHub[T comparable, I any] struct {
+	watchers map[uint64]*watcher[T, I]
+	nextID   uint64
+	mutex    *sync.Mutex
+}
+
+func (h *watchHub[T, I]) Watch(filter WatchFilter[T], opts ...WatchOption) Watcher[T, I] {
+	o := newWatchOptions(opts)
+	h.mutex.Lock()
+	defer h.mutex.Unlock()
+	h.nextID++
+	w := &watcher[T, I]{
+		watchChannel: newWatchChannel[T, I](o.BufferSize),
+		id:           h.nextID,
+		filter:       filter,
+		hub:          h,
+	}
+	h.watchers[w.id] = w
+	return w
+}
+
+func (h *watchHub[T, I]) remove(id uint64) {
+	h.mutex.Lock()
+	defer h.mutex.Unlock()
+	if w, ok := h.watchers[id]; ok {
+		delete(h.watchers, id)
+		close(w.channel)
+	}
+}
+
+func (h *watchHub[T, I]) notify(event WatchEvent[T, I]) {
+	h.mutex.Lock()
+	defer h.mutex.Unlock()
+	for _, w := range h.watchers {
+		if !w.filter(event.Key) {
+			continue
+		}
+		w.send(event)
+	}
+}
+
+func newWatchHub[T comparable, I any]() *watchHub[T, I] {
+	return &watchHub[T, I]{
+		watchers: make(map[uint64]*watcher[T, I]),
+		mutex:    &sync.Mutex{},
+	}
+}
+
+//----------------------------------------------------------------------------------------------------------------------
+//- ObservableMap
+
+// observableMap wraps a Map and notifies changes made through it. Writes are serialized, so events are delivered in
+// the order changes were applied; reads are not affected. Changes not made through the wrapper, such as TTL
+// evictions, are not notified.
+type observableMap[T comparable, I any] struct {
+	Map[T, I]
+	*watchHub[T, I]
+	writeMutex *sync.Mutex
+}
+
+func (m *observableMap[T, I]) Set(key T, value I) (T, I) {
+	m.writeMutex.Lock()
+	defer m.writeMutex.Unlock()
+	key, value = m.Map.Set(key, value)
+	m.notify(WatchEvent[T, I]{Type: WatchEventPut, Key: key, Value: value})
+	return key, value
+}
+
+func (m *observableMap[T, I]) Delete(key T) (I, bool) {
+	m.writeMutex.Lock()
+	defer m.writeMutex.Unlock()
+	value, ok := m.Map.Delete(key)
+	if ok {
+		m.notify(WatchEvent[T, I]{Type: WatchEventDelete, Key: key, Value: value})
+	}
+	return value, ok
+}
+
+func (m *observableMap[T, I]) LoadOrStore(key T, value I) (I, bool) {
+	m.writeMutex.Lock()
+	defer m.writeMutex.Unlock()
+	actual, loaded := m.Map.LoadOrStore(key, value)
+	if !loaded {
+		m.notify(WatchEvent[T, I]{Type: WatchEventPut, Key: key, Value: actual})
+	}
+	return actual, loaded
+}
+
+func (m *observableMap[T, I]) CompareAndSwap(key T, old, new I) bool {
+	m.writeMutex.Lock()
+	defer m.writeMutex.Unlock()
+	swapped := m.Map.CompareAndSwap(key, old, new)
+	if swapped {
+		m.notify(WatchEvent[T, I]{Type: WatchEventPut, Key: key, Value: new})
+	}
+	return swapped
+}
+
+func (m *observableMap[T, I]) Compute(key T, fn func(value I, ok bool) (I, bool)) (I, bool) {
+	m.writeMutex.Lock()
+	defer m.writeMutex.Unlock()
+
+	var (
+		previous I
+		existed  bool
+	)
+	value, kept := m.Map.Compute(key, func(current I, ok bool) (I, bool) {
+		previous, existed = current, ok
+		return fn(current, ok)
+	})
+
+	switch {
+	case kept:
+		m.notify(WatchEvent[T, I]{Type: WatchEventPut, Key: key, Value: value})
+	case existed:
+		m.notify(WatchEvent[T, I]{Type: WatchEventDelete, Key: key, Value: previous})
+	}
+	return value, kept
+}
+
+// DefaultObservableMap function wraps a Map into an ObservableMap. Changes must be made through the returned
+// ObservableMap to be notified.
+func DefaultObservableMap[T comparable, I any](m Map[T, I]) ObservableMap[T, I] {
+	return &observableMap[T, I]{
+		Map:        m,
+		watchHub:   newWatchHub[T, I](),
+		writeMutex: &sync.Mutex{},
+	}
+}
+
+//----------------------------------------------------------------------------------------------------------------------
+//- ObservableSet
+
+// observableSet wraps a Set and notifies changes made through it. Writes are serialized, so events are delivered in
+// the order changes were applied; reads are not affected.
+type observableSet[T comparable] struct {
+	*watchHub[T, struct{}]
+	set        Set[T]
+	writeMutex *sync.Mutex
+}
+
+func (s *observableSet[T]) Exist(key T) bool {
+	return s.set.Exist(key)
+}
+
+func (s *observableSet[T]) Set(key T) {
+	s.writeMutex.Lock()
+	defer s.writeMutex.Unlock()
+	s.set.Set(key)
+	s.notify(WatchEvent[T, struct{}]{Type: WatchEventPut, Key: key})
+}
+
+func (s *observableSet[T]) TrySet(key T) bool {
+	s.writeMutex.Lock()
+	defer s.writeMutex.Unlock()
+	ok := s.set.TrySet(key)
+	if ok {
+		s.notify(WatchEvent[T, struct{}]{Type: WatchEventPut, Key: key})
+	}
+	return ok
+}
+
+func (s *observableSet[T]) Remove(key T) bool {
+	s.writeMutex.Lock()
+	defer s.writeMutex.Unlock()
+	ok := s.set.Remove(key)
+	if ok {
+		s.notify(WatchEvent[T, struct{}]{Type: WatchEventDelete, Key: key})
+	}
+	return ok
+}
+
+func (s *observableSet[T]) Len() int {
+	return s.set.Len()
+}
+
+func (s *observableSet[T]) Range(f func(key T) bool) {
+	s.set.Range(f)
+}
+
+func (s *observableSet[T]) Values() []T {
+	return s.set.Values()
+}
+
+// DefaultObservableSet function wraps a Set into an ObservableSet. Changes must be made through the returned
+// ObservableSet to be notified.
+func DefaultObservableSet[T comparable](s Set[T]) ObservableSet[T] {
+	return &observableSet[T]{
+		watchHub:   newWatchHub[T, struct{}](),
+		set:        s,
+		writeMutex: &sync.Mutex{},
+	}
+}
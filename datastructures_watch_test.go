@@ -0,0 +1,78 @@
+/*
+Copyright 2023 Alexandre Mahdhaoui
+
+Licensed under the Apache License, Version 2.0 (the "License");
+you may not use this file except in compliance with the License.
+You may obtain a copy of the License at
+
+	http://www.apache.org/licenses/LICENSE-2.0
+
+Unless required by applicable law or agreed to in writing, software
+distributed under the License is distributed on an "AS IS" BASIS,
+WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+See the License for the specific language governing permissions and
+limitations under the License.
+*/
+
+package bda
+
+import (
+	"fmt"
+	"testing"
+)
+
+func TestWatcherOverflowSendsOneResync(t *testing.T) {
+	m := DefaultObservableMap(DefaultMap[string, int]())
+	w := m.Watch(WatchAll[string](), WithWatchBufferSize(2))
+	defer w.Stop()
+
+	for i := 0; i < 5; i++ {
+		m.Set(fmt.Sprintf("key-%d", i), i)
+	}
+	if got := w.Dropped(); got != 3 {
+		t.Fatalf("Dropped() = %d; want 3", got)
+	}
+	assertWatchEvents(t, w, WatchEventPut, WatchEventPut, WatchEventResync)
+
+	// once the consumer caught up, events are delivered again, and a new overflow sends a new resync
+	for i := 0; i < 4; i++ {
+		m.Set(fmt.Sprintf("key-%d", i), i)
+	}
+	if got := w.Dropped(); got != 5 {
+		t.Fatalf("Dropped() = %d; want 5", got)
+	}
+	assertWatchEvents(t, w, WatchEventPut, WatchEventPut, WatchEventResync)
+}
+
+func TestWatcherStopIsIdempotent(t *testing.T) {
+	s := DefaultObservableSet(DefaultSet[string]())
+	w := s.Watch(WatchAll[string]())
+
+	w.Stop()
+	w.Stop()
+	if _, ok := <-w.Receiver(); ok {
+		t.Fatalf("Receiver() is open after Stop()")
+	}
+	// changes made after the Watcher stopped are not sent to its closed channel
+	s.Set("key")
+}
+
+// assertWatchEvents asserts the buffered events of w have the given types, and that no other event is buffered.
+func assertWatchEvents[T comparable, I any](t *testing.T, w Watcher[T, I], types ...WatchEventType) {
+	t.Helper()
+	for i, want := range types {
+		select {
+		case event := <-w.Receiver():
+			if event.Type != want {
+				t.Fatalf("event %d type = %s; want %s", i, event.Type, want)
+			}
+		default:
+			t.Fatalf("got %d events; want %d", i, len(types))
+		}
+	}
+	select {
+	case event := <-w.Receiver():
+		t.Fatalf("unexpected event %+v", event)
+	default:
+	}
+}
@@ -0,0 +1,36 @@
+//go:build !(linux || darwin || freebsd || netbsd || openbsd || dragonfly)
+
+/*
+Copyright 2023 Alexandre Mahdhaoui
+
+Licensed under the Apache License, Version 2.0 (the "License");
+you may not use this file except in compliance with the License.
+You may obtain a copy of the License at
+
+	http://www.apache.org/licenses/LICENSE-2.0
+
+Unless required by applicable law or agreed to in writing, software
+distributed under the License is distributed on an "AS IS" BASIS,
+WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+See the License for the specific language governing permissions and
+limitations under the License.
+*/
+
+package bda
+
+import (
+	"errors"
+	"os"
+)
+
+var errFileLockUnsupported = errors.New("advisory file locks are not supported on this platform")
+
+// lockFile is not supported on this platform.
+func lockFile(f *os.File) error {
+	return errFileLockUnsupported
+}
+
+// unlockFile is not supported on this platform.
+func unlockFile(f *os.File) error {
+	return errFileLockUnsupported
+}
@@ -0,0 +1,396 @@
+/*
+Copyright 2023 Alexandre Mahdhaoui
+
+Licensed under the Apache License, Version 2.0 (the "License");
+you may not use this file except in compliance with the License.
+You may obtain a copy of the License at
+
+	http://www.apache.org/licenses/LICENSE-2.0
+
+Unless required by applicable law or agreed to in writing, software
+distributed under the License is distributed on an "AS IS" BASIS,
+WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+See the License for the specific language governing permissions and
+limitations under the License.
+*/
+
+package bda
+
+import (
+	"context"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"net/http"
+	"strings"
+	"sync"
+	"time"
+)
+
+const (
+	// HTTPBridgePathPrefix is the path prefix under which queues are exposed: POST|GET /queues/<queue-name>
+	HTTPBridgePathPrefix = "/queues/"
+
+	defaultHTTPBridgeMaxBodyBytes     = 1 << 20
+	defaultHTTPBridgeLongPollTimeout  = 30 * time.Second
+	defaultHTTPBridgeShutdownDuration = 5 * time.Second
+)
+
+// HTTPValidateFunc is a hook validating a decoded ligand before it is sent to a Queue.
+// Returning a non-nil Error rejects the request with a 422 status code.
+type HTTPValidateFunc[T any] func(item T) Error
+
+// HTTPBridge is an embeddable http.Handler mapping HTTP endpoints to named Queues.
+// It lets other services feed ligands into BDA pools without linking the library:
+//   - POST /queues/<name> decodes the JSON body into T and sends it to the Queue.
+//     Responds 429 Too Many Requests when the Queue is full.
+//   - GET /queues/<name> consumes the Queue, either by long-polling (one item per request, `?timeout=10s`),
+//     or by streaming Server-Sent Events when the request accepts "text/event-stream".
+//
+// HTTPBridge also implements Runtime: if Addr is set, Run serves the bridge until the context is done.
+//
+// An HTTPBridge may be built as a struct literal: defaults are applied when the fields are used.
+type HTTPBridge struct {
+	Name   string
+	Addr   string
+	Logger *Logger
+
+	// MaxBodyBytes limits the size of POST bodies. Defaults to 1MiB if not positive.
+	MaxBodyBytes int64
+	// LongPollTimeout is the default amount of time a GET request waits for an item. Defaults to 30s if not positive.
+	LongPollTimeout time.Duration
+
+	// Context defaults to context.Background().
+	Context context.Context
+
+	senders   Map[string, http.HandlerFunc]
+	receivers Map[string, http.HandlerFunc]
+	server    *http.Server
+	mutex     *sync.Mutex
+	initOnce  sync.Once
+}
+
+func (b *HTTPBridge) Init() Error {
+	b.init()
+	LogDebug(b, LogOperationInit, LogStatusStart)
+	b.mutex.Lock()
+	b.server = &http.Server{
+		Addr:    b.Addr,
+		Handler: b,
+	}
+	b.mutex.Unlock()
+	LogDebug(b, LogOperationInit, LogStatusSuccess)
+	return nil
+}
+
+func (b *HTTPBridge) Run() Error {
+	b.init()
+	if b.Addr == "" {
+		LogDebugf(b, LogOperationRun, LogStatusSuccess, "no address configured; bridge is only served as an embedded http.Handler")
+		return nil
+	}
+
+	b.mutex.Lock()
+	server := b.server
+	b.mutex.Unlock()
+	if server == nil {
+		return NewError("RuntimeError", "http bridge should be initialized before running", nil)
+	}
+
+	LogInfof(b, LogOperationRun, LogStatusStart, "start http bridge on %s", b.Addr)
+	served := make(chan struct{})
+	defer close(served)
+	go func() {
+		select {
+		case <-b.Context.Done():
+			LogInfof(b, LogOperationRun, LogStatusSuccess, "received stop signal for http bridge: %s", b.GetName())
+			b.Stop()
+		case <-served:
+		}
+	}()
+
+	if err := server.ListenAndServe(); err != nil && !errors.Is(err, http.ErrServerClosed) {
+		LogErrorf(b, LogOperationRun, LogStatusFailed, "%v", err)
+		return NewError("HTTPBridgeError", err.Error(), nil)
+	}
+	return nil
+}
+
+func (b *HTTPBridge) Stop() Error {
+	b.init()
+	LogDebug(b, LogOperationStop, LogStatusStart)
+	b.mutex.Lock()
+	server := b.server
+	b.mutex.Unlock()
+	if server == nil {
+		return nil
+	}
+
+	ctx, cancel := context.WithTimeout(context.Background(), defaultHTTPBridgeShutdownDuration)
+	defer cancel()
+	if err := server.Shutdown(ctx); err != nil {
+		LogErrorf(b, LogOperationStop, LogStatusFailed, "%v", err)
+		return NewError("HTTPBridgeError", err.Error(), nil)
+	}
+	LogDebug(b, LogOperationStop, LogStatusSuccess)
+	return nil
+}
+
+func (b *HTTPBridge) HandleError(err Error) Error {
+	return nil
+}
+
+func (b *HTTPBridge) GetName() string {
+	return b.Name
+}
+
+func (b *HTTPBridge) GetType() string {
+	return "http-bridge"
+}
+
+func (b *HTTPBridge) GetLogger() *Logger {
+	return b.Logger
+}
+
+// init applies the defaults of the fields left empty, so an HTTPBridge may be built as a struct literal.
+func (b *HTTPBridge) init() {
+	b.initOnce.Do(func() {
+		if b.Context == nil {
+			b.Context = context.Background()
+		}
+		if b.senders == nil {
+			b.senders = DefaultMap[string, http.HandlerFunc]()
+		}
+		if b.receivers == nil {
+			b.receivers = DefaultMap[string, http.HandlerFunc]()
+		}
+		if b.mutex == nil {
+			b.mutex = &sync.Mutex{}
+		}
+	})
+}
+
+// ServeHTTP dispatches requests to the Queue registered under the name following HTTPBridgePathPrefix.
+func (b *HTTPBridge) ServeHTTP(w http.ResponseWriter, r *http.Request) {
+	b.init()
+	name := strings.TrimPrefix(r.URL.Path, HTTPBridgePathPrefix)
+	if name == r.URL.Path || name == "" {
+		writeHTTPBridgeError(w, http.StatusNotFound, NewError("NotFoundError", fmt.Sprintf("unknown path: %s", r.URL.Path), nil))
+		return
+	}
+
+	var routes Map[string, http.HandlerFunc]
+	switch r.Method {
+	case http.MethodPost:
+		routes = b.senders
+	case http.MethodGet:
+		routes = b.receivers
+	default:
+		writeHTTPBridgeError(w, http.StatusMethodNotAllowed, NewError("MethodNotAllowedError", r.Method, nil))
+		return
+	}
+
+	handler, ok := routes.Get(name)
+	if !ok {
+		writeHTTPBridgeError(w, http.StatusNotFound, NewError("NotFoundError", fmt.Sprintf("no %s endpoint for queue: %s", r.Method, name), nil))
+		return
+	}
+	handler(w, r)
+}
+
+// RegisterHTTPSender exposes a Queue as POST /queues/<queue-name>.
+// Request bodies are decoded as JSON into T, checked by the validators in order, then sent to the Queue.
+func RegisterHTTPSender[T any](b *HTTPBridge, q Queue[T], validators ...HTTPValidateFunc[T]) {
+	b.init()
+	maxBodyBytes := b.MaxBodyBytes
+	if maxBodyBytes <= 0 {
+		maxBodyBytes = defaultHTTPBridgeMaxBodyBytes
+	}
+	b.senders.Set(q.GetName(), func(w http.ResponseWriter, r *http.Request) {
+		var item T
+		decoder := json.NewDecoder(http.MaxBytesReader(w, r.Body, maxBodyBytes))
+		if err := decoder.Decode(&item); err != nil {
+			writeHTTPBridgeError(w, http.StatusBadRequest, NewError("DecodeError", err.Error(), nil))
+			return
+		}
+
+		for _, validate := range validators {
+			if err := validate(item); err != nil {
+				writeHTTPBridgeError(w, http.StatusUnprocessableEntity, err)
+				return
+			}
+		}
+
+		if err := q.TrySend(item, 0); err != nil {
+			if err.Type == ErrorTypeQueueClosed {
+				writeHTTPBridgeError(w, http.StatusServiceUnavailable, err)
+				return
+			}
+			LogDebugf(b, LogOperationRun, LogStatusProgress, "queue %s is full; rejecting request", q.GetName())
+			w.Header().Set("Retry-After", "1")
+			writeHTTPBridgeError(w, http.StatusTooManyRequests, err)
+			return
+		}
+		w.WriteHeader(http.StatusAccepted)
+	})
+}
+
+// RegisterHTTPReceiver exposes a Queue as GET /queues/<queue-name>.
+// By default, the request long-polls the Queue and responds with a single JSON item, or 204 No Content if no item
+// was received before the timeout. The timeout can be overridden with the `timeout` query parameter (e.g. `?timeout=5s`);
+// a negative timeout is rejected with 400 Bad Request.
+// If the request accepts "text/event-stream", items are streamed as Server-Sent Events until the client disconnects.
+// Items are received through ReceiveContext, so they are accounted for in the Stats of the Queue.
+//
+// Delivery is at least once: an item received for a client that disconnected, or that cannot be written to the client,
+// is sent back to the Queue with TrySend, so a client may receive an item that is also delivered again later. The item
+// is enqueued at the back of the Queue, so FIFO order is not preserved, and it is dropped, with an error log, if the
+// Queue is full or closed.
+func RegisterHTTPReceiver[T any](b *HTTPBridge, q Queue[T]) {
+	b.init()
+	b.receivers.Set(q.GetName(), func(w http.ResponseWriter, r *http.Request) {
+		if strings.Contains(r.Header.Get("Accept"), "text/event-stream") {
+			serveHTTPBridgeEvents(b, w, r, q)
+			return
+		}
+
+		timeout := b.LongPollTimeout
+		if timeout <= 0 {
+			timeout = defaultHTTPBridgeLongPollTimeout
+		}
+		if raw := r.URL.Query().Get("timeout"); raw != "" {
+			d, err := time.ParseDuration(raw)
+			if err != nil {
+				writeHTTPBridgeError(w, http.StatusBadRequest, NewError("DecodeError", err.Error(), nil))
+				return
+			}
+			if d < 0 {
+				writeHTTPBridgeError(w, http.StatusBadRequest, NewError("DecodeError", fmt.Sprintf("timeout should not be negative; got: %s", raw), nil))
+				return
+			}
+			timeout = d
+		}
+
+		item, ok, closed := receiveHTTPBridgeItem(r.Context(), q, timeout)
+		switch {
+		case closed:
+			writeHTTPBridgeError(w, http.StatusGone, NewError(ErrorTypeQueueClosed, fmt.Sprintf("queue is closed: %s", q.GetName()), nil))
+		case !ok:
+			if r.Context().Err() == nil {
+				w.WriteHeader(http.StatusNoContent)
+			}
+		case r.Context().Err() != nil:
+			requeueHTTPBridgeItem(b, q, item, r.Context().Err())
+		default:
+			if err := writeHTTPBridgeJSON(w, http.StatusOK, item); err != nil {
+				requeueHTTPBridgeItem(b, q, item, err)
+			}
+		}
+	})
+}
+
+func serveHTTPBridgeEvents[T any](b *HTTPBridge, w http.ResponseWriter, r *http.Request, q Queue[T]) {
+	if _, ok := w.(http.Flusher); !ok {
+		writeHTTPBridgeError(w, http.StatusNotAcceptable, NewError("StreamingUnsupportedError", "response writer does not support flushing", nil))
+		return
+	}
+	controller := http.NewResponseController(w)
+
+	w.Header().Set("Content-Type", "text/event-stream")
+	w.Header().Set("Cache-Control", "no-cache")
+	w.Header().Set("Connection", "keep-alive")
+	w.WriteHeader(http.StatusOK)
+	if err := controller.Flush(); err != nil {
+		return
+	}
+
+	for {
+		item, ok, closed := receiveHTTPBridgeItem(r.Context(), q, -1)
+		if closed || !ok {
+			return
+		}
+		if err := r.Context().Err(); err != nil {
+			requeueHTTPBridgeItem(b, q, item, err)
+			return
+		}
+
+		var err error
+		if data, marshalErr := json.Marshal(item); marshalErr != nil {
+			_, err = fmt.Fprintf(w, "event: error\ndata: %q\n\n", marshalErr.Error())
+		} else {
+			_, err = fmt.Fprintf(w, "data: %s\n\n", data)
+		}
+		if err == nil {
+			err = controller.Flush()
+		}
+		if err != nil {
+			requeueHTTPBridgeItem(b, q, item, err)
+			return
+		}
+	}
+}
+
+// receiveHTTPBridgeItem receives an item through ReceiveContext, so that it is accounted for in the Stats of the
+// Queue, until timeout expires or ctx is done. A negative timeout waits until ctx is done. closed is true if the Queue
+// is closed and drained.
+func receiveHTTPBridgeItem[T any](ctx context.Context, q Queue[T], timeout time.Duration) (item T, ok bool, closed bool) {
+	waitCtx := ctx
+	if timeout >= 0 {
+		var cancel context.CancelFunc
+		waitCtx, cancel = context.WithTimeout(ctx, timeout)
+		defer cancel()
+	}
+
+	if item, ok = q.ReceiveContext(waitCtx); ok {
+		return item, true, false
+	}
+	return item, false, q.IsClosed() && q.Len() == 0
+}
+
+// requeueHTTPBridgeItem sends back to the Queue an item that could not be delivered to the client. The item is
+// enqueued at the back of the Queue; it is lost if the Queue is full or closed.
+func requeueHTTPBridgeItem[T any](b *HTTPBridge, q Queue[T], item T, cause error) {
+	if err := q.TrySend(item, 0); err != nil {
+		LogErrorf(b, LogOperationRun, LogStatusFailed, "cannot requeue undelivered item to queue %s; item is lost: %v; %+v", q.GetName(), cause, *err)
+		return
+	}
+	LogWarnf(b, LogOperationRun, LogStatusProgress, "requeued undelivered item to queue %s: %v", q.GetName(), cause)
+}
+
+// writeHTTPBridgeJSON encodes v before writing the response, so that encoding errors are not reported as a partial
+// body, and returns the error of the write.
+func writeHTTPBridgeJSON(w http.ResponseWriter, status int, v any) error {
+	data, err := json.Marshal(v)
+	if err != nil {
+		w.WriteHeader(http.StatusInternalServerError)
+		return err
+	}
+	w.Header().Set("Content-Type", "application/json")
+	w.WriteHeader(status)
+	if _, err = w.Write(append(data, '\n')); err != nil {
+		return err
+	}
+	if err = http.NewResponseController(w).Flush(); err != nil && !errors.Is(err, http.ErrNotSupported) {
+		return err
+	}
+	return nil
+}
+
+func writeHTTPBridgeError(w http.ResponseWriter, status int, err Error) {
+	_ = writeHTTPBridgeJSON(w, status, err)
+}
+
+// DefaultHTTPBridge returns a new HTTPBridge. Addr may be empty if the bridge is only embedded in another server.
+func DefaultHTTPBridge(name, addr string, ctx context.Context) *HTTPBridge {
+	return &HTTPBridge{
+		Name:            name,
+		Addr:            addr,
+		Logger:          DefaultLogger(),
+		MaxBodyBytes:    defaultHTTPBridgeMaxBodyBytes,
+		LongPollTimeout: defaultHTTPBridgeLongPollTimeout,
+		Context:         ctx,
+		senders:         DefaultMap[string, http.HandlerFunc](),
+		receivers:       DefaultMap[string, http.HandlerFunc](),
+		mutex:           &sync.Mutex{},
+	}
+}
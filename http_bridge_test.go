@@ -0,0 +1,80 @@
+/*
+Copyright 2023 Alexandre Mahdhaoui
+
+Licensed under the Apache License, Version 2.0 (the "License");
+you may not use this file except in compliance with the License.
+You may obtain a copy of the License at
+
+	http://www.apache.org/licenses/LICENSE-2.0
+
+Unless required by applicable law or agreed to in writing, software
+distributed under the License is distributed on an "AS IS" BASIS,
+WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+See the License for the specific language governing permissions and
+limitations under the License.
+*/
+
+package bda
+
+import (
+	"context"
+	"net/http"
+	"net/http/httptest"
+	"strings"
+	"testing"
+)
+
+func TestHTTPBridgeStructLiteral(t *testing.T) {
+	q := DefaultQueueWithCapacity[string]("ligands", context.Background(), 1)
+	if err := q.Init(); err != nil {
+		t.Fatalf("Init() error = %v", err)
+	}
+	b := &HTTPBridge{Name: "bridge"}
+	RegisterHTTPSender(b, q)
+	RegisterHTTPReceiver(b, q)
+
+	post := httptest.NewRecorder()
+	b.ServeHTTP(post, httptest.NewRequest(http.MethodPost, "/queues/ligands", strings.NewReader(`"ligand"`)))
+	if post.Code != http.StatusAccepted {
+		t.Fatalf("POST status = %d; want %d; body: %s", post.Code, http.StatusAccepted, post.Body)
+	}
+
+	get := httptest.NewRecorder()
+	b.ServeHTTP(get, httptest.NewRequest(http.MethodGet, "/queues/ligands?timeout=1s", nil))
+	if get.Code != http.StatusOK || strings.TrimSpace(get.Body.String()) != `"ligand"` {
+		t.Fatalf("GET = %d %s; want %d %q", get.Code, get.Body, http.StatusOK, `"ligand"`)
+	}
+	if got := q.Stats().Dequeued; got != 1 {
+		t.Fatalf("Stats().Dequeued = %d; want 1", got)
+	}
+
+	empty := httptest.NewRecorder()
+	b.ServeHTTP(empty, httptest.NewRequest(http.MethodGet, "/queues/ligands?timeout=10ms", nil))
+	if empty.Code != http.StatusNoContent {
+		t.Fatalf("GET status = %d; want %d", empty.Code, http.StatusNoContent)
+	}
+
+	q.Stop()
+	closed := httptest.NewRecorder()
+	b.ServeHTTP(closed, httptest.NewRequest(http.MethodGet, "/queues/ligands?timeout=1s", nil))
+	if closed.Code != http.StatusGone {
+		t.Fatalf("GET status = %d; want %d", closed.Code, http.StatusGone)
+	}
+}
+
+func TestHTTPBridgeRejectsNegativeTimeout(t *testing.T) {
+	q := DefaultQueueWithCapacity[string]("ligands", context.Background(), 1)
+	if err := q.Init(); err != nil {
+		t.Fatalf("Init() error = %v", err)
+	}
+	b := &HTTPBridge{Name: "bridge"}
+	RegisterHTTPReceiver(b, q)
+
+	for _, timeout := range []string{"-1s", "later"} {
+		get := httptest.NewRecorder()
+		b.ServeHTTP(get, httptest.NewRequest(http.MethodGet, "/queues/ligands?timeout="+timeout, nil))
+		if get.Code != http.StatusBadRequest {
+			t.Fatalf("GET ?timeout=%s status = %d; want %d", timeout, get.Code, http.StatusBadRequest)
+		}
+	}
+}
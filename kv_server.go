@@ -0,0 +1,535 @@
+/*
+Copyright 2023 Alexandre Mahdhaoui
+
+Licensed under the Apache License, Version 2.0 (the "License");
+you may not use this file except in compliance with the License.
+You may obtain a copy of the License at
+
+	http://www.apache.org/licenses/LICENSE-2.0
+
+Unless required by applicable law or agreed to in writing, software
+distributed under the License is distributed on an "AS IS" BASIS,
+WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+See the License for the specific language governing permissions and
+limitations under the License.
+*/
+
+package bda
+
+import (
+	"bufio"
+	"bytes"
+	"context"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"net"
+	"sync"
+	"time"
+)
+
+const (
+	kvOpLeaseAcquire = "lease.acquire"
+	kvOpLeaseRenew   = "lease.renew"
+	kvOpLeaseRelease = "lease.release"
+	kvOpLeaseHolder  = "lease.holder"
+
+	kvOpMapGet              = "map.get"
+	kvOpMapSet              = "map.set"
+	kvOpMapDelete           = "map.delete"
+	kvOpMapLen              = "map.len"
+	kvOpMapEntries          = "map.entries"
+	kvOpMapLoadOrStore      = "map.loadOrStore"
+	kvOpMapCompareAndSwap   = "map.compareAndSwap"
+	kvOpMapCompareAndDelete = "map.compareAndDelete"
+	kvOpWatch               = "watch"
+
+	defaultKVDialTimeout = 5 * time.Second
+	// defaultKVRequestTimeout bounds a request/response round trip, so a hung server does not block the callers of a
+	// kvClient forever.
+	defaultKVRequestTimeout = 10 * time.Second
+)
+
+// kvRequest is a request sent by a kvClient to a KVServer. Requests and responses are newline-delimited JSON.
+type kvRequest struct {
+	Op string `json:"op"`
+
+	ID       string        `json:"id,omitempty"`
+	Owner    string        `json:"owner,omitempty"`
+	Token    uint64        `json:"token,omitempty"`
+	Duration time.Duration `json:"duration,omitempty"`
+
+	// Bucket names the map a map operation or a watch applies to. Keys are JSON-encoded.
+	Bucket string          `json:"bucket,omitempty"`
+	Key    string          `json:"key,omitempty"`
+	Value  json.RawMessage `json:"value,omitempty"`
+	Old    json.RawMessage `json:"old,omitempty"`
+}
+
+// kvResponse is the response of a KVServer to a kvRequest.
+type kvResponse struct {
+	Ok    bool   `json:"ok"`
+	Lease *Lease `json:"lease,omitempty"`
+	// TTL is the remaining duration of Lease. Clients compute Lease.ExpiresAt from it with their own clock, since the
+	// clocks of the server and its clients may be skewed.
+	TTL   time.Duration `json:"ttl,omitempty"`
+	Error Error         `json:"error,omitempty"`
+
+	Value   json.RawMessage `json:"value,omitempty"`
+	Count   int             `json:"count,omitempty"`
+	Entries []kvEntry       `json:"entries,omitempty"`
+	// Event is set on the responses streamed to a watch connection.
+	Event *kvEvent `json:"event,omitempty"`
+}
+
+type kvEntry struct {
+	Key   string          `json:"key"`
+	Value json.RawMessage `json:"value"`
+}
+
+type kvEvent struct {
+	Type  WatchEventType  `json:"type"`
+	Key   string          `json:"key"`
+	Value json.RawMessage `json:"value,omitempty"`
+}
+
+// KVServer serves shared state over TCP, so processes running on different hosts can share it through the same
+// interfaces as in-memory implementations (see NewTCPLeaserBuilder, DefaultTCPMap and DefaultTCPSet).
+// State is kept in memory and lost when the server stops.
+type KVServer struct {
+	Name    string
+	Addr    string
+	Logger  *Logger
+	Context context.Context
+
+	leaser   *inMemoryLeaser
+	buckets  Map[string, *kvBucket]
+	listener net.Listener
+	conns    map[net.Conn]struct{}
+	wg       *sync.WaitGroup
+	mutex    *sync.Mutex
+}
+
+// Init starts listening on Addr.
+func (s *KVServer) Init() Error {
+	LogDebug(s, LogOperationInit, LogStatusStart)
+	listener, err := net.Listen("tcp", s.Addr)
+	if err != nil {
+		LogErrorf(s, LogOperationInit, LogStatusFailed, "%v", err)
+		return NewError("KVServerError", err.Error(), nil)
+	}
+
+	s.mutex.Lock()
+	s.listener = listener
+	s.mutex.Unlock()
+	LogDebug(s, LogOperationInit, LogStatusSuccess)
+	return nil
+}
+
+// Run accepts connections until the context is done or Stop is called.
+func (s *KVServer) Run() Error {
+	s.mutex.Lock()
+	listener := s.listener
+	s.mutex.Unlock()
+	if listener == nil {
+		return NewError("RuntimeError", "kv server should be initialized before running", nil)
+	}
+
+	LogInfof(s, LogOperationRun, LogStatusStart, "start kv server on %s", listener.Addr().String())
+	served := make(chan struct{})
+	defer close(served)
+	go func() {
+		select {
+		case <-s.Context.Done():
+			LogInfof(s, LogOperationRun, LogStatusSuccess, "received stop signal for kv server: %s", s.GetName())
+			s.Stop()
+		case <-served:
+		}
+	}()
+
+	for {
+		conn, err := listener.Accept()
+		if errors.Is(err, net.ErrClosed) {
+			s.wg.Wait()
+			return nil
+		}
+		if err != nil {
+			LogWarnf(s, LogOperationRun, LogStatusProgress, "cannot accept connection; %v", err)
+			continue
+		}
+
+		s.mutex.Lock()
+		s.conns[conn] = struct{}{}
+		s.mutex.Unlock()
+		s.wg.Add(1)
+		go s.serve(conn)
+	}
+}
+
+// Stop closes the listener and every open connection.
+func (s *KVServer) Stop() Error {
+	LogDebug(s, LogOperationStop, LogStatusStart)
+	s.mutex.Lock()
+	listener := s.listener
+	s.mutex.Unlock()
+
+	if listener != nil {
+		if err := listener.Close(); err != nil && !errors.Is(err, net.ErrClosed) {
+			LogErrorf(s, LogOperationStop, LogStatusFailed, "%v", err)
+			return NewError("KVServerError", err.Error(), nil)
+		}
+	}
+	s.closeConns()
+	LogDebug(s, LogOperationStop, LogStatusSuccess)
+	return nil
+}
+
+func (s *KVServer) HandleError(err Error) Error {
+	return nil
+}
+
+func (s *KVServer) GetName() string {
+	return s.Name
+}
+
+func (s *KVServer) GetType() string {
+	return "kv-server"
+}
+
+func (s *KVServer) GetLogger() *Logger {
+	return s.Logger
+}
+
+// ListenAddr returns the address the server listens on, which is useful when Addr uses port 0.
+func (s *KVServer) ListenAddr() string {
+	s.mutex.Lock()
+	defer s.mutex.Unlock()
+	if s.listener == nil {
+		return s.Addr
+	}
+	return s.listener.Addr().String()
+}
+
+func (s *KVServer) serve(conn net.Conn) {
+	defer s.wg.Done()
+	defer func() {
+		s.mutex.Lock()
+		delete(s.conns, conn)
+		s.mutex.Unlock()
+		conn.Close()
+	}()
+
+	decoder := json.NewDecoder(bufio.NewReader(conn))
+	encoder := json.NewEncoder(conn)
+	for {
+		var req kvRequest
+		if err := decoder.Decode(&req); err != nil {
+			return
+		}
+		if req.Op == kvOpWatch {
+			s.stream(decoder, encoder, s.bucket(req.Bucket))
+			return
+		}
+		if err := encoder.Encode(s.handle(req)); err != nil {
+			return
+		}
+	}
+}
+
+// stream turns a connection into a watch connection: every change of the bucket is sent to the client until the
+// connection is closed. Events are dropped if the client does not consume them fast enough, in which case a
+// WatchEventResync event is sent.
+func (s *KVServer) stream(decoder *json.Decoder, encoder *json.Encoder, bucket *kvBucket) {
+	w := bucket.Watch(WatchAll[string]())
+	defer w.Stop()
+	if err := encoder.Encode(kvResponse{Ok: true}); err != nil {
+		return
+	}
+
+	// the client does not send anything on a watch connection: a read returns when the connection is closed.
+	go func() {
+		var req kvRequest
+		_ = decoder.Decode(&req)
+		w.Stop()
+	}()
+
+	for event := range w.Receiver() {
+		resp := kvResponse{Ok: true, Event: &kvEvent{Type: event.Type, Key: event.Key, Value: event.Value}}
+		if err := encoder.Encode(resp); err != nil {
+			return
+		}
+	}
+}
+
+func (s *KVServer) handle(req kvRequest) kvResponse {
+	switch req.Op {
+	case kvOpLeaseAcquire:
+		lease, err := s.leaser.acquire(req.ID, req.Owner, positiveOrDefault(req.Duration, s.leaser.LeaseDuration))
+		return leaseResponse(lease, err)
+	case kvOpLeaseRenew:
+		lease, err := s.leaser.renew(req.ID, req.Token, positiveOrDefault(req.Duration, s.leaser.LeaseDuration))
+		return leaseResponse(lease, err)
+	case kvOpLeaseRelease:
+		if err := s.leaser.Release(req.ID, req.Token); err != nil {
+			return kvResponse{Error: err}
+		}
+		return kvResponse{Ok: true}
+	case kvOpLeaseHolder:
+		lease, ok := s.leaser.Holder(req.ID)
+		return kvResponse{Ok: ok, Lease: &lease, TTL: time.Until(lease.ExpiresAt)}
+	case kvOpMapGet:
+		value, ok := s.bucket(req.Bucket).get(req.Key)
+		return kvResponse{Ok: ok, Value: value}
+	case kvOpMapSet:
+		s.bucket(req.Bucket).set(req.Key, req.Value)
+		return kvResponse{Ok: true}
+	case kvOpMapDelete:
+		value, ok := s.bucket(req.Bucket).delete(req.Key)
+		return kvResponse{Ok: ok, Value: value}
+	case kvOpMapLen:
+		return kvResponse{Ok: true, Count: s.bucket(req.Bucket).len()}
+	case kvOpMapEntries:
+		return kvResponse{Ok: true, Entries: s.bucket(req.Bucket).entries()}
+	case kvOpMapLoadOrStore:
+		actual, loaded := s.bucket(req.Bucket).loadOrStore(req.Key, req.Value)
+		return kvResponse{Ok: loaded, Value: actual}
+	case kvOpMapCompareAndSwap:
+		return kvResponse{Ok: s.bucket(req.Bucket).compareAndSwap(req.Key, req.Old, req.Value)}
+	case kvOpMapCompareAndDelete:
+		return kvResponse{Ok: s.bucket(req.Bucket).compareAndDelete(req.Key, req.Old)}
+	default:
+		return kvResponse{Error: NewError("KVServerError", fmt.Sprintf("unknown operation: %s", req.Op), nil)}
+	}
+}
+
+// bucket returns the bucket with the given name, creating it if needed.
+func (s *KVServer) bucket(name string) *kvBucket {
+	if b, ok := s.buckets.Get(name); ok {
+		return b
+	}
+	b, _ := s.buckets.LoadOrStore(name, newKVBucket())
+	return b
+}
+
+func (s *KVServer) closeConns() {
+	s.mutex.Lock()
+	defer s.mutex.Unlock()
+	for conn := range s.conns {
+		conn.Close()
+	}
+}
+
+func leaseResponse(lease Lease, err Error) kvResponse {
+	if err != nil {
+		return kvResponse{Error: err}
+	}
+	return kvResponse{Ok: true, Lease: &lease, TTL: time.Until(lease.ExpiresAt)}
+}
+
+// DefaultKVServer returns a new KVServer listening on addr once initialized.
+func DefaultKVServer(name, addr string, ctx context.Context) *KVServer {
+	return &KVServer{
+		Name:    name,
+		Addr:    addr,
+		Logger:  DefaultLogger(),
+		Context: ctx,
+		leaser:  newInMemoryLeaser(DefaultLeaseDuration),
+		buckets: DefaultMap[string, *kvBucket](),
+		conns:   make(map[net.Conn]struct{}),
+		wg:      &sync.WaitGroup{},
+		mutex:   &sync.Mutex{},
+	}
+}
+
+//----------------------------------------------------------------------------------------------------------------------
+//- kvBucket
+
+// kvBucket is a map served by a KVServer. Keys and values are kept JSON-encoded, so values are compared by their
+// encoding.
+type kvBucket struct {
+	*watchHub[string, json.RawMessage]
+	store map[string]json.RawMessage
+	mutex *sync.Mutex
+}
+
+func (b *kvBucket) get(key string) (json.RawMessage, bool) {
+	b.mutex.Lock()
+	defer b.mutex.Unlock()
+	value, ok := b.store[key]
+	return value, ok
+}
+
+func (b *kvBucket) set(key string, value json.RawMessage) {
+	b.mutex.Lock()
+	defer b.mutex.Unlock()
+	b.store[key] = value
+	b.notify(WatchEvent[string, json.RawMessage]{Type: WatchEventPut, Key: key, Value: value})
+}
+
+func (b *kvBucket) delete(key string) (json.RawMessage, bool) {
+	b.mutex.Lock()
+	defer b.mutex.Unlock()
+	value, ok := b.store[key]
+	if ok {
+		delete(b.store, key)
+		b.notify(WatchEvent[string, json.RawMessage]{Type: WatchEventDelete, Key: key, Value: value})
+	}
+	return value, ok
+}
+
+func (b *kvBucket) len() int {
+	b.mutex.Lock()
+	defer b.mutex.Unlock()
+	return len(b.store)
+}
+
+func (b *kvBucket) entries() []kvEntry {
+	b.mutex.Lock()
+	defer b.mutex.Unlock()
+	entries := make([]kvEntry, 0, len(b.store))
+	for key, value := range b.store {
+		entries = append(entries, kvEntry{Key: key, Value: value})
+	}
+	return entries
+}
+
+func (b *kvBucket) loadOrStore(key string, value json.RawMessage) (json.RawMessage, bool) {
+	b.mutex.Lock()
+	defer b.mutex.Unlock()
+	if actual, ok := b.store[key]; ok {
+		return actual, true
+	}
+	b.store[key] = value
+	b.notify(WatchEvent[string, json.RawMessage]{Type: WatchEventPut, Key: key, Value: value})
+	return value, false
+}
+
+func (b *kvBucket) compareAndSwap(key string, old, new json.RawMessage) bool {
+	b.mutex.Lock()
+	defer b.mutex.Unlock()
+	if current, ok := b.store[key]; !ok || !bytes.Equal(current, old) {
+		return false
+	}
+	b.store[key] = new
+	b.notify(WatchEvent[string, json.RawMessage]{Type: WatchEventPut, Key: key, Value: new})
+	return true
+}
+
+func (b *kvBucket) compareAndDelete(key string, old json.RawMessage) bool {
+	b.mutex.Lock()
+	defer b.mutex.Unlock()
+	if current, ok := b.store[key]; !ok || !bytes.Equal(current, old) {
+		return false
+	}
+	delete(b.store, key)
+	b.notify(WatchEvent[string, json.RawMessage]{Type: WatchEventDelete, Key: key, Value: old})
+	return true
+}
+
+func newKVBucket() *kvBucket {
+	return &kvBucket{
+		watchHub: newWatchHub[string, json.RawMessage](),
+		store:    make(map[string]json.RawMessage),
+		mutex:    &sync.Mutex{},
+	}
+}
+
+//----------------------------------------------------------------------------------------------------------------------
+//- kvClient
+
+// kvClient sends requests to a KVServer over a single connection, dialed lazily and re-dialed after I/O errors.
+// Each round trip must complete within Timeout. Transport failures are logged and returned.
+type kvClient struct {
+	Addr    string
+	Timeout time.Duration
+	Logger  *Logger
+
+	conn    net.Conn
+	encoder *json.Encoder
+	decoder *json.Decoder
+	mutex   *sync.Mutex
+}
+
+func (c *kvClient) do(req kvRequest) (kvResponse, Error) {
+	c.mutex.Lock()
+	defer c.mutex.Unlock()
+
+	if c.conn == nil {
+		conn, err := net.DialTimeout("tcp", c.Addr, defaultKVDialTimeout)
+		if err != nil {
+			return kvResponse{}, c.transportError(req, err)
+		}
+		c.conn = conn
+		c.encoder = json.NewEncoder(conn)
+		c.decoder = json.NewDecoder(bufio.NewReader(conn))
+	}
+
+	if err := c.conn.SetDeadline(time.Now().Add(c.Timeout)); err != nil {
+		c.reset()
+		return kvResponse{}, c.transportError(req, err)
+	}
+
+	var resp kvResponse
+	if err := c.encoder.Encode(req); err != nil {
+		c.reset()
+		return kvResponse{}, c.transportError(req, err)
+	}
+	if err := c.decoder.Decode(&resp); err != nil {
+		c.reset()
+		return kvResponse{}, c.transportError(req, err)
+	}
+	return resp, nil
+}
+
+func (c *kvClient) transportError(req kvRequest, err error) Error {
+	LogWarnf(c, LogOperationRun, LogStatusFailed, "request %s to %s failed; %v", req.Op, c.Addr, err)
+	return NewError("KVClientError", err.Error(), nil)
+}
+
+func (c *kvClient) reset() {
+	c.conn.Close()
+	c.conn, c.encoder, c.decoder = nil, nil, nil
+}
+
+func (c *kvClient) Init() Error {
+	return nil
+}
+
+func (c *kvClient) Run() Error {
+	return nil
+}
+
+// Stop closes the connection of the client. The next request dials a new one.
+func (c *kvClient) Stop() Error {
+	c.mutex.Lock()
+	defer c.mutex.Unlock()
+	if c.conn != nil {
+		c.reset()
+	}
+	return nil
+}
+
+func (c *kvClient) HandleError(err Error) Error {
+	return err
+}
+
+func (c *kvClient) GetName() string {
+	return c.Addr
+}
+
+func (c *kvClient) GetType() string {
+	return "kv-client"
+}
+
+func (c *kvClient) GetLogger() *Logger {
+	return c.Logger
+}
+
+func newKVClient(addr string) *kvClient {
+	return &kvClient{
+		Addr:    addr,
+		Timeout: defaultKVRequestTimeout,
+		Logger:  DefaultLogger(),
+		mutex:   &sync.Mutex{},
+	}
+}
@@ -0,0 +1,342 @@
+/*
+Copyright 2023 Alexandre Mahdhaoui
+
+Licensed under the Apache License, Version 2.0 (the "License");
+you may not use this file except in compliance with the License.
+You may obtain a copy of the License at
+
+	http://www.apache.org/licenses/LICENSE-2.0
+
+Unless required by applicable law or agreed to in writing, software
+distributed under the License is distributed on an "AS IS" BASIS,
+WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+See the License for the specific language governing permissions and
+limitations under the License.
+*/
+
+package bda
+
+import (
+	"context"
+	"sync"
+	"time"
+)
+
+const (
+	defaultLeaderElectorRetryPeriod = 2 * time.Second
+
+	// leaderOnlyMinBackoff is the first delay before running the strategy of WorkerPoolStrategyLeaderOnly again after
+	// it failed. The delay doubles after each failure, up to the RetryPeriod of the LeaderElector.
+	leaderOnlyMinBackoff = 100 * time.Millisecond
+)
+
+// LeaderElector elects a single leader among replicas sharing the same Leaser and LeaseID.
+// Run keeps trying to acquire the lease; once acquired, the lease is automatically renewed until it is lost, the
+// context is done, or Stop is called, in which case the lease is released so another replica can take over.
+//
+// A LeaderElector may be built as a struct literal: defaults are applied when the fields are used.
+type LeaderElector struct {
+	Name string
+	// LeaseID is the ID of the lease replicas compete for.
+	LeaseID string
+	// Identity is the owner ID of this replica.
+	Identity string
+	Leaser   Leaser
+	// RetryPeriod is the interval between attempts to acquire the lease while not leading. Defaults to 2s if not
+	// positive.
+	RetryPeriod time.Duration
+
+	// OnStartedLeading is called in a new goroutine when this replica becomes the leader.
+	// The context is canceled when leadership is lost.
+	OnStartedLeading func(ctx context.Context)
+	// OnStoppedLeading is called when this replica stops being the leader.
+	OnStoppedLeading func()
+
+	Logger *Logger
+	// Context defaults to context.Background().
+	Context context.Context
+
+	lease         Lease
+	leadingCtx    context.Context
+	cancelLeading context.CancelFunc
+	// changed is closed and replaced each time leadership changes.
+	changed  chan struct{}
+	stop     chan struct{}
+	stopOnce *sync.Once
+	mutex    *sync.Mutex
+	initOnce sync.Once
+}
+
+func (e *LeaderElector) Init() Error {
+	e.init()
+	return nil
+}
+
+// Run blocks until the context is done or Stop is called.
+func (e *LeaderElector) Run() Error {
+	e.init()
+	LogInfof(e, LogOperationRun, LogStatusStart, "start leader election for lease: %s", e.LeaseID)
+
+	for {
+		wait := e.retryPeriod()
+		if lease, ok := e.leading(); ok {
+			renewed, err := e.Leaser.Renew(e.LeaseID, lease.Token)
+			if err != nil {
+				LogWarnf(e, LogOperationRun, LogStatusProgress, "lost leadership for lease: %s; %+v", e.LeaseID, *err)
+				e.stepDown(false)
+			} else {
+				e.setLease(renewed)
+				wait = renewInterval(renewed, defaultLeaseRenewFraction)
+			}
+		} else if lease, err := e.Leaser.Acquire(e.LeaseID, e.Identity); err == nil {
+			if !e.startLeading(lease) {
+				// Stop was called while acquiring the lease
+				if err := e.Leaser.Release(e.LeaseID, lease.Token); err != nil {
+					LogWarnf(e, LogOperationStop, LogStatusProgress, "cannot release lease: %s; %+v", e.LeaseID, *err)
+				}
+				return nil
+			}
+			wait = renewInterval(lease, defaultLeaseRenewFraction)
+		}
+
+		timer := time.NewTimer(wait)
+		select {
+		case <-e.Context.Done():
+			timer.Stop()
+			e.stepDown(true)
+			LogInfof(e, LogOperationRun, LogStatusSuccess, "received stop signal for leader election: %s", e.GetName())
+			return nil
+		case <-e.stop:
+			timer.Stop()
+			e.stepDown(true)
+			return nil
+		case <-timer.C:
+		}
+	}
+}
+
+// Stop gracefully steps down: the lease is released and OnStoppedLeading is called if this replica was leading.
+func (e *LeaderElector) Stop() Error {
+	e.init()
+	LogDebug(e, LogOperationStop, LogStatusStart)
+	e.stopOnce.Do(func() {
+		close(e.stop)
+	})
+	e.stepDown(true)
+	LogDebug(e, LogOperationStop, LogStatusSuccess)
+	return nil
+}
+
+func (e *LeaderElector) HandleError(err Error) Error {
+	return nil
+}
+
+func (e *LeaderElector) GetName() string {
+	return e.Name
+}
+
+func (e *LeaderElector) GetType() string {
+	return "leader-elector"
+}
+
+func (e *LeaderElector) GetLogger() *Logger {
+	return e.Logger
+}
+
+// IsLeader returns true if this replica is currently the leader.
+func (e *LeaderElector) IsLeader() bool {
+	e.init()
+	_, ok := e.leading()
+	return ok
+}
+
+// WaitForLeadership blocks until this replica becomes the leader, and returns a context canceled when leadership is
+// lost. Returns false if ctx is done first.
+func (e *LeaderElector) WaitForLeadership(ctx context.Context) (context.Context, bool) {
+	e.init()
+	for {
+		e.mutex.Lock()
+		leadingCtx, changed := e.leadingCtx, e.changed
+		e.mutex.Unlock()
+
+		if leadingCtx != nil {
+			return leadingCtx, true
+		}
+
+		select {
+		case <-ctx.Done():
+			return nil, false
+		case <-changed:
+		}
+	}
+}
+
+// init applies the defaults of the fields left empty, so a LeaderElector may be built as a struct literal.
+func (e *LeaderElector) init() {
+	e.initOnce.Do(func() {
+		if e.Context == nil {
+			e.Context = context.Background()
+		}
+		if e.changed == nil {
+			e.changed = make(chan struct{})
+		}
+		if e.stop == nil {
+			e.stop = make(chan struct{})
+		}
+		if e.stopOnce == nil {
+			e.stopOnce = &sync.Once{}
+		}
+		if e.mutex == nil {
+			e.mutex = &sync.Mutex{}
+		}
+	})
+}
+
+// retryPeriod returns RetryPeriod, or the default retry period if it is not positive: a zero period would busy-loop
+// on Acquire.
+func (e *LeaderElector) retryPeriod() time.Duration {
+	return positiveOrDefault(e.RetryPeriod, defaultLeaderElectorRetryPeriod)
+}
+
+func (e *LeaderElector) leading() (Lease, bool) {
+	e.mutex.Lock()
+	defer e.mutex.Unlock()
+	return e.lease, e.leadingCtx != nil
+}
+
+func (e *LeaderElector) setLease(lease Lease) {
+	e.mutex.Lock()
+	e.lease = lease
+	e.mutex.Unlock()
+}
+
+// startLeading returns false without leading if Stop was called. The check is made under the mutex, so that either
+// Stop sees this replica leading and steps down, or this replica does not start leading.
+func (e *LeaderElector) startLeading(lease Lease) bool {
+	e.mutex.Lock()
+	select {
+	case <-e.stop:
+		e.mutex.Unlock()
+		return false
+	default:
+	}
+	e.lease = lease
+	e.leadingCtx, e.cancelLeading = context.WithCancel(e.Context)
+	leadingCtx := e.leadingCtx
+	e.notifyLocked()
+	e.mutex.Unlock()
+
+	LogInfof(e, LogOperationRun, LogStatusProgress, "started leading lease: %s; token: %d", e.LeaseID, lease.Token)
+	if e.OnStartedLeading != nil {
+		go e.OnStartedLeading(leadingCtx)
+	}
+	return true
+}
+
+func (e *LeaderElector) stepDown(release bool) {
+	e.mutex.Lock()
+	if e.leadingCtx == nil {
+		e.mutex.Unlock()
+		return
+	}
+	lease := e.lease
+	e.cancelLeading()
+	e.leadingCtx, e.cancelLeading = nil, nil
+	e.lease = Lease{}
+	e.notifyLocked()
+	e.mutex.Unlock()
+
+	if release {
+		if err := e.Leaser.Release(e.LeaseID, lease.Token); err != nil {
+			LogWarnf(e, LogOperationStop, LogStatusProgress, "cannot release lease: %s; %+v", e.LeaseID, *err)
+		}
+	}
+
+	LogInfof(e, LogOperationRun, LogStatusProgress, "stopped leading lease: %s", e.LeaseID)
+	if e.OnStoppedLeading != nil {
+		e.OnStoppedLeading()
+	}
+}
+
+func (e *LeaderElector) notifyLocked() {
+	close(e.changed)
+	e.changed = make(chan struct{})
+}
+
+// DefaultLeaderElector returns a new LeaderElector competing for leaseID as identity.
+func DefaultLeaderElector(name, leaseID, identity string, leaser Leaser, ctx context.Context) *LeaderElector {
+	return &LeaderElector{
+		Name:        name,
+		LeaseID:     leaseID,
+		Identity:    identity,
+		Leaser:      leaser,
+		RetryPeriod: defaultLeaderElectorRetryPeriod,
+		Logger:      DefaultLogger(),
+		Context:     ctx,
+		changed:     make(chan struct{}),
+		stop:        make(chan struct{}),
+		stopOnce:    &sync.Once{},
+		mutex:       &sync.Mutex{},
+	}
+}
+
+// WorkerPoolStrategyLeaderOnly returns a WorkerPoolStrategy running the workers of a pool only while the
+// LeaderElector is leading. While leading, strategy is run repeatedly with a context done when leadership is lost or
+// when the context of the pool is done, e.g. WorkerPoolStrategyRunOnce or WorkerPoolStrategyRunLoop. When strategy
+// returns an Error, it is run again after a backoff.
+func WorkerPoolStrategyLeaderOnly(e *LeaderElector, strategy WorkerPoolStrategy) WorkerPoolStrategy {
+	return WorkerPoolStrategyFunc(func(ctx context.Context, h WorkerHandle) Error {
+		p, i := h.Pool(), h.Index()
+		for {
+			LogDebugf(p, LogOperationRun, LogStatusProgress, "worker-%d waiting for leadership", i)
+			leadingCtx, ok := e.WaitForLeadership(ctx)
+			if !ok {
+				return nil
+			}
+
+			LogDebugf(p, LogOperationRun, LogStatusProgress, "starting leader-only loop for worker-%d", i)
+			runCtx, cancel := mergeContexts(ctx, leadingCtx)
+			backoff := time.Duration(0)
+			for runCtx.Err() == nil {
+				err := strategy.Run(runCtx, h)
+				h.Report(err)
+				if err == nil {
+					backoff = 0
+					continue
+				}
+
+				// back off, e.g. while the worker cannot be spawned
+				if backoff *= 2; backoff < leaderOnlyMinBackoff {
+					backoff = leaderOnlyMinBackoff
+				} else if backoff > e.retryPeriod() {
+					backoff = e.retryPeriod()
+				}
+				LogDebugf(p, LogOperationRun, LogStatusProgress, "run of worker-%d failed; retrying in %s", i, backoff)
+				timer := time.NewTimer(backoff)
+				select {
+				case <-runCtx.Done():
+					timer.Stop()
+				case <-timer.C:
+				}
+			}
+			cancel()
+
+			if ctx.Err() != nil {
+				return nil
+			}
+		}
+	})
+}
+
+// mergeContexts returns a context derived from a, also canceled when b is done.
+func mergeContexts(a, b context.Context) (context.Context, context.CancelFunc) {
+	ctx, cancel := context.WithCancel(a)
+	go func() {
+		select {
+		case <-b.Done():
+			cancel()
+		case <-ctx.Done():
+		}
+	}()
+	return ctx, cancel
+}
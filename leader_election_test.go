@@ -0,0 +1,72 @@
+/*
+Copyright 2023 Alexandre Mahdhaoui
+
+Licensed under the Apache License, Version 2.0 (the "License");
+you may not use this file except in compliance with the License.
+You may obtain a copy of the License at
+
+	http://www.apache.org/licenses/LICENSE-2.0
+
+Unless required by applicable law or agreed to in writing, software
+distributed under the License is distributed on an "AS IS" BASIS,
+WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+See the License for the specific language governing permissions and
+limitations under the License.
+*/
+
+package bda
+
+import (
+	"context"
+	"sync/atomic"
+	"testing"
+	"time"
+)
+
+func TestLeaderElectorStructLiteral(t *testing.T) {
+	leaser := NewInMemoryLeaserBuilder(WithLeaseDuration(time.Minute)).Build()
+	e := &LeaderElector{Name: "elector", LeaseID: "leader", Identity: "replica", Leaser: leaser}
+
+	ran := make(chan Error, 1)
+	go func() {
+		ran <- e.Run()
+	}()
+
+	ctx, cancel := context.WithTimeout(context.Background(), 5*time.Second)
+	defer cancel()
+	if _, ok := e.WaitForLeadership(ctx); !ok {
+		t.Fatalf("WaitForLeadership() = false; want leadership")
+	}
+	if err := e.Stop(); err != nil {
+		t.Fatalf("Stop() error = %v", err)
+	}
+	if err := <-ran; err != nil {
+		t.Fatalf("Run() error = %v", err)
+	}
+	if _, held := leaser.Holder("leader"); held {
+		t.Fatalf("lease still held after Stop")
+	}
+}
+
+func TestWorkerPoolStrategyLeaderOnlyBacksOff(t *testing.T) {
+	leaser := NewInMemoryLeaserBuilder(WithLeaseDuration(time.Minute)).Build()
+	e := DefaultLeaderElector("elector", "leader", "replica", leaser, context.Background())
+	go e.Run()
+	defer e.Stop()
+
+	runs := &atomic.Int64{}
+	failing := WorkerPoolStrategyFunc(func(ctx context.Context, h WorkerHandle) Error {
+		runs.Add(1)
+		return NewError("TestError", "cannot spawn worker", nil)
+	})
+
+	ctx, cancel := context.WithTimeout(context.Background(), 300*time.Millisecond)
+	defer cancel()
+	h := &countingWorkerHandle{pool: &WorkerPool{Name: "pool"}}
+	if err := WorkerPoolStrategyLeaderOnly(e, failing).Run(ctx, h); err != nil {
+		t.Fatalf("Run() error = %v", err)
+	}
+	if got := runs.Load(); got < 1 || got > 5 {
+		t.Fatalf("runs = %d; want a few runs spaced by the backoff", got)
+	}
+}
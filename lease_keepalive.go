@@ -0,0 +1,267 @@
+/*
+Copyright 2023 Alexandre Mahdhaoui
+
+Licensed under the Apache License, Version 2.0 (the "License");
+you may not use this file except in compliance with the License.
+You may obtain a copy of the License at
+
+	http://www.apache.org/licenses/LICENSE-2.0
+
+Unless required by applicable law or agreed to in writing, software
+distributed under the License is distributed on an "AS IS" BASIS,
+WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+See the License for the specific language governing permissions and
+limitations under the License.
+*/
+
+package bda
+
+import (
+	"context"
+	"fmt"
+	"sync"
+	"time"
+)
+
+const defaultLeaseRenewFraction = 1.0 / 3
+
+// LeaseKeepAlive renews a lease in the background, so its holder does not have to call Leaser.Renew in time.
+// The lease is renewed each time RenewFraction of its remaining duration elapsed. When renewal fails, the lease is
+// considered lost: the context returned by LeaseContext is canceled and OnLost is called.
+//
+// A LeaseKeepAlive may be built as a struct literal: its internal state is created on first use, and Context
+// defaults to context.Background().
+type LeaseKeepAlive struct {
+	Name   string
+	Leaser Leaser
+	// Lease is the acquired lease to keep alive. It is not updated on renewal: see CurrentLease.
+	Lease Lease
+	// RenewFraction is the fraction of the remaining lease duration to wait before renewing. Defaults to 1/3.
+	RenewFraction float64
+	// OnLost is called once when the lease is lost.
+	OnLost func(Error)
+
+	Logger  *Logger
+	Context context.Context
+
+	lease    Lease
+	lost     bool
+	ctx      context.Context
+	cancel   context.CancelFunc
+	stop     chan struct{}
+	stopOnce *sync.Once
+	mutex    *sync.Mutex
+	initOnce sync.Once
+}
+
+func (k *LeaseKeepAlive) Init() Error {
+	k.init()
+	return nil
+}
+
+// init creates the internal state of the keep-alive, so a LeaseKeepAlive built as a struct literal can be used.
+func (k *LeaseKeepAlive) init() {
+	k.initOnce.Do(func() {
+		if k.Context == nil {
+			k.Context = context.Background()
+		}
+		if k.ctx == nil {
+			k.ctx, k.cancel = context.WithCancel(k.Context)
+		}
+		if k.stop == nil {
+			k.stop = make(chan struct{})
+		}
+		if k.stopOnce == nil {
+			k.stopOnce = &sync.Once{}
+		}
+		if k.mutex == nil {
+			k.mutex = &sync.Mutex{}
+		}
+		k.lease = k.Lease
+	})
+}
+
+// Run renews the lease until it is lost, the context is done, or Stop is called. The lease is only released by Stop,
+// so a holder may finish its work after the context is done. Returns the renewal Error if the lease was lost.
+func (k *LeaseKeepAlive) Run() Error {
+	k.init()
+	LogDebugf(k, LogOperationRun, LogStatusStart, "start keep-alive for lease: %s", k.CurrentLease().ID)
+
+	for {
+		timer := time.NewTimer(renewInterval(k.CurrentLease(), k.RenewFraction))
+		select {
+		case <-k.ctx.Done():
+			timer.Stop()
+			return nil
+		case <-k.stop:
+			timer.Stop()
+			return nil
+		case <-timer.C:
+		}
+
+		lease := k.CurrentLease()
+		renewed, err := k.Leaser.Renew(lease.ID, lease.Token)
+		if err != nil {
+			LogWarnf(k, LogOperationRun, LogStatusFailed, "lost lease: %s; %+v", lease.ID, *err)
+			err = NewError(ErrorTypeLeaseLost, fmt.Sprintf("lost lease: %s; token: %d", lease.ID, lease.Token), []Error{err})
+			k.mutex.Lock()
+			k.lost = true
+			k.mutex.Unlock()
+			k.cancel()
+			if k.OnLost != nil {
+				k.OnLost(err)
+			}
+			return err
+		}
+
+		k.mutex.Lock()
+		k.lease = renewed
+		k.mutex.Unlock()
+	}
+}
+
+// Stop stops renewing and releases the lease.
+func (k *LeaseKeepAlive) Stop() Error {
+	k.init()
+	LogDebug(k, LogOperationStop, LogStatusStart)
+	first := false
+	k.stopOnce.Do(func() {
+		close(k.stop)
+		first = true
+	})
+	if !first {
+		return nil
+	}
+
+	k.cancel()
+	k.mutex.Lock()
+	lease, lost := k.lease, k.lost
+	k.mutex.Unlock()
+	if lost {
+		return nil
+	}
+
+	if err := k.Leaser.Release(lease.ID, lease.Token); err != nil {
+		LogDebugf(k, LogOperationStop, LogStatusFailed, "%+v", *err)
+		return err
+	}
+	LogDebug(k, LogOperationStop, LogStatusSuccess)
+	return nil
+}
+
+func (k *LeaseKeepAlive) HandleError(err Error) Error {
+	return nil
+}
+
+func (k *LeaseKeepAlive) GetName() string {
+	return k.Name
+}
+
+func (k *LeaseKeepAlive) GetType() string {
+	return "lease-keep-alive"
+}
+
+func (k *LeaseKeepAlive) GetLogger() *Logger {
+	return k.Logger
+}
+
+// stopped returns true once Stop was called.
+func (k *LeaseKeepAlive) stopped() bool {
+	k.init()
+	select {
+	case <-k.stop:
+		return true
+	default:
+		return false
+	}
+}
+
+// LeaseContext returns a context derived from the parent context, canceled when the lease is lost or Stop is called.
+func (k *LeaseKeepAlive) LeaseContext() context.Context {
+	k.init()
+	return k.ctx
+}
+
+// CurrentLease returns the lease as of its last renewal.
+func (k *LeaseKeepAlive) CurrentLease() Lease {
+	k.init()
+	k.mutex.Lock()
+	defer k.mutex.Unlock()
+	return k.lease
+}
+
+// renewInterval returns the time to wait before renewing a lease: fraction of its remaining duration.
+func renewInterval(lease Lease, fraction float64) time.Duration {
+	if fraction <= 0 || fraction >= 1 {
+		fraction = defaultLeaseRenewFraction
+	}
+	return time.Duration(float64(time.Until(lease.ExpiresAt)) * fraction)
+}
+
+// DefaultLeaseKeepAlive returns a new LeaseKeepAlive for an acquired lease. Call Run to start renewing it.
+func DefaultLeaseKeepAlive(name string, leaser Leaser, lease Lease, ctx context.Context) *LeaseKeepAlive {
+	k := &LeaseKeepAlive{
+		Name:          name,
+		Leaser:        leaser,
+		Lease:         lease,
+		RenewFraction: defaultLeaseRenewFraction,
+		Logger:        DefaultLogger(),
+		Context:       ctx,
+	}
+	k.init()
+	return k
+}
+
+// KeepLease starts renewing an acquired lease on behalf of the Worker, until the Worker stops or its context is done.
+// The lease is released by Worker.Stop, once the receptor stopped.
+// Losing the lease triggers the Worker's HandleError path with an Error of type ErrorTypeLeaseLost.
+// Receptors should stop working on the leased resource once LeaseContext is done.
+func (w *Worker) KeepLease(leaser Leaser, lease Lease) *LeaseKeepAlive {
+	k := DefaultLeaseKeepAlive(fmt.Sprintf("%s-lease-%s", w.Name, lease.ID), leaser, lease, w.Context)
+	k.OnLost = func(err Error) {
+		if err = w.HandleError(err); err != nil {
+			LogDebugf(w, LogOperationHandleError, LogStatusFailed, "%+v", *err)
+		}
+	}
+
+	w.initKeepAlives()
+	w.keepAlivesMutex.Lock()
+	w.keepAlives[k] = struct{}{}
+	w.keepAlivesMutex.Unlock()
+
+	go func() {
+		if err := k.Run(); err == nil && !k.stopped() {
+			// the context is done: Worker.Stop releases the lease once the receptor stopped
+			return
+		}
+		// the lease is released or lost: forget the keep-alive
+		w.keepAlivesMutex.Lock()
+		delete(w.keepAlives, k)
+		w.keepAlivesMutex.Unlock()
+	}()
+	return k
+}
+
+// initKeepAlives creates the keep-alives of the worker and their mutex on first use.
+func (w *Worker) initKeepAlives() {
+	w.keepAlivesInit.Do(func() {
+		if w.keepAlivesMutex == nil {
+			w.keepAlivesMutex = &sync.Mutex{}
+		}
+		if w.keepAlives == nil {
+			w.keepAlives = make(map[*LeaseKeepAlive]struct{})
+		}
+	})
+}
+
+// runningKeepAlives returns the keep-alives started by KeepLease that are still running.
+func (w *Worker) runningKeepAlives() []*LeaseKeepAlive {
+	w.initKeepAlives()
+	w.keepAlivesMutex.Lock()
+	defer w.keepAlivesMutex.Unlock()
+	keepAlives := make([]*LeaseKeepAlive, 0, len(w.keepAlives))
+	for k := range w.keepAlives {
+		keepAlives = append(keepAlives, k)
+	}
+	return keepAlives
+}
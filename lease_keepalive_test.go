@@ -0,0 +1,97 @@
+/*
+Copyright 2023 Alexandre Mahdhaoui
+
+Licensed under the Apache License, Version 2.0 (the "License");
+you may not use this file except in compliance with the License.
+You may obtain a copy of the License at
+
+	http://www.apache.org/licenses/LICENSE-2.0
+
+Unless required by applicable law or agreed to in writing, software
+distributed under the License is distributed on an "AS IS" BASIS,
+WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+See the License for the specific language governing permissions and
+limitations under the License.
+*/
+
+package bda
+
+import (
+	"context"
+	"testing"
+	"time"
+)
+
+func TestWorkerStopReleasesLeaseAfterContextIsDone(t *testing.T) {
+	leaser := NewInMemoryLeaserBuilder(WithLeaseDuration(time.Minute)).Build()
+	lease, err := leaser.Acquire("resource", "worker")
+	if err != nil {
+		t.Fatalf("Acquire() error = %v", err)
+	}
+
+	ctx, cancel := context.WithCancel(context.Background())
+	heldOnStop := false
+	receptor := &fakeRuntime{name: "receptor", stop: func() Error {
+		_, heldOnStop = leaser.Holder(lease.ID)
+		return nil
+	}}
+	w := &Worker{Name: "worker", Strategy: DefaultStrategy(), Receptor: receptor, Logger: DefaultLogger(), Context: ctx}
+	k := w.KeepLease(leaser, lease)
+
+	// the pool cancels the context of its workers before stopping them
+	cancel()
+	<-k.LeaseContext().Done()
+	time.Sleep(10 * time.Millisecond)
+
+	if _, held := leaser.Holder(lease.ID); !held {
+		t.Fatalf("lease released before Worker.Stop")
+	}
+	if err := w.Stop(); err != nil {
+		t.Fatalf("Stop() error = %v", err)
+	}
+	if !heldOnStop {
+		t.Fatalf("lease released before the receptor stopped")
+	}
+	if _, held := leaser.Holder(lease.ID); held {
+		t.Fatalf("lease still held after Worker.Stop")
+	}
+}
+
+func TestLeaseKeepAliveLiteralRenewsAndReleases(t *testing.T) {
+	leaser := NewInMemoryLeaserBuilder(WithLeaseDuration(150 * time.Millisecond)).Build()
+	lease, err := leaser.Acquire("resource", "worker")
+	if err != nil {
+		t.Fatalf("Acquire() error = %v", err)
+	}
+
+	k := &LeaseKeepAlive{Name: "keep-alive", Leaser: leaser, Lease: lease}
+	ran := make(chan Error, 1)
+	go func() {
+		ran <- k.Run()
+	}()
+
+	// the lease outlives its duration while it is kept alive
+	time.Sleep(300 * time.Millisecond)
+	if held, ok := leaser.Holder(lease.ID); !ok || held.Token != lease.Token {
+		t.Fatalf("Holder() = %+v, %t; want the kept lease", held, ok)
+	}
+	if current := k.CurrentLease(); !current.ExpiresAt.After(lease.ExpiresAt) {
+		t.Fatalf("CurrentLease() expires at %s; want after %s", current.ExpiresAt, lease.ExpiresAt)
+	}
+
+	if err := k.Stop(); err != nil {
+		t.Fatalf("Stop() error = %v", err)
+	}
+	if err := k.Stop(); err != nil {
+		t.Fatalf("second Stop() error = %v", err)
+	}
+	if err := <-ran; err != nil {
+		t.Fatalf("Run() error = %v", err)
+	}
+	if _, held := leaser.Holder(lease.ID); held {
+		t.Fatalf("lease still held after Stop()")
+	}
+	if k.LeaseContext().Err() == nil {
+		t.Fatalf("LeaseContext() is not done after Stop()")
+	}
+}
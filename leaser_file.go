@@ -0,0 +1,258 @@
+/*
+Copyright 2023 Alexandre Mahdhaoui
+
+Licensed under the Apache License, Version 2.0 (the "License");
+you may not use this file except in compliance with the License.
+You may obtain a copy of the License at
+
+	http://www.apache.org/licenses/LICENSE-2.0
+
+Unless required by applicable law or agreed to in writing, software
+distributed under the License is distributed on an "AS IS" BASIS,
+WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+See the License for the specific language governing permissions and
+limitations under the License.
+*/
+
+package bda
+
+import (
+	"encoding/json"
+	"errors"
+	"fmt"
+	"io/fs"
+	"net/url"
+	"os"
+	"path/filepath"
+	"strconv"
+	"strings"
+	"sync"
+	"time"
+)
+
+const (
+	fileLeaserLockFile  = ".lock"
+	fileLeaserTokenFile = ".token"
+	fileLeaserExtension = ".lease"
+)
+
+// fileLeaser is an implementation of the Leaser interface storing leases as files in a directory.
+// It can be shared by processes running on the same host: every operation holds an advisory lock on the directory's
+// lock file, and lease files are written atomically (write to a temporary file, then rename).
+type fileLeaser struct {
+	Dir           string
+	LeaseDuration time.Duration
+	mutex         *sync.Mutex
+}
+
+// Acquire acquires the lease for an ID if it is not held, expired, or already held by the owner.
+func (l *fileLeaser) Acquire(id, owner string) (Lease, Error) {
+	var lease Lease
+	err := l.locked(func() Error {
+		current, ok, err := l.read(id)
+		if err != nil {
+			return err
+		}
+
+		if ok && !current.Expired() {
+			if current.Owner != owner {
+				return NewError(ErrorTypeLeaseHeld, fmt.Sprintf("cannot acquire lease for id: %s; held by: %s", id, current.Owner), nil)
+			}
+			current.ExpiresAt = time.Now().Add(l.LeaseDuration)
+			lease = current
+			return l.write(lease)
+		}
+
+		token, err := l.nextToken()
+		if err != nil {
+			return err
+		}
+		lease = Lease{
+			ID:        id,
+			Owner:     owner,
+			Token:     token,
+			ExpiresAt: time.Now().Add(l.LeaseDuration),
+		}
+		return l.write(lease)
+	})
+	return lease, err
+}
+
+// Renew extends the lease for an ID if the token matches and the lease did not expire.
+func (l *fileLeaser) Renew(id string, token uint64) (Lease, Error) {
+	var lease Lease
+	err := l.locked(func() Error {
+		current, ok, err := l.read(id)
+		if err != nil {
+			return err
+		}
+		if !ok || current.Token != token || current.Expired() {
+			return NewError(ErrorTypeLeaseLost, fmt.Sprintf("cannot renew lease for id: %s; token: %d", id, token), nil)
+		}
+		current.ExpiresAt = time.Now().Add(l.LeaseDuration)
+		lease = current
+		return l.write(lease)
+	})
+	return lease, err
+}
+
+// Release releases the lease for an ID if the token matches.
+func (l *fileLeaser) Release(id string, token uint64) Error {
+	return l.locked(func() Error {
+		current, ok, err := l.read(id)
+		if err != nil {
+			return err
+		}
+		if !ok || current.Token != token {
+			return NewError(ErrorTypeLeaseLost, fmt.Sprintf("cannot release lease for id: %s; token: %d", id, token), nil)
+		}
+		current.ExpiresAt = time.Now()
+		return l.write(current)
+	})
+}
+
+// Holder returns the current lease for an ID, and false if the lease is not held or expired.
+func (l *fileLeaser) Holder(id string) (Lease, bool) {
+	var (
+		lease Lease
+		held  bool
+	)
+	_ = l.locked(func() Error {
+		current, ok, err := l.read(id)
+		if err != nil || !ok || current.Expired() {
+			return err
+		}
+		lease, held = current, true
+		return nil
+	})
+	return lease, held
+}
+
+// locked runs f while holding both the in-process mutex and the advisory lock of the directory.
+func (l *fileLeaser) locked(f func() Error) Error {
+	l.mutex.Lock()
+	defer l.mutex.Unlock()
+
+	if err := os.MkdirAll(l.Dir, 0o755); err != nil {
+		return NewError("FileLeaserError", err.Error(), nil)
+	}
+	lock, err := os.OpenFile(filepath.Join(l.Dir, fileLeaserLockFile), os.O_CREATE|os.O_RDWR, 0o644)
+	if err != nil {
+		return NewError("FileLeaserError", err.Error(), nil)
+	}
+	defer lock.Close()
+
+	if err := lockFile(lock); err != nil {
+		return NewError("FileLeaserError", err.Error(), nil)
+	}
+	defer unlockFile(lock)
+
+	return f()
+}
+
+func (l *fileLeaser) path(id string) string {
+	return filepath.Join(l.Dir, url.PathEscape(id)+fileLeaserExtension)
+}
+
+func (l *fileLeaser) read(id string) (Lease, bool, Error) {
+	data, err := os.ReadFile(l.path(id))
+	if errors.Is(err, fs.ErrNotExist) {
+		return Lease{}, false, nil
+	}
+	if err != nil {
+		return Lease{}, false, NewError("FileLeaserError", err.Error(), nil)
+	}
+
+	var lease Lease
+	if err := json.Unmarshal(data, &lease); err != nil {
+		return Lease{}, false, NewError("FileLeaserError", fmt.Sprintf("cannot decode lease for id: %s; %v", id, err), nil)
+	}
+	return lease, true, nil
+}
+
+func (l *fileLeaser) write(lease Lease) Error {
+	data, err := json.Marshal(lease)
+	if err != nil {
+		return NewError("FileLeaserError", err.Error(), nil)
+	}
+	if err := writeFileAtomic(l.path(lease.ID), data, 0o644); err != nil {
+		return NewError("FileLeaserError", err.Error(), nil)
+	}
+	return nil
+}
+
+// nextToken increments and returns the fencing token persisted in the directory.
+func (l *fileLeaser) nextToken() (uint64, Error) {
+	path := filepath.Join(l.Dir, fileLeaserTokenFile)
+
+	var token uint64
+	data, err := os.ReadFile(path)
+	switch {
+	case errors.Is(err, fs.ErrNotExist):
+	case err != nil:
+		return 0, NewError("FileLeaserError", err.Error(), nil)
+	default:
+		token, err = strconv.ParseUint(strings.TrimSpace(string(data)), 10, 64)
+		if err != nil {
+			return 0, NewError("FileLeaserError", fmt.Sprintf("cannot decode fencing token; %v", err), nil)
+		}
+	}
+
+	token++
+	if err := writeFileAtomic(path, []byte(strconv.FormatUint(token, 10)), 0o644); err != nil {
+		return 0, NewError("FileLeaserError", err.Error(), nil)
+	}
+	return token, nil
+}
+
+// writeFileAtomic writes data to a temporary file in the same directory, then renames it to path, so readers never
+// observe a partially written file.
+func writeFileAtomic(path string, data []byte, perm os.FileMode) error {
+	tmp, err := os.CreateTemp(filepath.Dir(path), "."+filepath.Base(path)+".*.tmp")
+	if err != nil {
+		return err
+	}
+	defer os.Remove(tmp.Name())
+
+	if _, err := tmp.Write(data); err != nil {
+		tmp.Close()
+		return err
+	}
+	if err := tmp.Sync(); err != nil {
+		tmp.Close()
+		return err
+	}
+	if err := tmp.Close(); err != nil {
+		return err
+	}
+	if err := os.Chmod(tmp.Name(), perm); err != nil {
+		return err
+	}
+	return os.Rename(tmp.Name(), path)
+}
+
+// fileLeaserBuilder is a builder implementation for fileLeaser.
+type fileLeaserBuilder struct {
+	Dir           string
+	LeaseDuration time.Duration
+}
+
+// Build creates and returns a new instance of fileLeaser.
+func (b *fileLeaserBuilder) Build() Leaser {
+	return &fileLeaser{
+		Dir:           b.Dir,
+		LeaseDuration: positiveOrDefault(b.LeaseDuration, DefaultLeaseDuration),
+		mutex:         &sync.Mutex{},
+	}
+}
+
+// NewFileLeaserBuilder returns a LeaserBuilder storing leases in dir. Leasers built with the same dir share leases
+// across processes of the same host.
+// Leases last DefaultLeaseDuration unless WithLeaseDuration is provided.
+func NewFileLeaserBuilder(dir string, opts ...LeaserOption) LeaserBuilder {
+	o := newLeaserOptions(opts)
+	return &fileLeaserBuilder{
+		Dir:           dir,
+		LeaseDuration: o.LeaseDuration,
+	}
+}
@@ -0,0 +1,102 @@
+/*
+Copyright 2023 Alexandre Mahdhaoui
+
+Licensed under the Apache License, Version 2.0 (the "License");
+you may not use this file except in compliance with the License.
+You may obtain a copy of the License at
+
+	http://www.apache.org/licenses/LICENSE-2.0
+
+Unless required by applicable law or agreed to in writing, software
+distributed under the License is distributed on an "AS IS" BASIS,
+WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+See the License for the specific language governing permissions and
+limitations under the License.
+*/
+
+package bda
+
+import (
+	"fmt"
+	"time"
+)
+
+// tcpLeaser is an implementation of the Leaser interface backed by a KVServer, usable across hosts.
+// Lease expiry is evaluated by the server. The server returns the remaining duration of leases, and ExpiresAt is
+// computed from the client's clock when the request was sent, so clock skew between hosts does not matter and the
+// client never considers a lease valid longer than the server does.
+type tcpLeaser struct {
+	client        *kvClient
+	LeaseDuration time.Duration
+}
+
+func (l *tcpLeaser) Acquire(id, owner string) (Lease, Error) {
+	return l.doLease(kvRequest{Op: kvOpLeaseAcquire, ID: id, Owner: owner, Duration: l.LeaseDuration})
+}
+
+func (l *tcpLeaser) Renew(id string, token uint64) (Lease, Error) {
+	return l.doLease(kvRequest{Op: kvOpLeaseRenew, ID: id, Token: token, Duration: l.LeaseDuration})
+}
+
+func (l *tcpLeaser) Release(id string, token uint64) Error {
+	resp, err := l.client.do(kvRequest{Op: kvOpLeaseRelease, ID: id, Token: token})
+	if err != nil {
+		return err
+	}
+	return resp.Error
+}
+
+// Holder returns the current lease for an ID. Returns false if the lease is not held, or if the server is unreachable.
+func (l *tcpLeaser) Holder(id string) (Lease, bool) {
+	sent := time.Now()
+	resp, err := l.client.do(kvRequest{Op: kvOpLeaseHolder, ID: id})
+	if err != nil || !resp.Ok || resp.Lease == nil {
+		return Lease{}, false
+	}
+	return localLease(*resp.Lease, sent, resp.TTL), true
+}
+
+func (l *tcpLeaser) doLease(req kvRequest) (Lease, Error) {
+	sent := time.Now()
+	resp, err := l.client.do(req)
+	if err != nil {
+		return Lease{}, err
+	}
+	if resp.Error != nil {
+		return Lease{}, resp.Error
+	}
+	if resp.Lease == nil {
+		return Lease{}, NewError("KVClientError", fmt.Sprintf("missing lease in response to: %s", req.Op), nil)
+	}
+	return localLease(*resp.Lease, sent, resp.TTL), nil
+}
+
+// localLease sets the expiry of a lease returned by the server in the client's clock: ttl after the request was sent.
+func localLease(lease Lease, sent time.Time, ttl time.Duration) Lease {
+	lease.ExpiresAt = sent.Add(ttl)
+	return lease
+}
+
+// tcpLeaserBuilder is a builder implementation for tcpLeaser.
+type tcpLeaserBuilder struct {
+	Addr          string
+	LeaseDuration time.Duration
+}
+
+// Build creates and returns a new instance of tcpLeaser.
+func (b *tcpLeaserBuilder) Build() Leaser {
+	return &tcpLeaser{
+		client:        newKVClient(b.Addr),
+		LeaseDuration: positiveOrDefault(b.LeaseDuration, DefaultLeaseDuration),
+	}
+}
+
+// NewTCPLeaserBuilder returns a LeaserBuilder whose Leasers share leases through the KVServer listening on addr.
+// Leases last DefaultLeaseDuration unless WithLeaseDuration is provided.
+func NewTCPLeaserBuilder(addr string, opts ...LeaserOption) LeaserBuilder {
+	o := newLeaserOptions(opts)
+	return &tcpLeaserBuilder{
+		Addr:          addr,
+		LeaseDuration: o.LeaseDuration,
+	}
+}
@@ -0,0 +1,219 @@
+/*
+Copyright 2023 Alexandre Mahdhaoui
+
+Licensed under the Apache License, Version 2.0 (the "License");
+you may not use this file except in compliance with the License.
+You may obtain a copy of the License at
+
+	http://www.apache.org/licenses/LICENSE-2.0
+
+Unless required by applicable law or agreed to in writing, software
+distributed under the License is distributed on an "AS IS" BASIS,
+WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+See the License for the specific language governing permissions and
+limitations under the License.
+*/
+
+package bda
+
+import (
+	"fmt"
+	"sync"
+	"sync/atomic"
+	"testing"
+	"time"
+)
+
+func TestLeaserBuildersDefaultNonPositiveLeaseDuration(t *testing.T) {
+	for _, tc := range leaserBackends(t) {
+		for _, d := range []time.Duration{0, -time.Second} {
+			leaser := tc.builder(WithLeaseDuration(d)).Build()
+			lease, err := leaser.Acquire(tc.name, "owner")
+			if err != nil {
+				t.Fatalf("%s: Acquire() error = %v", tc.name, err)
+			}
+			if lease.Expired() || time.Until(lease.ExpiresAt) > DefaultLeaseDuration {
+				t.Fatalf("%s: WithLeaseDuration(%s) lease expires at %v; want DefaultLeaseDuration", tc.name, d, lease.ExpiresAt)
+			}
+			if err := leaser.Release(lease.ID, lease.Token); err != nil {
+				t.Fatalf("%s: Release() error = %v", tc.name, err)
+			}
+		}
+	}
+}
+
+// leaserContractDuration is the lease duration of the leasers tested against the Leaser contract: leases expire
+// quickly, so tests can wait for them to expire.
+const leaserContractDuration = 100 * time.Millisecond
+
+// testLeaserContract tests the fencing tokens and the ownership of the leases of a Leaser.
+func testLeaserContract(t *testing.T, leaser Leaser) {
+	t.Run("tokens increase", func(t *testing.T) {
+		a, err := leaser.Acquire("tokens-a", "owner-1")
+		if err != nil {
+			t.Fatalf("Acquire() error = %v", err)
+		}
+		b, err := leaser.Acquire("tokens-b", "owner-2")
+		if err != nil {
+			t.Fatalf("Acquire() error = %v", err)
+		}
+		if b.Token <= a.Token {
+			t.Fatalf("token of the second lease = %d; want greater than %d", b.Token, a.Token)
+		}
+
+		// the holder acquiring its lease again renews it and keeps its token
+		again, err := leaser.Acquire("tokens-a", "owner-1")
+		if err != nil || again.Token != a.Token {
+			t.Fatalf("Acquire() by the holder = %+v, %v; want token %d", again, err, a.Token)
+		}
+
+		if err := leaser.Release(a.ID, a.Token); err != nil {
+			t.Fatalf("Release() error = %v", err)
+		}
+		c, err := leaser.Acquire("tokens-a", "owner-2")
+		if err != nil {
+			t.Fatalf("Acquire() after Release() error = %v", err)
+		}
+		if c.Token <= b.Token {
+			t.Fatalf("token after Release() = %d; want greater than %d", c.Token, b.Token)
+		}
+	})
+
+	t.Run("held by another owner", func(t *testing.T) {
+		held, err := leaser.Acquire("held", "owner-1")
+		if err != nil {
+			t.Fatalf("Acquire() error = %v", err)
+		}
+		if _, err := leaser.Acquire("held", "owner-2"); err == nil || err.Type != ErrorTypeLeaseHeld {
+			t.Fatalf("Acquire() by another owner error = %v; want %s", err, ErrorTypeLeaseHeld)
+		}
+
+		// a token that is not the holder's cannot renew nor release the lease
+		other := held.Token + 1000
+		if _, err := leaser.Renew(held.ID, other); err == nil || err.Type != ErrorTypeLeaseLost {
+			t.Fatalf("Renew() with another token error = %v; want %s", err, ErrorTypeLeaseLost)
+		}
+		if err := leaser.Release(held.ID, other); err == nil || err.Type != ErrorTypeLeaseLost {
+			t.Fatalf("Release() with another token error = %v; want %s", err, ErrorTypeLeaseLost)
+		}
+		if holder, ok := leaser.Holder(held.ID); !ok || holder.Owner != "owner-1" || holder.Token != held.Token {
+			t.Fatalf("Holder() = %+v, %t; want owner-1 with token %d", holder, ok, held.Token)
+		}
+		if _, err := leaser.Renew(held.ID, held.Token); err != nil {
+			t.Fatalf("Renew() by the holder error = %v", err)
+		}
+	})
+
+	t.Run("re-acquired after expiry", func(t *testing.T) {
+		stale, err := leaser.Acquire("expiry", "owner-1")
+		if err != nil {
+			t.Fatalf("Acquire() error = %v", err)
+		}
+		time.Sleep(2 * leaserContractDuration)
+		if _, ok := leaser.Holder(stale.ID); ok {
+			t.Fatalf("Holder() = true after the lease expired")
+		}
+
+		current, err := leaser.Acquire("expiry", "owner-2")
+		if err != nil {
+			t.Fatalf("Acquire() after expiry error = %v", err)
+		}
+		if current.Token <= stale.Token {
+			t.Fatalf("token after expiry = %d; want greater than %d", current.Token, stale.Token)
+		}
+
+		// the previous holder cannot renew nor release the lease with its stale token
+		if _, err := leaser.Renew(stale.ID, stale.Token); err == nil || err.Type != ErrorTypeLeaseLost {
+			t.Fatalf("Renew() with a stale token error = %v; want %s", err, ErrorTypeLeaseLost)
+		}
+		if err := leaser.Release(stale.ID, stale.Token); err == nil || err.Type != ErrorTypeLeaseLost {
+			t.Fatalf("Release() with a stale token error = %v; want %s", err, ErrorTypeLeaseLost)
+		}
+		if holder, ok := leaser.Holder(stale.ID); !ok || holder.Owner != "owner-2" {
+			t.Fatalf("Holder() = %+v, %t; want owner-2", holder, ok)
+		}
+	})
+}
+
+// leaserBackends returns a LeaserBuilder constructor for every Leaser backend.
+func leaserBackends(t *testing.T) []struct {
+	name    string
+	builder func(opts ...LeaserOption) LeaserBuilder
+} {
+	s := startKVServer(t)
+	dir := t.TempDir()
+	return []struct {
+		name    string
+		builder func(opts ...LeaserOption) LeaserBuilder
+	}{
+		{name: "in-memory", builder: NewInMemoryLeaserBuilder},
+		{name: "file", builder: func(opts ...LeaserOption) LeaserBuilder { return NewFileLeaserBuilder(dir, opts...) }},
+		{name: "tcp", builder: func(opts ...LeaserOption) LeaserBuilder { return NewTCPLeaserBuilder(s.ListenAddr(), opts...) }},
+	}
+}
+
+func TestLeaserContract(t *testing.T) {
+	for _, backend := range leaserBackends(t) {
+		t.Run(backend.name, func(t *testing.T) {
+			testLeaserContract(t, backend.builder(WithLeaseDuration(leaserContractDuration)).Build())
+		})
+	}
+}
+
+// TestLeaserContention runs two holders sharing a backend, each with its own Leaser, contending for the same lease:
+// at most one of them holds it at any time.
+func TestLeaserContention(t *testing.T) {
+	for _, backend := range leaserBackends(t) {
+		t.Run(backend.name, func(t *testing.T) {
+			builder := backend.builder(WithLeaseDuration(time.Minute))
+			leasers := []Leaser{builder.Build(), builder.Build()}
+			if backend.name == "in-memory" {
+				// in-memory leasers do not share their state
+				leasers[1] = leasers[0]
+			}
+
+			holders := &atomic.Int64{}
+			acquired := &atomic.Int64{}
+			wg := &sync.WaitGroup{}
+			for i, leaser := range leasers {
+				i, leaser := i, leaser
+				wg.Add(1)
+				go func() {
+					defer wg.Done()
+					owner := fmt.Sprintf("holder-%d", i)
+					for j := 0; j < 20; j++ {
+						lease, err := leaser.Acquire("contended", owner)
+						if err != nil {
+							if err.Type != ErrorTypeLeaseHeld {
+								t.Errorf("%s: Acquire() error = %v; want %s", owner, err, ErrorTypeLeaseHeld)
+								return
+							}
+							time.Sleep(time.Millisecond)
+							continue
+						}
+						acquired.Add(1)
+						if n := holders.Add(1); n != 1 {
+							t.Errorf("%d holders of the lease; want 1", n)
+						}
+						if _, err := leaser.Renew(lease.ID, lease.Token); err != nil {
+							t.Errorf("%s: Renew() error = %v", owner, err)
+						}
+						time.Sleep(time.Millisecond)
+						holders.Add(-1)
+						if err := leaser.Release(lease.ID, lease.Token); err != nil {
+							t.Errorf("%s: Release() error = %v", owner, err)
+						}
+					}
+				}()
+			}
+			wg.Wait()
+
+			if acquired.Load() == 0 {
+				t.Fatalf("no holder acquired the lease")
+			}
+			if _, held := leasers[0].Holder("contended"); held {
+				t.Fatalf("lease still held after every holder released it")
+			}
+		})
+	}
+}
@@ -24,8 +24,11 @@ import (
 type Orchestrator struct {
 	Name        string
 	WorkerPools SafeArray[*WorkerPool]
-	Strategy    Strategy
-	Logger      *Logger
+	// Strategy is the Strategy of the pools that have no Strategy registered in Strategies.
+	Strategy Strategy
+	// Strategies resolves the Strategy of each pool by name or labels. It is optional.
+	Strategies *StrategyRegistry
+	Logger     *Logger
 
 	Context context.Context
 }
@@ -40,7 +43,7 @@ func (o *Orchestrator) Init() Error {
 		wg.Add(1)
 		go func(wg *sync.WaitGroup) {
 			p, _ := o.WorkerPools.Get(i)
-			if err := o.Strategy.Init(p); err != nil {
+			if err := o.strategy(p).Init(p); err != nil {
 				errs.Append(err)
 			}
 			wg.Done()
@@ -59,7 +62,7 @@ func (o *Orchestrator) Run() Error {
 		wg.Add(1)
 		go func(wg *sync.WaitGroup) {
 			p, _ := o.WorkerPools.Get(i)
-			err := o.Strategy.Run(p)
+			err := o.strategy(p).Run(p)
 			if err != nil {
 				errs.Append(err)
 			}
@@ -84,7 +87,7 @@ func (o *Orchestrator) Stop() Error {
 		p, _ := o.WorkerPools.Get(i)
 		wg.Add(1)
 		go func(wg *sync.WaitGroup) {
-			err := o.Strategy.Stop(p)
+			err := o.strategy(p).Stop(p)
 			if err != nil {
 				errs.Append(err)
 			}
@@ -95,6 +98,16 @@ func (o *Orchestrator) Stop() Error {
 	return HandleErrors(o, LogOperationStop, errs)
 }
 
+// strategy returns the Strategy of a pool.
+func (o *Orchestrator) strategy(p *WorkerPool) Strategy {
+	if o.Strategies != nil {
+		if strategy, ok := o.Strategies.Resolve(p.Name, p.Labels); ok {
+			return strategy
+		}
+	}
+	return o.Strategy
+}
+
 func (o *Orchestrator) GetName() string {
 	return o.Name
 }
@@ -106,3 +119,7 @@ func (o *Orchestrator) GetType() string {
 func (o *Orchestrator) GetLogger() *Logger {
 	return o.Logger
 }
+
+func (o *Orchestrator) GetContext() context.Context {
+	return o.Context
+}
@@ -0,0 +1,319 @@
+/*
+Copyright 2023 Alexandre Mahdhaoui
+
+Licensed under the Apache License, Version 2.0 (the "License");
+you may not use this file except in compliance with the License.
+You may obtain a copy of the License at
+
+	http://www.apache.org/licenses/LICENSE-2.0
+
+Unless required by applicable law or agreed to in writing, software
+distributed under the License is distributed on an "AS IS" BASIS,
+WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+See the License for the specific language governing permissions and
+limitations under the License.
+*/
+
+package bda
+
+import (
+	"context"
+	"fmt"
+	"sync"
+	"time"
+)
+
+const (
+	// ErrorTypeRateLimited is the type of the Error returned when a LeakyBucket is full.
+	ErrorTypeRateLimited ErrorType = "RateLimitedError"
+	// ErrorTypeInvalidRateLimit is the type of the Error returned when a RateLimiter is built with an invalid rate,
+	// burst or capacity.
+	ErrorTypeInvalidRateLimit ErrorType = "InvalidRateLimitError"
+
+	// rateLimitRetryDelay is the delay before waiting again for a RateLimiter that rejected a run.
+	rateLimitRetryDelay = 100 * time.Millisecond
+)
+
+// RateLimiter limits the rate of operations. A RateLimiter may be shared by the workers of several pools, so they
+// share the same quota.
+type RateLimiter interface {
+	// Wait method blocks until an operation is allowed, or until the context is done.
+	Wait(ctx context.Context) Error
+	// Allow method returns true if an operation is allowed now, without waiting.
+	Allow() bool
+}
+
+//----------------------------------------------------------------------------------------------------------------------
+//- TokenBucket
+
+// TokenBucket is a RateLimiter holding up to Burst tokens, refilled at Rate tokens per second. Each operation takes a
+// token: bursts of up to Burst operations are allowed, then operations are limited to Rate per second.
+type TokenBucket struct {
+	Rate  float64
+	Burst int
+
+	tokens float64
+	last   time.Time
+	mutex  *sync.Mutex
+}
+
+func (b *TokenBucket) Wait(ctx context.Context) Error {
+	b.mutex.Lock()
+	b.refill()
+	// tokens may go negative: callers reserve future tokens and wait for them.
+	b.tokens--
+	deficit := -b.tokens
+	b.mutex.Unlock()
+
+	if deficit <= 0 {
+		return nil
+	}
+
+	timer := time.NewTimer(time.Duration(deficit / b.Rate * float64(time.Second)))
+	defer timer.Stop()
+	select {
+	case <-timer.C:
+		return nil
+	case <-ctx.Done():
+		// give the reserved token back. Tokens are interchangeable: the operations reserving after this one may use it
+		// earlier, but no more operations are let through than the rate and burst allow.
+		b.mutex.Lock()
+		b.refill()
+		b.tokens++
+		if b.tokens > float64(b.Burst) {
+			b.tokens = float64(b.Burst)
+		}
+		b.mutex.Unlock()
+		return NewError("ContextError", ctx.Err().Error(), nil)
+	}
+}
+
+func (b *TokenBucket) Allow() bool {
+	b.mutex.Lock()
+	defer b.mutex.Unlock()
+	b.refill()
+	if b.tokens < 1 {
+		return false
+	}
+	b.tokens--
+	return true
+}
+
+func (b *TokenBucket) refill() {
+	now := time.Now()
+	b.tokens += now.Sub(b.last).Seconds() * b.Rate
+	if b.tokens > float64(b.Burst) {
+		b.tokens = float64(b.Burst)
+	}
+	b.last = now
+}
+
+// NewTokenBucket returns a new, full TokenBucket allowing rate operations per second, with bursts of up to burst
+// operations. Returns an Error of type ErrorTypeInvalidRateLimit if rate is not positive or burst is lower than 1.
+func NewTokenBucket(rate float64, burst int) (*TokenBucket, Error) {
+	if err := validateRateLimit(rate, "burst", burst); err != nil {
+		return nil, err
+	}
+	return &TokenBucket{
+		Rate:   rate,
+		Burst:  burst,
+		tokens: float64(burst),
+		last:   time.Now(),
+		mutex:  &sync.Mutex{},
+	}, nil
+}
+
+//----------------------------------------------------------------------------------------------------------------------
+//- LeakyBucket
+
+// LeakyBucket is a RateLimiter letting operations through at a constant Rate per second, without bursts. Up to
+// Capacity operations may wait for their turn; Wait returns an Error of type ErrorTypeRateLimited when the bucket is
+// full.
+type LeakyBucket struct {
+	Rate     float64
+	Capacity int
+
+	interval time.Duration
+	// next is the time the next operation is let through.
+	next  time.Time
+	mutex *sync.Mutex
+}
+
+// Wait method blocks until the turn of the operation. The turn of an operation whose context is done is given back if
+// no later operation reserved its turn yet.
+func (b *LeakyBucket) Wait(ctx context.Context) Error {
+	b.mutex.Lock()
+	now := time.Now()
+	slot := b.next
+	if slot.Before(now) {
+		slot = now
+	}
+	if slot.Sub(now) > time.Duration(b.Capacity)*b.interval {
+		b.mutex.Unlock()
+		return NewError(ErrorTypeRateLimited, fmt.Sprintf("leaky bucket is full; capacity: %d", b.Capacity), nil)
+	}
+	b.next = slot.Add(b.interval)
+	b.mutex.Unlock()
+
+	delay := slot.Sub(now)
+	if delay <= 0 {
+		return nil
+	}
+
+	timer := time.NewTimer(delay)
+	defer timer.Stop()
+	select {
+	case <-timer.C:
+		return nil
+	case <-ctx.Done():
+		// give the reserved turn back, unless a later operation reserved the following turn: moving next back would
+		// give the turn of that operation to the next one as well.
+		b.mutex.Lock()
+		if b.next.Equal(slot.Add(b.interval)) {
+			b.next = slot
+		}
+		b.mutex.Unlock()
+		return NewError("ContextError", ctx.Err().Error(), nil)
+	}
+}
+
+func (b *LeakyBucket) Allow() bool {
+	b.mutex.Lock()
+	defer b.mutex.Unlock()
+	now := time.Now()
+	if b.next.After(now) {
+		return false
+	}
+	b.next = now.Add(b.interval)
+	return true
+}
+
+// NewLeakyBucket returns a new LeakyBucket letting rate operations per second through, with up to capacity operations
+// waiting for their turn. Returns an Error of type ErrorTypeInvalidRateLimit if rate is not positive or capacity is
+// lower than 1.
+func NewLeakyBucket(rate float64, capacity int) (*LeakyBucket, Error) {
+	if err := validateRateLimit(rate, "capacity", capacity); err != nil {
+		return nil, err
+	}
+	return &LeakyBucket{
+		Rate:     rate,
+		Capacity: capacity,
+		interval: time.Duration(float64(time.Second) / rate),
+		mutex:    &sync.Mutex{},
+	}, nil
+}
+
+// validateRateLimit rejects rates that are not positive, which would disable the limit, and sizes lower than 1.
+func validateRateLimit(rate float64, sizeName string, size int) Error {
+	if !(rate > 0) {
+		return NewError(ErrorTypeInvalidRateLimit, fmt.Sprintf("rate should be positive; got: %v", rate), nil)
+	}
+	if size < 1 {
+		return NewError(ErrorTypeInvalidRateLimit, fmt.Sprintf("%s should be at least 1; got: %d", sizeName, size), nil)
+	}
+	return nil
+}
+
+//----------------------------------------------------------------------------------------------------------------------
+//- Semaphore
+
+// Semaphore limits the number of concurrent operations. A Semaphore may be shared by the workers of several pools.
+type Semaphore struct {
+	slots chan struct{}
+}
+
+// Acquire method blocks until a slot is available, or until the context is done.
+func (s *Semaphore) Acquire(ctx context.Context) Error {
+	select {
+	case s.slots <- struct{}{}:
+		return nil
+	case <-ctx.Done():
+		return NewError("ContextError", ctx.Err().Error(), nil)
+	}
+}
+
+// TryAcquire method returns true if a slot was acquired without waiting.
+func (s *Semaphore) TryAcquire() bool {
+	select {
+	case s.slots <- struct{}{}:
+		return true
+	default:
+		return false
+	}
+}
+
+// Release method releases a slot acquired with Acquire or TryAcquire.
+func (s *Semaphore) Release() {
+	<-s.slots
+}
+
+// Capacity method returns the maximum number of concurrent operations.
+func (s *Semaphore) Capacity() int {
+	return cap(s.slots)
+}
+
+// InUse method returns the number of slots currently acquired.
+func (s *Semaphore) InUse() int {
+	return len(s.slots)
+}
+
+// NewSemaphore returns a new Semaphore allowing n concurrent operations.
+func NewSemaphore(n int) *Semaphore {
+	if n < 1 {
+		n = 1
+	}
+	return &Semaphore{slots: make(chan struct{}, n)}
+}
+
+//----------------------------------------------------------------------------------------------------------------------
+//- WorkerPoolStrategy wrappers
+
+// WorkerPoolStrategyRateLimited returns a WorkerPoolStrategy running strategy repeatedly, each run waiting for the
+// RateLimiter first. strategy should be a single-run strategy such as WorkerPoolStrategyRunOnce. Share the RateLimiter
+// between pools to share the quota across them.
+func WorkerPoolStrategyRateLimited(limiter RateLimiter, strategy WorkerPoolStrategy) WorkerPoolStrategy {
+	return WorkerPoolStrategyFunc(func(ctx context.Context, h WorkerHandle) Error {
+		p, i := h.Pool(), h.Index()
+		LogDebugf(p, LogOperationRun, LogStatusProgress, "starting rate-limited loop for worker-%d", i)
+
+		for ctx.Err() == nil {
+			if err := limiter.Wait(ctx); err != nil {
+				if ctx.Err() != nil {
+					return nil
+				}
+				// the limiter rejected the run, e.g. a full LeakyBucket: try again later
+				LogDebugf(p, LogOperationRun, LogStatusProgress, "run of worker-%d rejected by rate limiter; %v", i, err)
+				timer := time.NewTimer(rateLimitRetryDelay)
+				select {
+				case <-ctx.Done():
+					timer.Stop()
+					return nil
+				case <-timer.C:
+				}
+				continue
+			}
+			h.Report(strategy.Run(ctx, h))
+		}
+		return nil
+	})
+}
+
+// WorkerPoolStrategyConcurrencyLimited returns a WorkerPoolStrategy running strategy repeatedly, each run holding a
+// slot of the Semaphore. strategy should be a single-run strategy such as WorkerPoolStrategyRunOnce. Share the
+// Semaphore between pools to bound the number of workers running at once across them.
+func WorkerPoolStrategyConcurrencyLimited(semaphore *Semaphore, strategy WorkerPoolStrategy) WorkerPoolStrategy {
+	return WorkerPoolStrategyFunc(func(ctx context.Context, h WorkerHandle) Error {
+		LogDebugf(h.Pool(), LogOperationRun, LogStatusProgress, "starting concurrency-limited loop for worker-%d", h.Index())
+
+		for ctx.Err() == nil {
+			if err := semaphore.Acquire(ctx); err != nil {
+				return nil
+			}
+			h.Report(func() Error {
+				defer semaphore.Release()
+				return strategy.Run(ctx, h)
+			}())
+		}
+		return nil
+	})
+}
@@ -0,0 +1,277 @@
+/*
+Copyright 2023 Alexandre Mahdhaoui
+
+Licensed under the Apache License, Version 2.0 (the "License");
+you may not use this file except in compliance with the License.
+You may obtain a copy of the License at
+
+	http://www.apache.org/licenses/LICENSE-2.0
+
+Unless required by applicable law or agreed to in writing, software
+distributed under the License is distributed on an "AS IS" BASIS,
+WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+See the License for the specific language governing permissions and
+limitations under the License.
+*/
+
+package bda
+
+import (
+	"context"
+	"math"
+	"testing"
+	"time"
+)
+
+func TestNewTokenBucketRejectsInvalidArguments(t *testing.T) {
+	for _, tc := range []struct {
+		name  string
+		rate  float64
+		burst int
+	}{
+		{name: "zero rate", rate: 0, burst: 1},
+		{name: "negative rate", rate: -1, burst: 1},
+		{name: "NaN rate", rate: math.NaN(), burst: 1},
+		{name: "zero burst", rate: 1, burst: 0},
+		{name: "negative burst", rate: 1, burst: -1},
+	} {
+		t.Run(tc.name, func(t *testing.T) {
+			b, err := NewTokenBucket(tc.rate, tc.burst)
+			if err == nil || err.Type != ErrorTypeInvalidRateLimit {
+				t.Fatalf("NewTokenBucket(%v, %d) error = %v; want %s", tc.rate, tc.burst, err, ErrorTypeInvalidRateLimit)
+			}
+			if b != nil {
+				t.Fatalf("NewTokenBucket(%v, %d) = %v; want nil", tc.rate, tc.burst, b)
+			}
+		})
+	}
+}
+
+func TestNewLeakyBucketRejectsInvalidArguments(t *testing.T) {
+	for _, tc := range []struct {
+		name     string
+		rate     float64
+		capacity int
+	}{
+		{name: "zero rate", rate: 0, capacity: 1},
+		{name: "negative rate", rate: -1, capacity: 1},
+		{name: "NaN rate", rate: math.NaN(), capacity: 1},
+		{name: "zero capacity", rate: 1, capacity: 0},
+		{name: "negative capacity", rate: 1, capacity: -1},
+	} {
+		t.Run(tc.name, func(t *testing.T) {
+			b, err := NewLeakyBucket(tc.rate, tc.capacity)
+			if err == nil || err.Type != ErrorTypeInvalidRateLimit {
+				t.Fatalf("NewLeakyBucket(%v, %d) error = %v; want %s", tc.rate, tc.capacity, err, ErrorTypeInvalidRateLimit)
+			}
+			if b != nil {
+				t.Fatalf("NewLeakyBucket(%v, %d) = %v; want nil", tc.rate, tc.capacity, b)
+			}
+		})
+	}
+}
+
+func TestTokenBucketLimitsRate(t *testing.T) {
+	b, err := NewTokenBucket(20, 2)
+	if err != nil {
+		t.Fatalf("NewTokenBucket() error = %v", err)
+	}
+
+	allowed := 0
+	for i := 0; i < 10; i++ {
+		if b.Allow() {
+			allowed++
+		}
+	}
+	if allowed != 2 {
+		t.Fatalf("Allow() succeeded %d times; want burst of 2", allowed)
+	}
+
+	// the bucket is empty: each Wait takes a token refilled every 50ms
+	start := time.Now()
+	for i := 0; i < 3; i++ {
+		if err := b.Wait(context.Background()); err != nil {
+			t.Fatalf("Wait() error = %v", err)
+		}
+	}
+	if elapsed := time.Since(start); elapsed < 100*time.Millisecond {
+		t.Fatalf("3 Wait() took %s; want at least 100ms", elapsed)
+	}
+}
+
+func TestLeakyBucketLimitsRate(t *testing.T) {
+	b, err := NewLeakyBucket(20, 1)
+	if err != nil {
+		t.Fatalf("NewLeakyBucket() error = %v", err)
+	}
+
+	allowed := 0
+	for i := 0; i < 10; i++ {
+		if b.Allow() {
+			allowed++
+		}
+	}
+	if allowed != 1 {
+		t.Fatalf("Allow() succeeded %d times; want 1", allowed)
+	}
+
+	// one operation may wait for its turn, the next one is rejected.
+	reserved := b.next
+	waited := make(chan Error, 1)
+	go func() {
+		waited <- b.Wait(context.Background())
+	}()
+	for {
+		b.mutex.Lock()
+		next := b.next
+		b.mutex.Unlock()
+		if next.After(reserved) {
+			break
+		}
+		time.Sleep(time.Millisecond)
+	}
+	if err := b.Wait(context.Background()); err == nil || err.Type != ErrorTypeRateLimited {
+		t.Fatalf("Wait() error = %v; want %s", err, ErrorTypeRateLimited)
+	}
+	if err := <-waited; err != nil {
+		t.Fatalf("Wait() error = %v", err)
+	}
+}
+
+func TestLeakyBucketRefundsCanceledTurns(t *testing.T) {
+	b, err := NewLeakyBucket(20, 1)
+	if err != nil {
+		t.Fatalf("NewLeakyBucket() error = %v", err)
+	}
+	if !b.Allow() {
+		t.Fatalf("Allow() = false; want true")
+	}
+
+	// the operation reserves the only waiting turn, then gives up since its context is done
+	ctx, cancel := context.WithCancel(context.Background())
+	cancel()
+	if err := b.Wait(ctx); err == nil || err.Type != "ContextError" {
+		t.Fatalf("Wait() error = %v; want ContextError", err)
+	}
+
+	// the turn was given back: the next operation waits for it instead of being rejected
+	if err := b.Wait(context.Background()); err != nil {
+		t.Fatalf("Wait() error = %v; want the refunded turn", err)
+	}
+}
+
+func TestLeakyBucketDoesNotShareTurnsOfCanceledWaiters(t *testing.T) {
+	b, err := NewLeakyBucket(20, 5)
+	if err != nil {
+		t.Fatalf("NewLeakyBucket() error = %v", err)
+	}
+	if !b.Allow() {
+		t.Fatalf("Allow() = false; want true")
+	}
+
+	// reserve returns the turn reserved by a new waiter, and the result of its Wait
+	reserve := func(ctx context.Context) (time.Time, <-chan Error) {
+		b.mutex.Lock()
+		reserved := b.next
+		b.mutex.Unlock()
+		waited := make(chan Error, 1)
+		go func() {
+			waited <- b.Wait(ctx)
+		}()
+		for {
+			b.mutex.Lock()
+			next := b.next
+			b.mutex.Unlock()
+			if next.After(reserved) {
+				return next.Add(-b.interval), waited
+			}
+			time.Sleep(time.Millisecond)
+		}
+	}
+
+	// waiters a, b and c reserve consecutive turns, then b gives up
+	slotA, waitedA := reserve(context.Background())
+	ctx, cancel := context.WithCancel(context.Background())
+	_, waitedB := reserve(ctx)
+	slotC, waitedC := reserve(context.Background())
+	cancel()
+	if err := <-waitedB; err == nil || err.Type != "ContextError" {
+		t.Fatalf("Wait() error = %v; want ContextError", err)
+	}
+
+	// the turn of b cannot be given back without giving the turn of c twice
+	slotD, waitedD := reserve(context.Background())
+	if slotD.Equal(slotA) || slotD.Equal(slotC) {
+		t.Fatalf("turn %s reserved twice", slotD)
+	}
+	if !slotD.After(slotC) {
+		t.Fatalf("turn of d = %s; want after the turn of c %s", slotD, slotC)
+	}
+	for _, waited := range []<-chan Error{waitedA, waitedC, waitedD} {
+		if err := <-waited; err != nil {
+			t.Fatalf("Wait() error = %v", err)
+		}
+	}
+}
+
+func TestTokenBucketRefundsCanceledTokens(t *testing.T) {
+	// the bucket refills one token an hour: tokens only change when operations reserve or give back tokens
+	b, err := NewTokenBucket(1.0/3600, 1)
+	if err != nil {
+		t.Fatalf("NewTokenBucket() error = %v", err)
+	}
+	if !b.Allow() {
+		t.Fatalf("Allow() = false; want true")
+	}
+	tokens := func() float64 {
+		b.mutex.Lock()
+		defer b.mutex.Unlock()
+		return math.Round(b.tokens)
+	}
+
+	// waiters a, b and c reserve the next 3 tokens, then b gives up
+	ctx, cancel := context.WithCancel(context.Background())
+	defer cancel()
+	ctxB, cancelB := context.WithCancel(ctx)
+	waited := make(chan Error, 3)
+	for i, ctx := range []context.Context{ctx, ctxB, ctx} {
+		go func(ctx context.Context) {
+			waited <- b.Wait(ctx)
+		}(ctx)
+		for tokens() > float64(-i-1) {
+			time.Sleep(time.Millisecond)
+		}
+	}
+	cancelB()
+	if err := <-waited; err == nil || err.Type != "ContextError" {
+		t.Fatalf("Wait() error = %v; want ContextError", err)
+	}
+
+	// the token of b is given back once. Tokens are interchangeable: the next operation may take the token of b,
+	// without letting more operations through than the rate allows.
+	if got := tokens(); got != -2 {
+		t.Fatalf("tokens = %v; want -2", got)
+	}
+}
+
+func TestRateLimiterStrategyMiddlewareStopsWhenContextIsDone(t *testing.T) {
+	b, err := NewTokenBucket(1.0/3600, 1)
+	if err != nil {
+		t.Fatalf("NewTokenBucket() error = %v", err)
+	}
+	ctx, cancel := context.WithCancel(context.Background())
+	r := &fakeRuntime{name: "receptor", ctx: ctx}
+	s := ChainStrategy(DefaultStrategy(), RateLimiterStrategyMiddleware(b))
+
+	if err := s.Run(r); err != nil {
+		t.Fatalf("Run() error = %v", err)
+	}
+	// the bucket is empty for an hour: the next Run waits until the context is done
+	time.AfterFunc(10*time.Millisecond, cancel)
+	if err := s.Run(r); err == nil || err.Type != "ContextError" {
+		t.Fatalf("Run() error = %v; want ContextError", err)
+	}
+	if got := r.runs.Load(); got != 1 {
+		t.Fatalf("runs = %d; want 1", got)
+	}
+}
@@ -0,0 +1,472 @@
+/*
+Copyright 2023 Alexandre Mahdhaoui
+
+Licensed under the Apache License, Version 2.0 (the "License");
+you may not use this file except in compliance with the License.
+You may obtain a copy of the License at
+
+	http://www.apache.org/licenses/LICENSE-2.0
+
+Unless required by applicable law or agreed to in writing, software
+distributed under the License is distributed on an "AS IS" BASIS,
+WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+See the License for the specific language governing permissions and
+limitations under the License.
+*/
+
+package bda
+
+import (
+	"context"
+	"fmt"
+	"strconv"
+	"strings"
+	"sync"
+	"time"
+)
+
+//----------------------------------------------------------------------------------------------------------------------
+//- Schedule
+
+// Schedule computes the activation times of a scheduled WorkerPoolStrategy.
+type Schedule interface {
+	// Next method returns the first activation time strictly after t.
+	Next(t time.Time) time.Time
+}
+
+type intervalSchedule struct {
+	Interval time.Duration
+}
+
+func (s *intervalSchedule) Next(t time.Time) time.Time {
+	return t.Add(s.Interval)
+}
+
+// EverySchedule returns a Schedule activating every interval. Intervals shorter than a millisecond are rounded up.
+func EverySchedule(interval time.Duration) Schedule {
+	if interval < time.Millisecond {
+		interval = time.Millisecond
+	}
+	return &intervalSchedule{Interval: interval}
+}
+
+//----------------------------------------------------------------------------------------------------------------------
+//- Cron
+
+// cronMaxLookahead bounds the search of the next activation of a cron expression that never matches, e.g. "0 0 30 2 *".
+const cronMaxLookahead = 5 * 366 * 24 * time.Hour
+
+// cronField describes the range and the names of a field of a cron expression.
+type cronField struct {
+	Name  string
+	Min   uint
+	Max   uint
+	Names map[string]uint
+}
+
+var (
+	cronMinute     = cronField{Name: "minute", Min: 0, Max: 59}
+	cronHour       = cronField{Name: "hour", Min: 0, Max: 23}
+	cronDayOfMonth = cronField{Name: "day of month", Min: 1, Max: 31}
+	cronMonth      = cronField{Name: "month", Min: 1, Max: 12, Names: map[string]uint{
+		"jan": 1, "feb": 2, "mar": 3, "apr": 4, "may": 5, "jun": 6,
+		"jul": 7, "aug": 8, "sep": 9, "oct": 10, "nov": 11, "dec": 12,
+	}}
+	// day of week accepts 7 for Sunday, folded into 0 once parsed.
+	cronDayOfWeek = cronField{Name: "day of week", Min: 0, Max: 7, Names: map[string]uint{
+		"sun": 0, "mon": 1, "tue": 2, "wed": 3, "thu": 4, "fri": 5, "sat": 6,
+	}}
+
+	cronMacros = map[string]string{
+		"@yearly":   "0 0 1 1 *",
+		"@annually": "0 0 1 1 *",
+		"@monthly":  "0 0 1 * *",
+		"@weekly":   "0 0 * * 0",
+		"@daily":    "0 0 * * *",
+		"@midnight": "0 0 * * *",
+		"@hourly":   "0 * * * *",
+	}
+)
+
+// CronSchedule is a Schedule parsed from a standard 5-field cron expression (see ParseCron).
+type CronSchedule struct {
+	Spec     string
+	Location *time.Location
+
+	minute, hour, dayOfMonth, month, dayOfWeek uint64
+	// dayOfMonthAny and dayOfWeekAny are true if the field is "*". When both day fields are restricted, a day matches
+	// if either field matches, as in standard cron.
+	dayOfMonthAny, dayOfWeekAny bool
+}
+
+// Next method returns the first minute strictly after t matching the expression, in the Location of the schedule.
+// Returns the zero time if the expression never matches. Wall clock times skipped by a DST transition never match,
+// and wall clock times repeated by a DST transition match twice.
+func (s *CronSchedule) Next(t time.Time) time.Time {
+	t = t.In(s.Location).Truncate(time.Minute).Add(time.Minute)
+	limit := t.Add(cronMaxLookahead)
+
+	for t.Before(limit) {
+		var next time.Time
+		switch {
+		case s.month&(1<<uint(t.Month())) == 0:
+			next = time.Date(t.Year(), t.Month()+1, 1, 0, 0, 0, 0, s.Location)
+		case !s.matchDay(t):
+			next = time.Date(t.Year(), t.Month(), t.Day()+1, 0, 0, 0, 0, s.Location)
+		case s.hour&(1<<uint(t.Hour())) == 0:
+			// step in elapsed time: the next hour may not exist on the wall clock, e.g. 02:00 on a spring-forward day
+			next = t.Add(time.Duration(60-t.Minute()) * time.Minute)
+		case s.minute&(1<<uint(t.Minute())) == 0:
+			next = t.Add(time.Minute)
+		default:
+			return t
+		}
+		// time.Date may normalize a wall clock time falling in a DST gap backward; always move forward.
+		if !next.After(t) {
+			next = t.Add(time.Minute)
+		}
+		t = next
+	}
+	return time.Time{}
+}
+
+func (s *CronSchedule) matchDay(t time.Time) bool {
+	dayOfMonth := s.dayOfMonth&(1<<uint(t.Day())) != 0
+	dayOfWeek := s.dayOfWeek&(1<<uint(t.Weekday())) != 0
+	switch {
+	case s.dayOfMonthAny && s.dayOfWeekAny:
+		return true
+	case s.dayOfMonthAny:
+		return dayOfWeek
+	case s.dayOfWeekAny:
+		return dayOfMonth
+	default:
+		return dayOfMonth || dayOfWeek
+	}
+}
+
+// ParseCron parses a standard 5-field cron expression: minute, hour, day of month, month and day of week. Fields
+// support "*", values, names ("jan", "mon"), ranges ("1-5"), steps ("*/15", "0-30/10") and lists ("1,15").
+// Macros such as "@daily" and "@hourly" are supported. The expression is evaluated in the local time zone, unless it
+// is prefixed with "CRON_TZ=<zone> " or "TZ=<zone> ", e.g. "CRON_TZ=Europe/Paris 0 9 * * mon-fri".
+func ParseCron(spec string) (*CronSchedule, Error) {
+	s := &CronSchedule{Spec: spec, Location: time.Local}
+
+	expr := strings.TrimSpace(spec)
+	if strings.HasPrefix(expr, "CRON_TZ=") || strings.HasPrefix(expr, "TZ=") {
+		zone, rest, _ := strings.Cut(expr, " ")
+		_, name, _ := strings.Cut(zone, "=")
+		location, err := time.LoadLocation(name)
+		if err != nil {
+			return nil, NewError("CronParseError", fmt.Sprintf("invalid time zone in %q; %v", spec, err), nil)
+		}
+		s.Location = location
+		expr = strings.TrimSpace(rest)
+	}
+	if macro, ok := cronMacros[strings.ToLower(expr)]; ok {
+		expr = macro
+	}
+
+	fields := strings.Fields(expr)
+	if len(fields) != 5 {
+		return nil, NewError("CronParseError", fmt.Sprintf("cron expression should have 5 fields; got: %d in %q", len(fields), spec), nil)
+	}
+
+	var err Error
+	if s.minute, err = parseCronField(fields[0], cronMinute); err != nil {
+		return nil, err
+	}
+	if s.hour, err = parseCronField(fields[1], cronHour); err != nil {
+		return nil, err
+	}
+	if s.dayOfMonth, err = parseCronField(fields[2], cronDayOfMonth); err != nil {
+		return nil, err
+	}
+	if s.month, err = parseCronField(fields[3], cronMonth); err != nil {
+		return nil, err
+	}
+	if s.dayOfWeek, err = parseCronField(fields[4], cronDayOfWeek); err != nil {
+		return nil, err
+	}
+	if s.dayOfWeek&(1<<7) != 0 {
+		s.dayOfWeek = s.dayOfWeek&^(1<<7) | 1
+	}
+	s.dayOfMonthAny = fields[2] == "*"
+	s.dayOfWeekAny = fields[4] == "*"
+	return s, nil
+}
+
+// parseCronField parses a field of a cron expression into a bitset of the matching values.
+func parseCronField(field string, f cronField) (uint64, Error) {
+	var bits uint64
+	for _, part := range strings.Split(field, ",") {
+		rangePart, stepPart, hasStep := strings.Cut(part, "/")
+
+		step := uint(1)
+		if hasStep {
+			n, err := strconv.ParseUint(stepPart, 10, 8)
+			if err != nil || n == 0 {
+				return 0, NewError("CronParseError", fmt.Sprintf("invalid step %q in %s field %q", stepPart, f.Name, field), nil)
+			}
+			step = uint(n)
+		}
+
+		var start, end uint
+		switch {
+		case rangePart == "*":
+			start, end = f.Min, f.Max
+		case strings.Contains(rangePart, "-"):
+			low, high, _ := strings.Cut(rangePart, "-")
+			var err Error
+			if start, err = parseCronValue(low, f); err != nil {
+				return 0, err
+			}
+			if end, err = parseCronValue(high, f); err != nil {
+				return 0, err
+			}
+			if start > end {
+				return 0, NewError("CronParseError", fmt.Sprintf("invalid range %q in %s field %q", rangePart, f.Name, field), nil)
+			}
+		default:
+			var err Error
+			if start, err = parseCronValue(rangePart, f); err != nil {
+				return 0, err
+			}
+			end = start
+			// "a/n" means every n from a to the end of the range
+			if hasStep {
+				end = f.Max
+			}
+		}
+
+		for v := start; v <= end; v += step {
+			bits |= 1 << v
+		}
+	}
+	return bits, nil
+}
+
+func parseCronValue(value string, f cronField) (uint, Error) {
+	if n, ok := f.Names[strings.ToLower(value)]; ok {
+		return n, nil
+	}
+	n, err := strconv.ParseUint(value, 10, 8)
+	if err != nil || uint(n) < f.Min || uint(n) > f.Max {
+		return 0, NewError("CronParseError", fmt.Sprintf("invalid %s %q; want a value between %d and %d", f.Name, value, f.Min, f.Max), nil)
+	}
+	return uint(n), nil
+}
+
+//----------------------------------------------------------------------------------------------------------------------
+//- Scheduled WorkerPoolStrategy
+
+// OverlapPolicy decides what happens when an activation is due while the previous run of the worker is in progress.
+type OverlapPolicy int
+
+const (
+	// OverlapSkip skips activations due while the worker is running.
+	OverlapSkip OverlapPolicy = iota
+	// OverlapQueue runs activations due while the worker is running once it is done, one after the other.
+	OverlapQueue
+	// OverlapAllow runs activations concurrently with the runs in progress.
+	OverlapAllow
+)
+
+// ScheduleOption configures a scheduled WorkerPoolStrategy.
+type ScheduleOption func(*scheduleOptions)
+
+type scheduleOptions struct {
+	Overlap OverlapPolicy
+	CatchUp int
+}
+
+// WithOverlapPolicy sets the OverlapPolicy of a scheduled WorkerPoolStrategy. Defaults to OverlapSkip.
+func WithOverlapPolicy(policy OverlapPolicy) ScheduleOption {
+	return func(o *scheduleOptions) {
+		o.Overlap = policy
+	}
+}
+
+// WithCatchUp sets the maximum number of missed activations run when the scheduler wakes up late, e.g. after the
+// process was suspended. Missed activations run one after the other, whatever the OverlapPolicy. Defaults to 0:
+// missed activations are skipped and only one run happens.
+func WithCatchUp(n int) ScheduleOption {
+	return func(o *scheduleOptions) {
+		o.CatchUp = n
+	}
+}
+
+func newScheduleOptions(opts []ScheduleOption) *scheduleOptions {
+	o := &scheduleOptions{Overlap: OverlapSkip}
+	for _, opt := range opts {
+		opt(o)
+	}
+	if o.CatchUp < 0 {
+		o.CatchUp = 0
+	}
+	return o
+}
+
+// WorkerPoolStrategySchedule returns a WorkerPoolStrategy running each worker once at every activation of the
+// Schedule, until the context of the pool is done.
+func WorkerPoolStrategySchedule(schedule Schedule, opts ...ScheduleOption) WorkerPoolStrategy {
+	o := newScheduleOptions(opts)
+	return WorkerPoolStrategyFunc(func(ctx context.Context, h WorkerHandle) Error {
+		p, i := h.Pool(), h.Index()
+		LogDebugf(p, LogOperationRun, LogStatusProgress, "starting scheduled loop for worker-%d", i)
+
+		s := &scheduledWorker{handle: h, ctx: ctx, overlap: o.Overlap, inflight: &sync.WaitGroup{}, mutex: &sync.Mutex{}}
+		defer s.inflight.Wait()
+
+		next := schedule.Next(time.Now())
+		for !next.IsZero() {
+			timer := time.NewTimer(time.Until(next))
+			select {
+			case <-ctx.Done():
+				timer.Stop()
+				return nil
+			case <-timer.C:
+			}
+
+			var due int
+			var skipped bool
+			next, due, skipped = nextActivation(schedule, next, time.Now(), o.CatchUp)
+			if skipped {
+				LogWarnf(p, LogOperationRun, LogStatusProgress, "skipping missed activations of worker-%d beyond the catch-up limit of %d", i, o.CatchUp)
+			}
+			s.activate(due)
+		}
+		LogWarnf(p, LogOperationRun, LogStatusProgress, "schedule of worker-%d has no next activation", i)
+		return nil
+	})
+}
+
+// nextActivation returns the first activation of schedule after now, given that the activation at last is due. It
+// also returns the number of activations due, i.e. last and the activations missed until now up to catchUp, and
+// whether missed activations were skipped.
+func nextActivation(schedule Schedule, last, now time.Time, catchUp int) (time.Time, int, bool) {
+	// fixed intervals are computed arithmetically: a long suspension may have missed millions of activations.
+	if s, ok := schedule.(*intervalSchedule); ok {
+		missed := int64(0)
+		if now.After(last) {
+			missed = int64(now.Sub(last) / s.Interval)
+		}
+		next := last.Add(time.Duration(missed+1) * s.Interval)
+		if missed > int64(catchUp) {
+			return next, catchUp + 1, true
+		}
+		return next, int(missed) + 1, false
+	}
+
+	due := 1
+	next := schedule.Next(last)
+	for ; !next.IsZero() && !next.After(now); next = schedule.Next(next) {
+		if due > catchUp {
+			// stop counting and jump past now
+			return schedule.Next(now), due, true
+		}
+		due++
+	}
+	return next, due, false
+}
+
+// WorkerPoolStrategyInterval returns a WorkerPoolStrategy running each worker every interval, at a fixed rate.
+func WorkerPoolStrategyInterval(interval time.Duration, opts ...ScheduleOption) WorkerPoolStrategy {
+	return WorkerPoolStrategySchedule(EverySchedule(interval), opts...)
+}
+
+// WorkerPoolStrategyCron returns a WorkerPoolStrategy running each worker at the activations of a cron
+// expression (see ParseCron).
+func WorkerPoolStrategyCron(spec string, opts ...ScheduleOption) (WorkerPoolStrategy, Error) {
+	schedule, err := ParseCron(spec)
+	if err != nil {
+		return nil, err
+	}
+	return WorkerPoolStrategySchedule(schedule, opts...), nil
+}
+
+// WorkerPoolStrategyFixedDelay returns a WorkerPoolStrategy running each worker repeatedly, waiting delay between
+// the end of a run and the start of the next one. Runs never overlap. Delays shorter than a millisecond are rounded up.
+func WorkerPoolStrategyFixedDelay(delay time.Duration) WorkerPoolStrategy {
+	if delay < time.Millisecond {
+		delay = time.Millisecond
+	}
+	return WorkerPoolStrategyFunc(func(ctx context.Context, h WorkerHandle) Error {
+		LogDebugf(h.Pool(), LogOperationRun, LogStatusProgress, "starting fixed delay loop for worker-%d", h.Index())
+
+		for ctx.Err() == nil {
+			h.Report(h.RunOnce())
+
+			timer := time.NewTimer(delay)
+			select {
+			case <-ctx.Done():
+				timer.Stop()
+			case <-timer.C:
+			}
+		}
+		return nil
+	})
+}
+
+// scheduledWorker runs a worker of a pool on activation, according to an OverlapPolicy.
+type scheduledWorker struct {
+	handle  WorkerHandle
+	ctx     context.Context
+	overlap OverlapPolicy
+
+	running  bool
+	pending  int
+	inflight *sync.WaitGroup
+	mutex    *sync.Mutex
+}
+
+// activate runs the worker for n activations due at once. The activations run one after the other, whatever the
+// OverlapPolicy: the policy only applies to activations due while a previous run is in progress.
+func (s *scheduledWorker) activate(n int) {
+	s.mutex.Lock()
+	defer s.mutex.Unlock()
+
+	if s.overlap == OverlapAllow {
+		s.inflight.Add(1)
+		go func() {
+			defer s.inflight.Done()
+			for ; n > 0 && s.ctx.Err() == nil; n-- {
+				s.run()
+			}
+		}()
+		return
+	}
+
+	if s.running {
+		if s.overlap == OverlapQueue {
+			s.pending += n
+			return
+		}
+		LogDebugf(s.handle.Pool(), LogOperationRun, LogStatusProgress, "worker-%d is still running; skipping %d activation(s)", s.handle.Index(), n)
+		return
+	}
+
+	s.running = true
+	s.pending = n - 1
+	s.inflight.Add(1)
+	go func() {
+		defer s.inflight.Done()
+		for {
+			s.run()
+
+			s.mutex.Lock()
+			if s.pending <= 0 || s.ctx.Err() != nil {
+				s.running, s.pending = false, 0
+				s.mutex.Unlock()
+				return
+			}
+			s.pending--
+			s.mutex.Unlock()
+		}
+	}()
+}
+
+// run runs the worker once, spawning it if needed.
+func (s *scheduledWorker) run() {
+	s.handle.Report(s.handle.RunOnce())
+}
@@ -0,0 +1,229 @@
+/*
+Copyright 2023 Alexandre Mahdhaoui
+
+Licensed under the Apache License, Version 2.0 (the "License");
+you may not use this file except in compliance with the License.
+You may obtain a copy of the License at
+
+	http://www.apache.org/licenses/LICENSE-2.0
+
+Unless required by applicable law or agreed to in writing, software
+distributed under the License is distributed on an "AS IS" BASIS,
+WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+See the License for the specific language governing permissions and
+limitations under the License.
+*/
+
+package bda
+
+import (
+	"context"
+	"sync"
+	"sync/atomic"
+	"testing"
+	"time"
+)
+
+func TestCronScheduleNextAcrossDST(t *testing.T) {
+	newYork, err := time.LoadLocation("America/New_York")
+	if err != nil {
+		t.Skipf("time zone database unavailable: %v", err)
+	}
+
+	for _, tc := range []struct {
+		name  string
+		spec  string
+		from  time.Time
+		wants []time.Time
+	}{
+		{
+			name: "spring forward daily",
+			spec: "CRON_TZ=America/New_York 0 9 * * *",
+			from: time.Date(2024, 3, 9, 12, 0, 0, 0, newYork),
+			wants: []time.Time{
+				time.Date(2024, 3, 10, 9, 0, 0, 0, newYork),
+				time.Date(2024, 3, 11, 9, 0, 0, 0, newYork),
+			},
+		},
+		{
+			name: "spring forward in the gap",
+			spec: "CRON_TZ=America/New_York 30 2 * * *",
+			from: time.Date(2024, 3, 9, 12, 0, 0, 0, newYork),
+			// 02:30 does not exist on 2024-03-10
+			wants: []time.Time{
+				time.Date(2024, 3, 11, 2, 30, 0, 0, newYork),
+			},
+		},
+		{
+			name: "spring forward hourly",
+			spec: "CRON_TZ=America/New_York 0 * * * *",
+			from: time.Date(2024, 3, 10, 0, 30, 0, 0, newYork),
+			wants: []time.Time{
+				time.Date(2024, 3, 10, 1, 0, 0, 0, newYork),
+				time.Date(2024, 3, 10, 3, 0, 0, 0, newYork),
+			},
+		},
+		{
+			name: "fall back daily",
+			spec: "CRON_TZ=America/New_York 0 9 * * *",
+			from: time.Date(2024, 11, 2, 12, 0, 0, 0, newYork),
+			wants: []time.Time{
+				time.Date(2024, 11, 3, 9, 0, 0, 0, newYork),
+				time.Date(2024, 11, 4, 9, 0, 0, 0, newYork),
+			},
+		},
+		{
+			name: "fall back in the repeated hour",
+			spec: "CRON_TZ=America/New_York 30 1 * * *",
+			from: time.Date(2024, 11, 3, 0, 0, 0, 0, newYork),
+			// 01:30 happens twice on 2024-11-03: in EDT, then in EST
+			wants: []time.Time{
+				time.Date(2024, 11, 3, 5, 30, 0, 0, time.UTC),
+				time.Date(2024, 11, 3, 6, 30, 0, 0, time.UTC),
+				time.Date(2024, 11, 4, 1, 30, 0, 0, newYork),
+			},
+		},
+	} {
+		t.Run(tc.name, func(t *testing.T) {
+			s, err := ParseCron(tc.spec)
+			if err != nil {
+				t.Fatalf("ParseCron(%q) error = %v", tc.spec, err)
+			}
+
+			next := tc.from
+			for _, want := range tc.wants {
+				next = s.Next(next)
+				if !next.Equal(want) {
+					t.Fatalf("Next() = %v; want %v", next, want.In(newYork))
+				}
+			}
+		})
+	}
+}
+
+func TestNextActivation(t *testing.T) {
+	last := time.Date(2024, 1, 1, 0, 0, 0, 0, time.UTC)
+
+	for _, tc := range []struct {
+		name        string
+		schedule    Schedule
+		now         time.Time
+		catchUp     int
+		wantNext    time.Time
+		wantDue     int
+		wantSkipped bool
+	}{
+		{
+			name:     "interval on time",
+			schedule: EverySchedule(time.Minute),
+			now:      last,
+			wantNext: last.Add(time.Minute),
+			wantDue:  1,
+		},
+		{
+			name:     "interval catching up",
+			schedule: EverySchedule(time.Minute),
+			now:      last.Add(2*time.Minute + time.Second),
+			catchUp:  5,
+			wantNext: last.Add(3 * time.Minute),
+			wantDue:  3,
+		},
+		{
+			name:        "interval after a long suspension",
+			schedule:    EverySchedule(time.Millisecond),
+			now:         last.Add(24 * time.Hour),
+			catchUp:     2,
+			wantNext:    last.Add(24*time.Hour + time.Millisecond),
+			wantDue:     3,
+			wantSkipped: true,
+		},
+		{
+			name:     "cron catching up",
+			schedule: mustParseCron(t, "CRON_TZ=UTC * * * * *"),
+			now:      last.Add(2*time.Minute + time.Second),
+			catchUp:  5,
+			wantNext: last.Add(3 * time.Minute),
+			wantDue:  3,
+		},
+		{
+			name:        "cron after a long suspension",
+			schedule:    mustParseCron(t, "CRON_TZ=UTC * * * * *"),
+			now:         last.Add(30 * 24 * time.Hour),
+			catchUp:     1,
+			wantNext:    last.Add(30*24*time.Hour + time.Minute),
+			wantDue:     2,
+			wantSkipped: true,
+		},
+	} {
+		t.Run(tc.name, func(t *testing.T) {
+			next, due, skipped := nextActivation(tc.schedule, last, tc.now, tc.catchUp)
+			if !next.Equal(tc.wantNext) || due != tc.wantDue || skipped != tc.wantSkipped {
+				t.Fatalf("nextActivation() = %v, %d, %t; want %v, %d, %t", next, due, skipped, tc.wantNext, tc.wantDue, tc.wantSkipped)
+			}
+		})
+	}
+}
+
+func TestScheduledWorkerRunsCatchUpActivations(t *testing.T) {
+	for _, overlap := range []OverlapPolicy{OverlapSkip, OverlapQueue, OverlapAllow} {
+		h := &countingWorkerHandle{pool: &WorkerPool{Name: "test"}}
+		s := &scheduledWorker{handle: h, ctx: context.Background(), overlap: overlap, inflight: &sync.WaitGroup{}, mutex: &sync.Mutex{}}
+
+		s.activate(3)
+		s.inflight.Wait()
+		if got := h.runs.Load(); got != 3 {
+			t.Fatalf("overlap policy %d: runs = %d; want 3", overlap, got)
+		}
+	}
+}
+
+func TestWorkerPoolStrategyFixedDelayDoesNotRunOnceDone(t *testing.T) {
+	ctx, cancel := context.WithCancel(context.Background())
+	cancel()
+	h := &countingWorkerHandle{pool: &WorkerPool{Name: "test"}}
+
+	if err := WorkerPoolStrategyFixedDelay(time.Hour).Run(ctx, h); err != nil {
+		t.Fatalf("Run() error = %v", err)
+	}
+	if got := h.runs.Load(); got != 0 {
+		t.Fatalf("runs = %d; want 0 once the context is done", got)
+	}
+}
+
+func TestWorkerPoolStrategyFixedDelayRoundsUpNonPositiveDelays(t *testing.T) {
+	for _, delay := range []time.Duration{0, -time.Second} {
+		ctx, cancel := context.WithTimeout(context.Background(), 20*time.Millisecond)
+		h := &countingWorkerHandle{pool: &WorkerPool{Name: "test"}}
+
+		if err := WorkerPoolStrategyFixedDelay(delay).Run(ctx, h); err != nil {
+			t.Fatalf("delay %s: Run() error = %v", delay, err)
+		}
+		cancel()
+		// a millisecond delay allows about 20 runs, a busy loop would run many more
+		if got := h.runs.Load(); got < 1 || got > 100 {
+			t.Fatalf("delay %s: runs = %d; want between 1 and 100", delay, got)
+		}
+	}
+}
+
+// countingWorkerHandle is a WorkerHandle counting the runs of its worker.
+type countingWorkerHandle struct {
+	pool *WorkerPool
+	runs atomic.Int64
+}
+
+func (h *countingWorkerHandle) Index() int                { return 0 }
+func (h *countingWorkerHandle) Pool() *WorkerPool         { return h.pool }
+func (h *countingWorkerHandle) Worker() (*Worker, Error)  { return nil, nil }
+func (h *countingWorkerHandle) Respawn() (*Worker, Error) { return nil, nil }
+func (h *countingWorkerHandle) Report(Error)              {}
+func (h *countingWorkerHandle) RunOnce() Error            { h.runs.Add(1); return nil }
+
+func mustParseCron(t *testing.T, spec string) *CronSchedule {
+	t.Helper()
+	s, err := ParseCron(spec)
+	if err != nil {
+		t.Fatalf("ParseCron(%q) error = %v", spec, err)
+	}
+	return s
+}
@@ -0,0 +1,451 @@
+/*
+Copyright 2023 Alexandre Mahdhaoui
+
+Licensed under the Apache License, Version 2.0 (the "License");
+you may not use this file except in compliance with the License.
+You may obtain a copy of the License at
+
+	http://www.apache.org/licenses/LICENSE-2.0
+
+Unless required by applicable law or agreed to in writing, software
+distributed under the License is distributed on an "AS IS" BASIS,
+WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+See the License for the specific language governing permissions and
+limitations under the License.
+*/
+
+package bda
+
+import (
+	"bufio"
+	"bytes"
+	"context"
+	"encoding/gob"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"io"
+	"io/fs"
+	"os"
+	"path/filepath"
+	"sync"
+	"time"
+)
+
+const (
+	// ErrorTypeSnapshot is the type of the Errors returned when snapshots cannot be saved or restored, or when a
+	// Snapshotter is misconfigured.
+	ErrorTypeSnapshot ErrorType = "SnapshotError"
+
+	// SnapshotFormatVersion is the version of the snapshot format written by this package.
+	SnapshotFormatVersion = 1
+
+	snapshotFileExtension = ".snapshot"
+
+	snapshotKindMap       = "map"
+	snapshotKindSet       = "set"
+	snapshotKindSafeArray = "safe-array"
+)
+
+//----------------------------------------------------------------------------------------------------------------------
+//- Codec
+
+// Codec encodes and decodes the payload of snapshots.
+type Codec interface {
+	// Name method returns the name of the codec, recorded in snapshots so they are decoded with the same codec.
+	Name() string
+	Encode(w io.Writer, v any) error
+	Decode(r io.Reader, v any) error
+}
+
+type jsonCodec struct{}
+
+func (c jsonCodec) Name() string {
+	return "json"
+}
+
+func (c jsonCodec) Encode(w io.Writer, v any) error {
+	return json.NewEncoder(w).Encode(v)
+}
+
+func (c jsonCodec) Decode(r io.Reader, v any) error {
+	return json.NewDecoder(r).Decode(v)
+}
+
+// JSONCodec returns a Codec encoding snapshots as JSON. Keys and values must be JSON-serializable.
+func JSONCodec() Codec {
+	return jsonCodec{}
+}
+
+type gobCodec struct{}
+
+func (c gobCodec) Name() string {
+	return "gob"
+}
+
+func (c gobCodec) Encode(w io.Writer, v any) error {
+	return gob.NewEncoder(w).Encode(v)
+}
+
+func (c gobCodec) Decode(r io.Reader, v any) error {
+	return gob.NewDecoder(r).Decode(v)
+}
+
+// GobCodec returns a Codec encoding snapshots with encoding/gob. Concrete types stored behind interfaces must be
+// registered with gob.Register.
+func GobCodec() Codec {
+	return gobCodec{}
+}
+
+//----------------------------------------------------------------------------------------------------------------------
+//- SnapshotTarget
+
+// snapshotHeader is written as a JSON line before the payload of a snapshot.
+type snapshotHeader struct {
+	Version   int       `json:"version"`
+	Kind      string    `json:"kind"`
+	Codec     string    `json:"codec"`
+	CreatedAt time.Time `json:"createdAt"`
+}
+
+// SnapshotTarget is a shared data structure that can be saved and restored.
+type SnapshotTarget interface {
+	// GetName method returns the name of the target, used as the name of its snapshot file
+	GetName() string
+	// Save method writes a snapshot of the data structure
+	Save(w io.Writer, codec Codec) error
+	// Load method replaces the content of the data structure with a snapshot
+	Load(r io.Reader, codec Codec) error
+}
+
+type snapshotEntry[T comparable, I any] struct {
+	Key   T
+	Value I
+}
+
+type snapshotTarget struct {
+	name string
+	kind string
+	save func(w io.Writer, codec Codec) error
+	load func(r io.Reader, codec Codec) error
+}
+
+func (t *snapshotTarget) GetName() string {
+	return t.name
+}
+
+func (t *snapshotTarget) Save(w io.Writer, codec Codec) error {
+	header, err := json.Marshal(snapshotHeader{
+		Version:   SnapshotFormatVersion,
+		Kind:      t.kind,
+		Codec:     codec.Name(),
+		CreatedAt: time.Now().UTC(),
+	})
+	if err != nil {
+		return err
+	}
+	if _, err := w.Write(append(header, '\n')); err != nil {
+		return err
+	}
+	return t.save(w, codec)
+}
+
+func (t *snapshotTarget) Load(r io.Reader, codec Codec) error {
+	reader := bufio.NewReader(r)
+	line, err := reader.ReadBytes('\n')
+	if err != nil {
+		return fmt.Errorf("cannot read snapshot header of %s; %w", t.name, err)
+	}
+
+	var header snapshotHeader
+	if err := json.Unmarshal(line, &header); err != nil {
+		return fmt.Errorf("cannot decode snapshot header of %s; %w", t.name, err)
+	}
+	switch {
+	case header.Version > SnapshotFormatVersion:
+		return fmt.Errorf("unsupported snapshot version for %s; got: %d; want: <= %d", t.name, header.Version, SnapshotFormatVersion)
+	case header.Kind != t.kind:
+		return fmt.Errorf("unexpected snapshot kind for %s; got: %s; want: %s", t.name, header.Kind, t.kind)
+	case header.Codec != codec.Name():
+		return fmt.Errorf("unexpected snapshot codec for %s; got: %s; want: %s", t.name, header.Codec, codec.Name())
+	}
+	return t.load(reader, codec)
+}
+
+// MapSnapshotTarget returns a SnapshotTarget for a Map. Loading a snapshot replaces the content of the Map, and
+// should happen before the Map is shared.
+func MapSnapshotTarget[T comparable, I any](name string, m Map[T, I]) SnapshotTarget {
+	return &snapshotTarget{
+		name: name,
+		kind: snapshotKindMap,
+		save: func(w io.Writer, codec Codec) error {
+			entries := make([]snapshotEntry[T, I], 0, m.Len())
+			m.Range(func(key T, value I) bool {
+				entries = append(entries, snapshotEntry[T, I]{Key: key, Value: value})
+				return true
+			})
+			return codec.Encode(w, entries)
+		},
+		load: func(r io.Reader, codec Codec) error {
+			entries := make([]snapshotEntry[T, I], 0)
+			if err := codec.Decode(r, &entries); err != nil {
+				return err
+			}
+			m.Range(func(key T, _ I) bool {
+				m.Delete(key)
+				return true
+			})
+			for _, entry := range entries {
+				m.Set(entry.Key, entry.Value)
+			}
+			return nil
+		},
+	}
+}
+
+// SetSnapshotTarget returns a SnapshotTarget for a Set. Loading a snapshot replaces the content of the Set, and
+// should happen before the Set is shared.
+func SetSnapshotTarget[T comparable](name string, s Set[T]) SnapshotTarget {
+	return &snapshotTarget{
+		name: name,
+		kind: snapshotKindSet,
+		save: func(w io.Writer, codec Codec) error {
+			return codec.Encode(w, s.Values())
+		},
+		load: func(r io.Reader, codec Codec) error {
+			keys := make([]T, 0)
+			if err := codec.Decode(r, &keys); err != nil {
+				return err
+			}
+			s.Range(func(key T) bool {
+				s.Remove(key)
+				return true
+			})
+			for _, key := range keys {
+				s.Set(key)
+			}
+			return nil
+		},
+	}
+}
+
+// SafeArraySnapshotTarget returns a SnapshotTarget for a SafeArray. Loading a snapshot replaces the content of the
+// SafeArray, and should happen before the SafeArray is shared.
+func SafeArraySnapshotTarget[T any](name string, a SafeArray[T]) SnapshotTarget {
+	return &snapshotTarget{
+		name: name,
+		kind: snapshotKindSafeArray,
+		save: func(w io.Writer, codec Codec) error {
+			return codec.Encode(w, a.Snapshot())
+		},
+		load: func(r io.Reader, codec Codec) error {
+			items := make([]T, 0)
+			if err := codec.Decode(r, &items); err != nil {
+				return err
+			}
+			for a.Length() > 0 {
+				a.Remove(a.Length() - 1)
+			}
+			for _, item := range items {
+				a.Append(item)
+			}
+			return nil
+		},
+	}
+}
+
+//----------------------------------------------------------------------------------------------------------------------
+//- Snapshotter
+
+// Snapshotter persists shared data structures to disk, so stateful receptors can resume where they left off after a
+// restart. Init restores every target from its snapshot file, if any; Run saves snapshots every Interval; Stop saves
+// a final snapshot. Snapshot files are written atomically to Dir.
+//
+// A Snapshotter may be built as a struct literal: Codec defaults to JSONCodec, Targets and Context are created on
+// first use, and Run returns an Error if Interval is not positive.
+type Snapshotter struct {
+	Name     string
+	Dir      string
+	Interval time.Duration
+	Codec    Codec
+	Targets  SafeArray[SnapshotTarget]
+
+	Logger  *Logger
+	Context context.Context
+
+	stop     chan struct{}
+	stopOnce sync.Once
+	initOnce sync.Once
+}
+
+// Register adds a target to the Snapshotter.
+func (s *Snapshotter) Register(target SnapshotTarget) {
+	s.init()
+	s.Targets.Append(target)
+}
+
+// Init restores every target from its snapshot file. Targets without a snapshot file are left untouched.
+func (s *Snapshotter) Init() Error {
+	s.init()
+	LogDebug(s, LogOperationInit, LogStatusStart)
+	if err := s.RestoreAll(); err != nil {
+		return err
+	}
+	LogDebug(s, LogOperationInit, LogStatusSuccess)
+	return nil
+}
+
+// Run saves snapshots every Interval until the context is done or Stop is called.
+// A final snapshot is saved when the context is done.
+func (s *Snapshotter) Run() Error {
+	s.init()
+	LogInfof(s, LogOperationRun, LogStatusStart, "start snapshotter: %s", s.GetName())
+	if err := validateSnapshotInterval(s.Interval); err != nil {
+		return err
+	}
+	ticker := time.NewTicker(s.Interval)
+	defer ticker.Stop()
+
+	for {
+		select {
+		case <-s.Context.Done():
+			LogInfof(s, LogOperationRun, LogStatusSuccess, "received stop signal for snapshotter: %s", s.GetName())
+			return s.Stop()
+		case <-s.stop:
+			return nil
+		case <-ticker.C:
+			if err := s.SnapshotAll(); err != nil {
+				LogErrorf(s, LogOperationRun, LogStatusProgress, "%+v", *err)
+			}
+		}
+	}
+}
+
+// Stop saves a final snapshot of every target. It is idempotent.
+func (s *Snapshotter) Stop() Error {
+	s.init()
+	var err Error
+	s.stopOnce.Do(func() {
+		LogDebug(s, LogOperationStop, LogStatusStart)
+		close(s.stop)
+		err = s.SnapshotAll()
+	})
+	return err
+}
+
+// init applies the defaults of the fields left empty, so a Snapshotter built as a struct literal can be used.
+func (s *Snapshotter) init() {
+	s.initOnce.Do(func() {
+		if s.Targets == nil {
+			s.Targets = DefaultSafeArray[SnapshotTarget]()
+		}
+		if s.Context == nil {
+			s.Context = context.Background()
+		}
+		s.stop = make(chan struct{})
+	})
+}
+
+func (s *Snapshotter) codec() Codec {
+	if s.Codec == nil {
+		return JSONCodec()
+	}
+	return s.Codec
+}
+
+func (s *Snapshotter) HandleError(err Error) Error {
+	return nil
+}
+
+func (s *Snapshotter) GetName() string {
+	return s.Name
+}
+
+func (s *Snapshotter) GetType() string {
+	return "snapshotter"
+}
+
+func (s *Snapshotter) GetLogger() *Logger {
+	return s.Logger
+}
+
+// SnapshotAll saves a snapshot of every target.
+func (s *Snapshotter) SnapshotAll() Error {
+	s.init()
+	errs := DefaultSafeArray[Error]()
+	if s.Targets.Length() == 0 {
+		return nil
+	}
+	if err := os.MkdirAll(s.Dir, 0o755); err != nil {
+		return NewError(ErrorTypeSnapshot, err.Error(), nil)
+	}
+
+	s.Targets.Range(func(_ int, target SnapshotTarget) bool {
+		buf := &bytes.Buffer{}
+		if err := target.Save(buf, s.codec()); err != nil {
+			errs.Append(NewError(ErrorTypeSnapshot, fmt.Sprintf("cannot save snapshot of %s; %v", target.GetName(), err), nil))
+			return true
+		}
+		if err := writeFileAtomic(s.path(target), buf.Bytes(), 0o644); err != nil {
+			errs.Append(NewError(ErrorTypeSnapshot, fmt.Sprintf("cannot write snapshot of %s; %v", target.GetName(), err), nil))
+		}
+		return true
+	})
+	return HandleErrors(s, LogOperationRun, errs)
+}
+
+// RestoreAll restores every target from its snapshot file. Targets without a snapshot file are left untouched.
+func (s *Snapshotter) RestoreAll() Error {
+	s.init()
+	errs := DefaultSafeArray[Error]()
+	s.Targets.Range(func(_ int, target SnapshotTarget) bool {
+		f, err := os.Open(s.path(target))
+		if errors.Is(err, fs.ErrNotExist) {
+			LogDebugf(s, LogOperationInit, LogStatusProgress, "no snapshot found for %s", target.GetName())
+			return true
+		}
+		if err != nil {
+			errs.Append(NewError(ErrorTypeSnapshot, err.Error(), nil))
+			return true
+		}
+		defer f.Close()
+
+		if err := target.Load(f, s.codec()); err != nil {
+			errs.Append(NewError(ErrorTypeSnapshot, fmt.Sprintf("cannot restore snapshot of %s; %v", target.GetName(), err), nil))
+		}
+		return true
+	})
+	return HandleErrors(s, LogOperationInit, errs)
+}
+
+func (s *Snapshotter) path(target SnapshotTarget) string {
+	return filepath.Join(s.Dir, target.GetName()+snapshotFileExtension)
+}
+
+// DefaultSnapshotter returns a new Snapshotter saving JSON snapshots of its targets to dir every interval.
+// Returns an Error of type ErrorTypeSnapshot if interval is not positive.
+func DefaultSnapshotter(name, dir string, interval time.Duration, ctx context.Context, targets ...SnapshotTarget) (*Snapshotter, Error) {
+	if err := validateSnapshotInterval(interval); err != nil {
+		return nil, err
+	}
+	s := &Snapshotter{
+		Name:     name,
+		Dir:      dir,
+		Interval: interval,
+		Codec:    JSONCodec(),
+		Targets:  DefaultSafeArray[SnapshotTarget](),
+		Logger:   DefaultLogger(),
+		Context:  ctx,
+	}
+	for _, target := range targets {
+		s.Register(target)
+	}
+	return s, nil
+}
+
+func validateSnapshotInterval(interval time.Duration) Error {
+	if interval <= 0 {
+		return NewError(ErrorTypeSnapshot, fmt.Sprintf("snapshot interval should be positive; got: %s", interval), nil)
+	}
+	return nil
+}
@@ -0,0 +1,138 @@
+/*
+Copyright 2023 Alexandre Mahdhaoui
+
+Licensed under the Apache License, Version 2.0 (the "License");
+you may not use this file except in compliance with the License.
+You may obtain a copy of the License at
+
+	http://www.apache.org/licenses/LICENSE-2.0
+
+Unless required by applicable law or agreed to in writing, software
+distributed under the License is distributed on an "AS IS" BASIS,
+WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+See the License for the specific language governing permissions and
+limitations under the License.
+*/
+
+package bda
+
+import (
+	"reflect"
+	"sort"
+	"strings"
+	"testing"
+	"time"
+)
+
+func TestSnapshotterRoundTrip(t *testing.T) {
+	for _, codec := range []Codec{JSONCodec(), GobCodec()} {
+		t.Run(codec.Name(), func(t *testing.T) {
+			dir := t.TempDir()
+
+			m := DefaultMap[string, int]()
+			m.Set("a", 1)
+			m.Set("b", 2)
+			set := DefaultSet[string]()
+			set.Set("x")
+			set.Set("y")
+			a := DefaultSafeArray[string]()
+			a.Append("first")
+			a.Append("second")
+
+			saver := &Snapshotter{Name: "saver", Dir: dir, Codec: codec}
+			saver.Register(MapSnapshotTarget("map", m))
+			saver.Register(SetSnapshotTarget("set", set))
+			saver.Register(SafeArraySnapshotTarget("array", a))
+			if err := saver.SnapshotAll(); err != nil {
+				t.Fatalf("SnapshotAll() error = %v", err)
+			}
+
+			// the restored data structures replace their previous content
+			restoredMap := DefaultMap[string, int]()
+			restoredMap.Set("stale", 0)
+			restoredSet := DefaultSet[string]()
+			restoredArray := DefaultSafeArray[string]()
+			restoredArray.Append("stale")
+
+			restorer := &Snapshotter{Name: "restorer", Dir: dir, Codec: codec}
+			restorer.Register(MapSnapshotTarget("map", restoredMap))
+			restorer.Register(SetSnapshotTarget("set", restoredSet))
+			restorer.Register(SafeArraySnapshotTarget("array", restoredArray))
+			if err := restorer.Init(); err != nil {
+				t.Fatalf("Init() error = %v", err)
+			}
+
+			if got, want := restoredMap.Snapshot(), m.Snapshot(); !reflect.DeepEqual(got, want) {
+				t.Fatalf("restored map = %v; want %v", got, want)
+			}
+			values := restoredSet.Values()
+			sort.Strings(values)
+			if want := []string{"x", "y"}; !reflect.DeepEqual(values, want) {
+				t.Fatalf("restored set = %v; want %v", values, want)
+			}
+			if got, want := restoredArray.Snapshot(), []string{"first", "second"}; !reflect.DeepEqual(got, want) {
+				t.Fatalf("restored array = %v; want %v", got, want)
+			}
+		})
+	}
+}
+
+func TestSnapshotterInitLeavesTargetsWithoutSnapshot(t *testing.T) {
+	m := DefaultMap[string, int]()
+	m.Set("a", 1)
+
+	s := &Snapshotter{Name: "snapshotter", Dir: t.TempDir()}
+	s.Register(MapSnapshotTarget("map", m))
+	if err := s.Init(); err != nil {
+		t.Fatalf("Init() error = %v", err)
+	}
+	if got, want := m.Snapshot(), map[string]int{"a": 1}; !reflect.DeepEqual(got, want) {
+		t.Fatalf("map = %v; want %v", got, want)
+	}
+}
+
+func TestSnapshotterRejectsCodecMismatch(t *testing.T) {
+	dir := t.TempDir()
+	m := DefaultMap[string, int]()
+	m.Set("a", 1)
+	saver := &Snapshotter{Name: "saver", Dir: dir, Codec: JSONCodec()}
+	saver.Register(MapSnapshotTarget("map", m))
+	if err := saver.SnapshotAll(); err != nil {
+		t.Fatalf("SnapshotAll() error = %v", err)
+	}
+
+	restored := DefaultMap[string, int]()
+	restored.Set("b", 2)
+	restorer := &Snapshotter{Name: "restorer", Dir: dir, Codec: GobCodec()}
+	restorer.Register(MapSnapshotTarget("map", restored))
+	err := restorer.Init()
+	if err == nil || len(err.SubErrors) != 1 {
+		t.Fatalf("Init() error = %v; want one sub-error", err)
+	}
+	if sub := err.SubErrors[0]; sub.Type != ErrorTypeSnapshot || !strings.Contains(sub.Message, "unexpected snapshot codec") {
+		t.Fatalf("Init() error = %+v; want %s: unexpected snapshot codec", *sub, ErrorTypeSnapshot)
+	}
+	// the target is not modified by a snapshot it cannot decode
+	if got, want := restored.Snapshot(), map[string]int{"b": 2}; !reflect.DeepEqual(got, want) {
+		t.Fatalf("map = %v; want %v", got, want)
+	}
+}
+
+func TestSnapshotterLiteralCanRunAndStop(t *testing.T) {
+	s := &Snapshotter{Name: "snapshotter", Dir: t.TempDir(), Interval: time.Hour}
+	ran := make(chan Error, 1)
+	go func() {
+		ran <- s.Run()
+	}()
+	if err := s.Stop(); err != nil {
+		t.Fatalf("Stop() error = %v", err)
+	}
+	select {
+	case err := <-ran:
+		if err != nil {
+			t.Fatalf("Run() error = %v", err)
+		}
+	case <-time.After(5 * time.Second):
+		t.Fatalf("Run() did not return after Stop()")
+	}
+}
@@ -16,6 +16,11 @@ limitations under the License.
 
 package bda
 
+import (
+	"context"
+	"sync"
+)
+
 type Strategy interface {
 	Init(Runtime) Error
 	Run(Runtime) Error
@@ -23,6 +28,23 @@ type Strategy interface {
 	Stop(Runtime) Error
 }
 
+// ContextRuntime is implemented by the runtimes exposing their context, such as Worker, WorkerPool and Orchestrator.
+// Receptors should implement it with the context they were spawned with, i.e. the context of their Worker, so
+// strategies stop waiting on their behalf once the Worker is done.
+type ContextRuntime interface {
+	GetContext() context.Context
+}
+
+// RuntimeContext returns the context of a runtime implementing ContextRuntime, or context.Background().
+func RuntimeContext(runtime Runtime) context.Context {
+	if r, ok := runtime.(ContextRuntime); ok {
+		if ctx := r.GetContext(); ctx != nil {
+			return ctx
+		}
+	}
+	return context.Background()
+}
+
 type defaultStrategy struct{}
 
 func (s *defaultStrategy) Init(runtime Runtime) Error {
@@ -44,3 +66,75 @@ func (s *defaultStrategy) Stop(runtime Runtime) Error {
 func DefaultStrategy() Strategy {
 	return &defaultStrategy{}
 }
+
+//----------------------------------------------------------------------------------------------------------------------
+//- StrategyRegistry
+
+// StrategyRegistry resolves the Strategy of a runtime by its name or by its labels, so pools of an Orchestrator, or
+// workers of a WorkerPool, can use different strategies. Name matches take precedence over label matches; labels are
+// matched in registration order.
+//
+// A StrategyRegistry may be built as a struct literal: it is initialized on first use.
+type StrategyRegistry struct {
+	byName  Map[string, Strategy]
+	byLabel SafeArray[labelStrategy]
+
+	initOnce sync.Once
+}
+
+type labelStrategy struct {
+	Key      string
+	Value    string
+	Strategy Strategy
+}
+
+// RegisterName registers the Strategy of the runtime named name.
+func (r *StrategyRegistry) RegisterName(name string, strategy Strategy) {
+	r.init()
+	r.byName.Set(name, strategy)
+}
+
+// RegisterLabel registers the Strategy of the runtimes labeled with key=value.
+func (r *StrategyRegistry) RegisterLabel(key, value string, strategy Strategy) {
+	r.init()
+	r.byLabel.Append(labelStrategy{Key: key, Value: value, Strategy: strategy})
+}
+
+// Resolve returns the Strategy registered for a runtime name or labels. Returns false if none matches, in which case
+// callers fall back to their default Strategy.
+func (r *StrategyRegistry) Resolve(name string, labels map[string]string) (Strategy, bool) {
+	r.init()
+	if strategy, ok := r.byName.Get(name); ok {
+		return strategy, true
+	}
+
+	var strategy Strategy
+	r.byLabel.Range(func(_ int, l labelStrategy) bool {
+		if value, ok := labels[l.Key]; ok && value == l.Value {
+			strategy = l.Strategy
+			return false
+		}
+		return true
+	})
+	return strategy, strategy != nil
+}
+
+// init creates the maps of the registry on first use.
+func (r *StrategyRegistry) init() {
+	r.initOnce.Do(func() {
+		if r.byName == nil {
+			r.byName = DefaultMap[string, Strategy]()
+		}
+		if r.byLabel == nil {
+			r.byLabel = DefaultSafeArray[labelStrategy]()
+		}
+	})
+}
+
+// DefaultStrategyRegistry returns a new, empty StrategyRegistry.
+func DefaultStrategyRegistry() *StrategyRegistry {
+	return &StrategyRegistry{
+		byName:  DefaultMap[string, Strategy](),
+		byLabel: DefaultSafeArray[labelStrategy](),
+	}
+}
@@ -0,0 +1,341 @@
+/*
+Copyright 2023 Alexandre Mahdhaoui
+
+Licensed under the Apache License, Version 2.0 (the "License");
+you may not use this file except in compliance with the License.
+You may obtain a copy of the License at
+
+	http://www.apache.org/licenses/LICENSE-2.0
+
+Unless required by applicable law or agreed to in writing, software
+distributed under the License is distributed on an "AS IS" BASIS,
+WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+See the License for the specific language governing permissions and
+limitations under the License.
+*/
+
+package bda
+
+import (
+	"encoding/json"
+	"fmt"
+	"os"
+	"sort"
+	"strings"
+)
+
+const (
+	// KubernetesEnvPoolName is the environment variable naming the WorkerPool a pod runs.
+	KubernetesEnvPoolName = "BDA_POOL_NAME"
+	// KubernetesEnvOrchestratorName is the environment variable naming the Orchestrator a pod belongs to.
+	KubernetesEnvOrchestratorName = "BDA_ORCHESTRATOR_NAME"
+
+	kubernetesLabelName      = "app.kubernetes.io/name"
+	kubernetesLabelComponent = "app.kubernetes.io/component"
+	kubernetesLabelManagedBy = "app.kubernetes.io/managed-by"
+	kubernetesManagedBy      = "biological-driven-architecture"
+
+	kubernetesMaxNameLength = 63
+)
+
+//----------------------------------------------------------------------------------------------------------------------
+//- KubernetesManifestGenerator
+
+// KubernetesManifestGenerator generates the Kubernetes manifests deploying an Orchestrator: a ConfigMap shared by every
+// pod, one Deployment per WorkerPool with WorkerPool.Replicas replicas, and one Service per WorkerPool if Port is set.
+// Each pod runs a single worker of its pool through KubernetesPodBootstrap. Generation is offline and deterministic.
+type KubernetesManifestGenerator struct {
+	Namespace       string
+	Image           string
+	ImagePullPolicy string
+	Command         []string
+	Args            []string
+	// Port is exposed by the containers and by a Service per pool. No Service is generated if Port is 0.
+	Port int
+	// Config is stored in a ConfigMap exposed as environment variables to every pod.
+	Config map[string]string
+	// Labels are added to every resource.
+	Labels map[string]string
+}
+
+// Generate returns the manifests of the Orchestrator as a multi-document YAML stream.
+func (g *KubernetesManifestGenerator) Generate(o *Orchestrator) ([]byte, Error) {
+	if g.Image == "" {
+		return nil, NewError("KubernetesManifestError", "image should be set", nil)
+	}
+
+	appName, err := kubernetesName(o.Name)
+	if err != nil {
+		return nil, err
+	}
+
+	b := &strings.Builder{}
+	configMapName := kubernetesJoinNames(appName, "config")
+	g.writeConfigMap(b, o, configMapName, g.labels(appName, ""))
+
+	names := DefaultSet[string]()
+	for i := 0; i < o.WorkerPools.Length(); i++ {
+		p, ok := o.WorkerPools.Get(i)
+		if !ok || p == nil {
+			continue
+		}
+		component, err := kubernetesName(p.Name)
+		if err != nil {
+			return nil, err
+		}
+		name := kubernetesJoinNames(appName, component)
+		if !names.TrySet(name) {
+			return nil, NewError("KubernetesManifestError", fmt.Sprintf("pools of %s should have distinct names; got duplicate: %s", o.Name, name), nil)
+		}
+
+		labels := g.labels(appName, component)
+		g.writeDeployment(b, p, name, component, configMapName, labels)
+		if g.Port > 0 {
+			g.writeService(b, name, labels)
+		}
+	}
+	return []byte(b.String()), nil
+}
+
+func (g *KubernetesManifestGenerator) writeConfigMap(b *strings.Builder, o *Orchestrator, name string, labels map[string]string) {
+	data := map[string]string{KubernetesEnvOrchestratorName: o.Name}
+	for key, value := range g.Config {
+		data[key] = value
+	}
+
+	b.WriteString("---\napiVersion: v1\nkind: ConfigMap\n")
+	g.writeMetadata(b, name, labels)
+	b.WriteString("data:\n")
+	writeYAMLMap(b, 1, data)
+}
+
+func (g *KubernetesManifestGenerator) writeDeployment(b *strings.Builder, p *WorkerPool, name, container, configMapName string, labels map[string]string) {
+	selector := map[string]string{
+		kubernetesLabelName:      labels[kubernetesLabelName],
+		kubernetesLabelComponent: labels[kubernetesLabelComponent],
+	}
+
+	b.WriteString("---\napiVersion: apps/v1\nkind: Deployment\n")
+	g.writeMetadata(b, name, labels)
+	b.WriteString("spec:\n")
+	fmt.Fprintf(b, "  replicas: %d\n", p.Replicas)
+	b.WriteString("  selector:\n    matchLabels:\n")
+	writeYAMLMap(b, 3, selector)
+	b.WriteString("  template:\n    metadata:\n      labels:\n")
+	writeYAMLMap(b, 4, labels)
+	b.WriteString("    spec:\n      containers:\n")
+	fmt.Fprintf(b, "        - name: %s\n", yamlString(container))
+	fmt.Fprintf(b, "          image: %s\n", yamlString(g.Image))
+	if g.ImagePullPolicy != "" {
+		fmt.Fprintf(b, "          imagePullPolicy: %s\n", yamlString(g.ImagePullPolicy))
+	}
+	writeYAMLList(b, 5, "command", g.Command)
+	writeYAMLList(b, 5, "args", g.Args)
+	b.WriteString("          env:\n")
+	fmt.Fprintf(b, "            - name: %s\n              value: %s\n", KubernetesEnvPoolName, yamlString(p.Name))
+	b.WriteString("          envFrom:\n")
+	fmt.Fprintf(b, "            - configMapRef:\n                name: %s\n", yamlString(configMapName))
+	if g.Port > 0 {
+		fmt.Fprintf(b, "          ports:\n            - containerPort: %d\n", g.Port)
+	}
+}
+
+func (g *KubernetesManifestGenerator) writeService(b *strings.Builder, name string, labels map[string]string) {
+	selector := map[string]string{
+		kubernetesLabelName:      labels[kubernetesLabelName],
+		kubernetesLabelComponent: labels[kubernetesLabelComponent],
+	}
+
+	b.WriteString("---\napiVersion: v1\nkind: Service\n")
+	g.writeMetadata(b, name, labels)
+	b.WriteString("spec:\n  selector:\n")
+	writeYAMLMap(b, 2, selector)
+	fmt.Fprintf(b, "  ports:\n    - port: %d\n      targetPort: %d\n", g.Port, g.Port)
+}
+
+func (g *KubernetesManifestGenerator) writeMetadata(b *strings.Builder, name string, labels map[string]string) {
+	b.WriteString("metadata:\n")
+	fmt.Fprintf(b, "  name: %s\n", yamlString(name))
+	if g.Namespace != "" {
+		fmt.Fprintf(b, "  namespace: %s\n", yamlString(g.Namespace))
+	}
+	b.WriteString("  labels:\n")
+	writeYAMLMap(b, 2, labels)
+}
+
+// labels returns the labels of the resources of a pool, or of the whole Orchestrator if component is empty.
+func (g *KubernetesManifestGenerator) labels(appName, component string) map[string]string {
+	labels := make(map[string]string, len(g.Labels)+3)
+	for key, value := range g.Labels {
+		labels[key] = value
+	}
+	labels[kubernetesLabelName] = appName
+	labels[kubernetesLabelManagedBy] = kubernetesManagedBy
+	if component != "" {
+		labels[kubernetesLabelComponent] = component
+	}
+	return labels
+}
+
+// DefaultKubernetesManifestGenerator returns a new KubernetesManifestGenerator deploying image in namespace.
+func DefaultKubernetesManifestGenerator(namespace, image string) *KubernetesManifestGenerator {
+	return &KubernetesManifestGenerator{
+		Namespace: namespace,
+		Image:     image,
+		Config:    make(map[string]string),
+		Labels:    make(map[string]string),
+	}
+}
+
+// kubernetesName converts name into a valid Kubernetes resource name (RFC 1123 label): lowercase alphanumeric
+// characters and dashes, at most 63 characters. Returns an Error if name has no alphanumeric character.
+func kubernetesName(name string) (string, Error) {
+	b := &strings.Builder{}
+	for _, r := range strings.ToLower(name) {
+		switch {
+		case r >= 'a' && r <= 'z', r >= '0' && r <= '9':
+			b.WriteRune(r)
+		default:
+			b.WriteRune('-')
+		}
+	}
+
+	converted := trimKubernetesName(b.String())
+	if converted == "" {
+		return "", NewError("KubernetesManifestError", fmt.Sprintf("name should contain an alphanumeric character; got: %q", name), nil)
+	}
+	return converted, nil
+}
+
+// kubernetesJoinNames joins names returned by kubernetesName, keeping the result at most 63 characters.
+func kubernetesJoinNames(names ...string) string {
+	return trimKubernetesName(strings.Join(names, "-"))
+}
+
+func trimKubernetesName(name string) string {
+	if len(name) > kubernetesMaxNameLength {
+		name = name[:kubernetesMaxNameLength]
+	}
+	return strings.Trim(name, "-")
+}
+
+// yamlString quotes a string for YAML. JSON strings are valid YAML double-quoted scalars.
+func yamlString(s string) string {
+	quoted, _ := json.Marshal(s)
+	return string(quoted)
+}
+
+// writeYAMLMap writes a map of strings sorted by key, indented by level.
+func writeYAMLMap(b *strings.Builder, level int, m map[string]string) {
+	keys := make([]string, 0, len(m))
+	for key := range m {
+		keys = append(keys, key)
+	}
+	sort.Strings(keys)
+
+	indent := strings.Repeat("  ", level)
+	for _, key := range keys {
+		fmt.Fprintf(b, "%s%s: %s\n", indent, yamlString(key), yamlString(m[key]))
+	}
+}
+
+// writeYAMLList writes a list of strings under a field, indented by level. Nothing is written if items is empty.
+func writeYAMLList(b *strings.Builder, level int, field string, items []string) {
+	if len(items) == 0 {
+		return
+	}
+	indent := strings.Repeat("  ", level)
+	fmt.Fprintf(b, "%s%s:\n", indent, field)
+	for _, item := range items {
+		fmt.Fprintf(b, "%s  - %s\n", indent, yamlString(item))
+	}
+}
+
+//----------------------------------------------------------------------------------------------------------------------
+//- Pod-side strategy
+
+// kubernetesPodStrategy is the Strategy of an Orchestrator running in a pod generated by KubernetesManifestGenerator:
+// only the pool named PoolName runs, with a single worker, since the Deployment provides the replicas.
+type kubernetesPodStrategy struct {
+	Strategy Strategy
+	PoolName string
+}
+
+func (s *kubernetesPodStrategy) Init(runtime Runtime) Error {
+	if p, ok := runtime.(*WorkerPool); ok {
+		if !s.runs(runtime) {
+			return nil
+		}
+		p.Replicas = 1
+	}
+	return s.Strategy.Init(runtime)
+}
+
+func (s *kubernetesPodStrategy) Run(runtime Runtime) Error {
+	if !s.runs(runtime) {
+		return nil
+	}
+	return s.Strategy.Run(runtime)
+}
+
+func (s *kubernetesPodStrategy) HandleError(runtime Runtime, err Error) Error {
+	if !s.runs(runtime) {
+		return nil
+	}
+	return s.Strategy.HandleError(runtime, err)
+}
+
+func (s *kubernetesPodStrategy) Stop(runtime Runtime) Error {
+	if !s.runs(runtime) {
+		return nil
+	}
+	return s.Strategy.Stop(runtime)
+}
+
+// runs returns false for the pools other than PoolName.
+func (s *kubernetesPodStrategy) runs(runtime Runtime) bool {
+	p, ok := runtime.(*WorkerPool)
+	return !ok || p.Name == s.PoolName
+}
+
+// KubernetesPodStrategy returns a Strategy wrapping strategy, so that an Orchestrator only runs the pool named
+// poolName, with a single worker.
+func KubernetesPodStrategy(strategy Strategy, poolName string) Strategy {
+	return &kubernetesPodStrategy{
+		Strategy: strategy,
+		PoolName: poolName,
+	}
+}
+
+// KubernetesPodBootstrap runs the pool of the Orchestrator named by the KubernetesEnvPoolName environment variable,
+// set by the manifests of KubernetesManifestGenerator. It initializes and runs the Orchestrator until its context is
+// done, then stops it.
+func KubernetesPodBootstrap(o *Orchestrator) Error {
+	poolName := os.Getenv(KubernetesEnvPoolName)
+	if poolName == "" {
+		return NewError("KubernetesPodError", fmt.Sprintf("environment variable %s should be set", KubernetesEnvPoolName), nil)
+	}
+
+	var pool *WorkerPool
+	o.WorkerPools.Range(func(_ int, p *WorkerPool) bool {
+		if p != nil && p.Name == poolName {
+			pool = p
+		}
+		return pool == nil
+	})
+	if pool == nil {
+		return NewError("KubernetesPodError", fmt.Sprintf("orchestrator %s has no pool named %s", o.Name, poolName), nil)
+	}
+
+	LogInfof(o, LogOperationInit, LogStatusStart, "running pool %s in pod", poolName)
+	o.Strategy = KubernetesPodStrategy(o.strategy(pool), poolName)
+	o.Strategies = nil
+	if err := o.Init(); err != nil {
+		return err
+	}
+	if err := o.Run(); err != nil {
+		o.Stop()
+		return err
+	}
+	return o.Stop()
+}
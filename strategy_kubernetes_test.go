@@ -0,0 +1,103 @@
+/*
+Copyright 2023 Alexandre Mahdhaoui
+
+Licensed under the Apache License, Version 2.0 (the "License");
+you may not use this file except in compliance with the License.
+You may obtain a copy of the License at
+
+	http://www.apache.org/licenses/LICENSE-2.0
+
+Unless required by applicable law or agreed to in writing, software
+distributed under the License is distributed on an "AS IS" BASIS,
+WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+See the License for the specific language governing permissions and
+limitations under the License.
+*/
+
+package bda
+
+import (
+	"flag"
+	"os"
+	"path/filepath"
+	"testing"
+)
+
+var updateGolden = flag.Bool("update", false, "update the golden files in testdata")
+
+func testKubernetesOrchestrator(pools ...string) *Orchestrator {
+	o := &Orchestrator{Name: "My_Orchestrator", WorkerPools: DefaultSafeArray[*WorkerPool]()}
+	for _, pool := range pools {
+		o.WorkerPools.Append(&WorkerPool{Name: pool, Replicas: 3})
+	}
+	return o
+}
+
+func TestKubernetesManifestGeneratorGenerate(t *testing.T) {
+	g := DefaultKubernetesManifestGenerator("prod", "example.com/app:1.0")
+	g.Port = 8080
+	g.Command = []string{"/app"}
+	g.Args = []string{"--verbose"}
+	g.Config["LOG_LEVEL"] = "debug"
+	g.Labels["team"] = "core"
+
+	got, err := g.Generate(testKubernetesOrchestrator("ingest", "Export.Jobs"))
+	if err != nil {
+		t.Fatalf("Generate() error = %+v", *err)
+	}
+
+	golden := filepath.Join("testdata", "kubernetes_manifests.golden")
+	if *updateGolden {
+		if err := os.WriteFile(golden, got, 0o644); err != nil {
+			t.Fatal(err)
+		}
+	}
+	want, readErr := os.ReadFile(golden)
+	if readErr != nil {
+		t.Fatal(readErr)
+	}
+	if string(got) != string(want) {
+		t.Fatalf("Generate() =\n%s\nwant:\n%s", got, want)
+	}
+}
+
+func TestKubernetesManifestGeneratorGenerateRejectsInvalidNames(t *testing.T) {
+	for _, tc := range []struct {
+		name         string
+		orchestrator *Orchestrator
+	}{
+		{name: "empty orchestrator name", orchestrator: &Orchestrator{Name: "", WorkerPools: DefaultSafeArray[*WorkerPool]()}},
+		{name: "orchestrator name without alphanumeric character", orchestrator: &Orchestrator{Name: "_-_", WorkerPools: DefaultSafeArray[*WorkerPool]()}},
+		{name: "pool name without alphanumeric character", orchestrator: testKubernetesOrchestrator("ingest", "!!")},
+		{name: "duplicate pool names", orchestrator: testKubernetesOrchestrator("ingest", "INGEST")},
+	} {
+		t.Run(tc.name, func(t *testing.T) {
+			g := DefaultKubernetesManifestGenerator("prod", "example.com/app:1.0")
+			if _, err := g.Generate(tc.orchestrator); err == nil || err.Type != "KubernetesManifestError" {
+				t.Fatalf("Generate() error = %v; want KubernetesManifestError", err)
+			}
+		})
+	}
+}
+
+func TestKubernetesName(t *testing.T) {
+	long := "a-very-long-orchestrator-name-exceeding-the-limit-of-sixty-three-characters"
+	for _, tc := range []struct {
+		name string
+		want string
+	}{
+		{name: "ingest", want: "ingest"},
+		{name: "My_Pool.v2", want: "my-pool-v2"},
+		{name: "--pool--", want: "pool"},
+		{name: long, want: long[:63]},
+	} {
+		got, err := kubernetesName(tc.name)
+		if err != nil || got != tc.want {
+			t.Fatalf("kubernetesName(%q) = %q, %v; want %q", tc.name, got, err, tc.want)
+		}
+	}
+
+	if got, err := kubernetesName("___"); err == nil {
+		t.Fatalf("kubernetesName(\"___\") = %q; want an Error", got)
+	}
+}
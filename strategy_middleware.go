@@ -0,0 +1,385 @@
+/*
+Copyright 2023 Alexandre Mahdhaoui
+
+Licensed under the Apache License, Version 2.0 (the "License");
+you may not use this file except in compliance with the License.
+You may obtain a copy of the License at
+
+	http://www.apache.org/licenses/LICENSE-2.0
+
+Unless required by applicable law or agreed to in writing, software
+distributed under the License is distributed on an "AS IS" BASIS,
+WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+See the License for the specific language governing permissions and
+limitations under the License.
+*/
+
+package bda
+
+import (
+	"context"
+	"fmt"
+	"runtime/debug"
+	"sync"
+	"sync/atomic"
+	"time"
+)
+
+const (
+	// ErrorTypeStrategyTimeout is the type of the Error returned when an operation exceeds the timeout of
+	// TimeoutStrategyMiddleware.
+	ErrorTypeStrategyTimeout ErrorType = "StrategyTimeoutError"
+	// ErrorTypePanic is the type of the Error returned when RecoverStrategyMiddleware recovers a panic.
+	ErrorTypePanic ErrorType = "PanicError"
+
+	// maxRetryBackoff caps the delay between the attempts of RetryStrategyMiddleware.
+	maxRetryBackoff = 30 * time.Second
+)
+
+//----------------------------------------------------------------------------------------------------------------------
+//- Chain
+
+// StrategyMiddleware decorates a Strategy with additional behavior.
+type StrategyMiddleware func(next Strategy) Strategy
+
+// ChainStrategy decorates base with middlewares. The first middleware is the outermost one: it is called first, and
+// sees the result of all the others, e.g. ChainStrategy(base, RecoverStrategyMiddleware(), LoggingStrategyMiddleware())
+// recovers panics raised by the logging middleware too.
+func ChainStrategy(base Strategy, middlewares ...StrategyMiddleware) Strategy {
+	s := base
+	for i := len(middlewares) - 1; i >= 0; i-- {
+		s = middlewares[i](s)
+	}
+	return s
+}
+
+// StrategyInterceptor wraps an operation of a Strategy. call performs the operation on the next Strategy, and may be
+// called any number of times.
+type StrategyInterceptor func(op LogOperation, runtime Runtime, call func() Error) Error
+
+// InterceptorStrategyMiddleware returns a StrategyMiddleware applying interceptor to the given operations, or to every
+// operation if none is given. Operations are LogOperationInit, LogOperationRun, LogOperationHandleError and
+// LogOperationStop.
+func InterceptorStrategyMiddleware(interceptor StrategyInterceptor, ops ...LogOperation) StrategyMiddleware {
+	var intercepted map[LogOperation]bool
+	if len(ops) > 0 {
+		intercepted = make(map[LogOperation]bool, len(ops))
+		for _, op := range ops {
+			intercepted[op] = true
+		}
+	}
+
+	return func(next Strategy) Strategy {
+		return &interceptedStrategy{
+			next:        next,
+			interceptor: interceptor,
+			intercepted: intercepted,
+		}
+	}
+}
+
+// interceptedStrategy is a Strategy whose operations go through a StrategyInterceptor.
+type interceptedStrategy struct {
+	next        Strategy
+	interceptor StrategyInterceptor
+	// intercepted is nil if every operation is intercepted.
+	intercepted map[LogOperation]bool
+}
+
+func (s *interceptedStrategy) Init(runtime Runtime) Error {
+	return s.intercept(LogOperationInit, runtime, func() Error {
+		return s.next.Init(runtime)
+	})
+}
+
+func (s *interceptedStrategy) Run(runtime Runtime) Error {
+	return s.intercept(LogOperationRun, runtime, func() Error {
+		return s.next.Run(runtime)
+	})
+}
+
+func (s *interceptedStrategy) HandleError(runtime Runtime, err Error) Error {
+	return s.intercept(LogOperationHandleError, runtime, func() Error {
+		return s.next.HandleError(runtime, err)
+	})
+}
+
+func (s *interceptedStrategy) Stop(runtime Runtime) Error {
+	return s.intercept(LogOperationStop, runtime, func() Error {
+		return s.next.Stop(runtime)
+	})
+}
+
+func (s *interceptedStrategy) intercept(op LogOperation, runtime Runtime, call func() Error) Error {
+	if s.intercepted != nil && !s.intercepted[op] {
+		return call()
+	}
+	return s.interceptor(op, runtime, call)
+}
+
+//----------------------------------------------------------------------------------------------------------------------
+//- Logging
+
+// LoggingStrategyMiddleware logs the start, the outcome and the duration of every operation.
+func LoggingStrategyMiddleware() StrategyMiddleware {
+	return InterceptorStrategyMiddleware(func(op LogOperation, runtime Runtime, call func() Error) Error {
+		LogDebug(runtime, op, LogStatusStart)
+		start := time.Now()
+		err := call()
+		if err != nil {
+			LogInfof(runtime, op, LogStatusFailed, "failed after %s; %+v", time.Since(start), *err)
+			return err
+		}
+		LogDebugf(runtime, op, LogStatusSuccess, "succeeded after %s", time.Since(start))
+		return nil
+	})
+}
+
+//----------------------------------------------------------------------------------------------------------------------
+//- Metrics
+
+// StrategyMetrics collects the metrics of the operations going through MetricsStrategyMiddleware. It may be shared by
+// several strategies. The zero value is ready to use.
+type StrategyMetrics struct {
+	operations     Map[LogOperation, *strategyOperationCounters]
+	operationsInit sync.Once
+}
+
+// StrategyOperationMetrics is a snapshot of the metrics of an operation.
+type StrategyOperationMetrics struct {
+	Calls    uint64
+	Failures uint64
+	// Duration is the cumulated duration of the calls.
+	Duration time.Duration
+}
+
+type strategyOperationCounters struct {
+	calls    atomic.Uint64
+	failures atomic.Uint64
+	duration atomic.Int64
+}
+
+// Snapshot returns the metrics of every operation called at least once.
+func (m *StrategyMetrics) Snapshot() map[LogOperation]StrategyOperationMetrics {
+	snapshot := make(map[LogOperation]StrategyOperationMetrics)
+	m.counters().Range(func(op LogOperation, c *strategyOperationCounters) bool {
+		snapshot[op] = StrategyOperationMetrics{
+			Calls:    c.calls.Load(),
+			Failures: c.failures.Load(),
+			Duration: time.Duration(c.duration.Load()),
+		}
+		return true
+	})
+	return snapshot
+}
+
+func (m *StrategyMetrics) record(op LogOperation, d time.Duration, err Error) {
+	c, ok := m.counters().Get(op)
+	if !ok {
+		c, _ = m.counters().LoadOrStore(op, &strategyOperationCounters{})
+	}
+	c.calls.Add(1)
+	c.duration.Add(int64(d))
+	if err != nil {
+		c.failures.Add(1)
+	}
+}
+
+// counters returns the counters of the operations by name, creating the map on first use.
+func (m *StrategyMetrics) counters() Map[LogOperation, *strategyOperationCounters] {
+	m.operationsInit.Do(func() {
+		if m.operations == nil {
+			m.operations = DefaultMap[LogOperation, *strategyOperationCounters]()
+		}
+	})
+	return m.operations
+}
+
+// DefaultStrategyMetrics returns a new, empty StrategyMetrics.
+func DefaultStrategyMetrics() *StrategyMetrics {
+	return &StrategyMetrics{
+		operations: DefaultMap[LogOperation, *strategyOperationCounters](),
+	}
+}
+
+// MetricsStrategyMiddleware records the calls, failures and duration of every operation into metrics.
+func MetricsStrategyMiddleware(metrics *StrategyMetrics) StrategyMiddleware {
+	return InterceptorStrategyMiddleware(func(op LogOperation, runtime Runtime, call func() Error) Error {
+		start := time.Now()
+		err := call()
+		metrics.record(op, time.Since(start), err)
+		return err
+	})
+}
+
+//----------------------------------------------------------------------------------------------------------------------
+//- Tracing
+
+// Tracer starts spans, e.g. by adapting an OpenTelemetry tracer.
+type Tracer interface {
+	Start(ctx context.Context, name string) (context.Context, Span)
+}
+
+// Span is a traced operation.
+type Span interface {
+	SetAttribute(key, value string)
+	SetError(err Error)
+	End()
+}
+
+// TracingStrategyMiddleware traces every operation in a span named "<runtime type>.<operation>". Spans are started from
+// the context of the runtime (see RuntimeContext).
+func TracingStrategyMiddleware(tracer Tracer) StrategyMiddleware {
+	return InterceptorStrategyMiddleware(func(op LogOperation, runtime Runtime, call func() Error) Error {
+		_, span := tracer.Start(RuntimeContext(runtime), fmt.Sprintf("%s.%s", runtime.GetType(), op))
+		defer span.End()
+		span.SetAttribute(logFieldName, runtime.GetName())
+		span.SetAttribute(logFieldType, runtime.GetType())
+
+		err := call()
+		if err != nil {
+			span.SetError(err)
+		}
+		return err
+	})
+}
+
+//----------------------------------------------------------------------------------------------------------------------
+//- Retry
+
+// RetryStrategyMiddleware retries Init and Run until they succeed, at most attempts times in total. The delay between
+// attempts starts at backoff and doubles after each attempt, up to 30s. Retrying stops once the context of the runtime
+// is done (see RuntimeContext). The last error is returned.
+func RetryStrategyMiddleware(attempts int, backoff time.Duration) StrategyMiddleware {
+	return InterceptorStrategyMiddleware(func(op LogOperation, runtime Runtime, call func() Error) Error {
+		ctx := RuntimeContext(runtime)
+		delay := backoff
+		var err Error
+		for attempt := 1; ; attempt++ {
+			if err = call(); err == nil || attempt >= attempts {
+				return err
+			}
+			LogDebugf(runtime, op, LogStatusProgress, "attempt %d/%d failed; retrying in %s", attempt, attempts, delay)
+
+			timer := time.NewTimer(delay)
+			select {
+			case <-ctx.Done():
+				timer.Stop()
+				return err
+			case <-timer.C:
+			}
+			if delay *= 2; delay > maxRetryBackoff {
+				delay = maxRetryBackoff
+			}
+		}
+	}, LogOperationInit, LogOperationRun)
+}
+
+//----------------------------------------------------------------------------------------------------------------------
+//- Timeout
+
+// TimeoutStrategyMiddleware returns an Error of type ErrorTypeStrategyTimeout when Init, Run or Stop exceeds timeout.
+// Runtimes cannot be interrupted: the operation keeps running in the background, and its result is discarded. Until an
+// abandoned Init or Run returns, the next Init or Run of the same runtime waits for it, so a runtime never runs
+// concurrently with itself; if it is still running after timeout, an Error of type ErrorTypeStrategyTimeout is
+// returned without calling the runtime. Stop is not held back, so it may interrupt the abandoned operation. Panics
+// raised before the timeout are propagated to the caller.
+func TimeoutStrategyMiddleware(timeout time.Duration) StrategyMiddleware {
+	// abandoned holds, by runtime name, a channel closed once the abandoned Init or Run of the runtime returns.
+	abandoned := DefaultMap[string, chan struct{}]()
+
+	return InterceptorStrategyMiddleware(func(op LogOperation, runtime Runtime, call func() Error) Error {
+		name := runtime.GetName()
+		if returned, ok := abandoned.Get(name); ok && op != LogOperationStop {
+			timer := time.NewTimer(timeout)
+			select {
+			case <-returned:
+				timer.Stop()
+			case <-timer.C:
+				LogWarnf(runtime, op, LogStatusFailed, "abandoned operation still running after %s", timeout)
+				return NewError(ErrorTypeStrategyTimeout, fmt.Sprintf("%s of %s exceeded timeout of %s; an abandoned operation is still running", op, name, timeout), nil)
+			}
+		}
+
+		result := make(chan Error, 1)
+		panicked := make(chan any, 1)
+		returned := make(chan struct{})
+		go func() {
+			defer close(returned)
+			defer func() {
+				if r := recover(); r != nil {
+					panicked <- r
+				}
+			}()
+			result <- call()
+		}()
+
+		timer := time.NewTimer(timeout)
+		defer timer.Stop()
+		select {
+		case err := <-result:
+			return err
+		case r := <-panicked:
+			// re-panic in the calling goroutine, so outer middlewares can recover it
+			panic(r)
+		case <-timer.C:
+			LogWarnf(runtime, op, LogStatusFailed, "operation exceeded timeout of %s", timeout)
+			if op != LogOperationStop {
+				abandoned.Set(name, returned)
+				go func() {
+					<-returned
+					// forget the operation, unless a newer one was abandoned since
+					abandoned.Compute(name, func(current chan struct{}, ok bool) (chan struct{}, bool) {
+						return current, ok && current != returned
+					})
+				}()
+			}
+			return NewError(ErrorTypeStrategyTimeout, fmt.Sprintf("%s of %s exceeded timeout of %s", op, name, timeout), nil)
+		}
+	}, LogOperationInit, LogOperationRun, LogOperationStop)
+}
+
+//----------------------------------------------------------------------------------------------------------------------
+//- RateLimit
+
+// RateLimitStrategyMiddleware limits the rate of Run to rate calls per second, with bursts of up to burst calls.
+// The limit is shared by every runtime the decorated Strategy runs. Bursts lower than 1 are rounded up. Panics if rate
+// is not positive; use RateLimiterStrategyMiddleware to share a RateLimiter or to handle invalid limits.
+func RateLimitStrategyMiddleware(rate float64, burst int) StrategyMiddleware {
+	if burst < 1 {
+		burst = 1
+	}
+	bucket, err := NewTokenBucket(rate, burst)
+	if err != nil {
+		panic(err.Message)
+	}
+	return RateLimiterStrategyMiddleware(bucket)
+}
+
+// RateLimiterStrategyMiddleware waits for the RateLimiter before every Run. Share the RateLimiter between strategies
+// to share the quota across them. Waiting stops once the context of the runtime is done (see RuntimeContext). If the
+// RateLimiter rejects the run, its Error is returned and the Run is skipped.
+func RateLimiterStrategyMiddleware(limiter RateLimiter) StrategyMiddleware {
+	return InterceptorStrategyMiddleware(func(op LogOperation, runtime Runtime, call func() Error) Error {
+		if err := limiter.Wait(RuntimeContext(runtime)); err != nil {
+			return err
+		}
+		return call()
+	}, LogOperationRun)
+}
+
+//----------------------------------------------------------------------------------------------------------------------
+//- Recover
+
+// RecoverStrategyMiddleware converts panics raised by any operation into an Error of type ErrorTypePanic.
+func RecoverStrategyMiddleware() StrategyMiddleware {
+	return InterceptorStrategyMiddleware(func(op LogOperation, runtime Runtime, call func() Error) (err Error) {
+		defer func() {
+			if r := recover(); r != nil {
+				LogErrorf(runtime, op, LogStatusFailed, "recovered from panic: %v\n%s", r, debug.Stack())
+				err = NewError(ErrorTypePanic, fmt.Sprintf("%s of %s panicked: %v", op, runtime.GetName(), r), nil)
+			}
+		}()
+		return call()
+	})
+}
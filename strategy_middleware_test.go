@@ -0,0 +1,88 @@
+/*
+Copyright 2023 Alexandre Mahdhaoui
+
+Licensed under the Apache License, Version 2.0 (the "License");
+you may not use this file except in compliance with the License.
+You may obtain a copy of the License at
+
+	http://www.apache.org/licenses/LICENSE-2.0
+
+Unless required by applicable law or agreed to in writing, software
+distributed under the License is distributed on an "AS IS" BASIS,
+WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+See the License for the specific language governing permissions and
+limitations under the License.
+*/
+
+package bda
+
+import (
+	"context"
+	"testing"
+	"time"
+)
+
+func TestTimeoutStrategyMiddlewareDoesNotRunConcurrently(t *testing.T) {
+	release := make(chan struct{})
+	r := &fakeRuntime{name: "receptor", run: func() Error {
+		<-release
+		return nil
+	}}
+	s := ChainStrategy(DefaultStrategy(), TimeoutStrategyMiddleware(20*time.Millisecond))
+
+	if err := s.Run(r); err == nil || err.Type != ErrorTypeStrategyTimeout {
+		t.Fatalf("Run() error = %v; want %s", err, ErrorTypeStrategyTimeout)
+	}
+	// the abandoned run is still in progress: the next one is not started
+	if err := s.Run(r); err == nil || err.Type != ErrorTypeStrategyTimeout {
+		t.Fatalf("Run() error = %v; want %s", err, ErrorTypeStrategyTimeout)
+	}
+	if got := r.running.Load(); got != 1 {
+		t.Fatalf("concurrent runs = %d; want 1", got)
+	}
+
+	// once the abandoned run returned, the next one runs
+	close(release)
+	if err := s.Run(r); err != nil {
+		t.Fatalf("Run() error = %v", err)
+	}
+	if got := r.runs.Load(); got != 2 {
+		t.Fatalf("runs = %d; want 2", got)
+	}
+}
+
+func TestRetryStrategyMiddlewareStopsWhenContextIsDone(t *testing.T) {
+	ctx, cancel := context.WithCancel(context.Background())
+	r := &fakeRuntime{name: "receptor", ctx: ctx, run: func() Error {
+		cancel()
+		return NewError("TestError", "failed", nil)
+	}}
+	s := ChainStrategy(DefaultStrategy(), RetryStrategyMiddleware(10, time.Hour))
+
+	done := make(chan Error, 1)
+	go func() {
+		done <- s.Run(r)
+	}()
+	select {
+	case err := <-done:
+		if err == nil || err.Type != "TestError" {
+			t.Fatalf("Run() error = %v; want TestError", err)
+		}
+	case <-time.After(5 * time.Second):
+		t.Fatalf("Run() still waiting for the backoff after the context is done")
+	}
+	if got := r.runs.Load(); got != 1 {
+		t.Fatalf("runs = %d; want 1", got)
+	}
+}
+
+func TestStrategyMetricsZeroValue(t *testing.T) {
+	metrics := &StrategyMetrics{}
+	s := ChainStrategy(DefaultStrategy(), MetricsStrategyMiddleware(metrics))
+	if err := s.Run(&fakeRuntime{name: "receptor"}); err != nil {
+		t.Fatalf("Run() error = %v", err)
+	}
+	if got := metrics.Snapshot()[LogOperationRun].Calls; got != 1 {
+		t.Fatalf("run calls = %d; want 1", got)
+	}
+}
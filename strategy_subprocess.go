@@ -0,0 +1,546 @@
+/*
+Copyright 2023 Alexandre Mahdhaoui
+
+Licensed under the Apache License, Version 2.0 (the "License");
+you may not use this file except in compliance with the License.
+You may obtain a copy of the License at
+
+	http://www.apache.org/licenses/LICENSE-2.0
+
+Unless required by applicable law or agreed to in writing, software
+distributed under the License is distributed on an "AS IS" BASIS,
+WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+See the License for the specific language governing permissions and
+limitations under the License.
+*/
+
+package bda
+
+import (
+	"bufio"
+	"context"
+	"encoding/json"
+	"fmt"
+	"net"
+	"os"
+	"os/exec"
+	"path/filepath"
+	"strings"
+	"sync"
+	"time"
+)
+
+const (
+	// ErrorTypeSubprocessExit is the type of the Error returned when the process of a receptor exits unexpectedly.
+	ErrorTypeSubprocessExit ErrorType = "SubprocessExitError"
+
+	// SubprocessRoleFlag is the flag passed to the current binary when it is re-executed to run a receptor. Its value
+	// is the key the receptor builder was registered with (see RegisterSubprocessReceptor).
+	SubprocessRoleFlag = "--bda-subprocess"
+
+	subprocessEnvName   = "BDA_SUBPROCESS_NAME"
+	subprocessEnvSocket = "BDA_SUBPROCESS_SOCKET"
+
+	subprocessOpInit        = "init"
+	subprocessOpRun         = "run"
+	subprocessOpHandleError = "handleError"
+	subprocessOpStop        = "stop"
+
+	DefaultSubprocessStartTimeout = 10 * time.Second
+	DefaultSubprocessStopTimeout  = 10 * time.Second
+)
+
+// subprocessReceptors holds the receptor builders that can run in a subprocess. Parent and child processes run the
+// same binary, so they register the same builders.
+var subprocessReceptors = DefaultMap[string, Builder[Runtime]]()
+
+// RegisterSubprocessReceptor registers a receptor builder under a key, so a SubprocessStrategy can run its receptors in
+// child processes. It must be called in the parent and in the child, e.g. at the beginning of main, before ServeSubprocess.
+func RegisterSubprocessReceptor(key string, builder Builder[Runtime]) {
+	subprocessReceptors.Set(key, builder)
+}
+
+// subprocessRequest is sent by the parent to the child process. Requests and responses are newline-delimited JSON, and
+// are matched by ID so Stop can be sent while Run is in progress.
+type subprocessRequest struct {
+	ID    uint64 `json:"id"`
+	Op    string `json:"op"`
+	Error Error  `json:"error,omitempty"`
+}
+
+type subprocessResponse struct {
+	ID    uint64 `json:"id"`
+	Error Error  `json:"error,omitempty"`
+}
+
+//----------------------------------------------------------------------------------------------------------------------
+//- SubprocessStrategy
+
+// SubprocessStrategy is a Strategy running each receptor in a child process, for crash isolation. The child is the
+// current binary re-executed with SubprocessRoleFlag; it must call ServeSubprocess (see IsSubprocess). Init, Run,
+// HandleError and Stop are proxied to the child over a Unix socket. The receptor given to the strategy by the Worker
+// only identifies the child by its name: the child builds its own receptor with the builder registered under
+// ReceptorKey. Use SubprocessReceptorBuilder as the ReceptorFactory, so the parent does not build receptors.
+//
+// When the child exits unexpectedly, pending calls return an Error of type ErrorTypeSubprocessExit, and the next Run
+// starts and initializes a new child. When the context of the receptor is done (see RuntimeContext), the child is
+// stopped like by Stop, and killed after StopTimeout.
+//
+// A SubprocessStrategy may be built as a struct literal: defaults are applied when the fields are used.
+type SubprocessStrategy struct {
+	ReceptorKey string
+	// Executable defaults to the current binary.
+	Executable string
+	// Env is appended to the environment of the parent when starting a child.
+	Env []string
+	// StartTimeout defaults to DefaultSubprocessStartTimeout if not positive.
+	StartTimeout time.Duration
+	// StopTimeout defaults to DefaultSubprocessStopTimeout if not positive.
+	StopTimeout time.Duration
+
+	children     Map[string, *subprocess]
+	childrenInit sync.Once
+}
+
+func (s *SubprocessStrategy) Init(runtime Runtime) Error {
+	p, err := s.start(runtime)
+	if err != nil {
+		return err
+	}
+	return p.call(subprocessRequest{Op: subprocessOpInit})
+}
+
+// Run is proxied to the child. Batches cannot be sent to the child: running the adapter of
+// WorkerPoolStrategyRunBatchLoop returns an Error.
+func (s *SubprocessStrategy) Run(runtime Runtime) Error {
+	if _, ok := runtime.(batchRunner); ok {
+		return NewError("SubprocessError", fmt.Sprintf("cannot run batches of %s in a subprocess", runtime.GetName()), nil)
+	}
+	p, ok := s.childMap().Get(runtime.GetName())
+	if !ok || p.exited() {
+		LogInfof(runtime, LogOperationRun, LogStatusProgress, "starting new subprocess for %s", runtime.GetName())
+		if err := s.Init(runtime); err != nil {
+			return err
+		}
+		p, _ = s.childMap().Get(runtime.GetName())
+	}
+	return p.call(subprocessRequest{Op: subprocessOpRun})
+}
+
+// HandleError is proxied to the child. If the child is not running, err is returned unchanged.
+func (s *SubprocessStrategy) HandleError(runtime Runtime, err Error) Error {
+	p, ok := s.childMap().Get(runtime.GetName())
+	if !ok || p.exited() {
+		return err
+	}
+	return p.call(subprocessRequest{Op: subprocessOpHandleError, Error: err})
+}
+
+// Stop stops the receptor of the child, then waits for the child to exit. The child is killed after StopTimeout.
+func (s *SubprocessStrategy) Stop(runtime Runtime) Error {
+	p, ok := s.childMap().Delete(runtime.GetName())
+	if !ok {
+		return nil
+	}
+	defer p.close()
+	if p.exited() {
+		return nil
+	}
+	err := p.call(subprocessRequest{Op: subprocessOpStop})
+	p.terminate(positiveOrDefault(s.StopTimeout, DefaultSubprocessStopTimeout))
+	return err
+}
+
+// childMap returns the children of the strategy by runtime name, creating the map on first use.
+func (s *SubprocessStrategy) childMap() Map[string, *subprocess] {
+	s.childrenInit.Do(func() {
+		if s.children == nil {
+			s.children = DefaultMap[string, *subprocess]()
+		}
+	})
+	return s.children
+}
+
+// start starts a child process for the runtime and waits for it to connect.
+func (s *SubprocessStrategy) start(runtime Runtime) (*subprocess, Error) {
+	if previous, ok := s.childMap().Delete(runtime.GetName()); ok {
+		previous.terminate(0)
+		previous.close()
+	}
+
+	executable := s.Executable
+	if executable == "" {
+		var err error
+		if executable, err = os.Executable(); err != nil {
+			return nil, NewError("SubprocessError", err.Error(), nil)
+		}
+	}
+
+	dir, err := os.MkdirTemp("", "bda-")
+	if err != nil {
+		return nil, NewError("SubprocessError", err.Error(), nil)
+	}
+	socket := filepath.Join(dir, "receptor.sock")
+	listener, err := net.Listen("unix", socket)
+	if err != nil {
+		os.RemoveAll(dir)
+		return nil, NewError("SubprocessError", err.Error(), nil)
+	}
+	defer listener.Close()
+
+	cmd := exec.Command(executable, fmt.Sprintf("%s=%s", SubprocessRoleFlag, s.ReceptorKey))
+	cmd.Env = append(os.Environ(), s.Env...)
+	cmd.Env = append(cmd.Env,
+		fmt.Sprintf("%s=%s", subprocessEnvName, runtime.GetName()),
+		fmt.Sprintf("%s=%s", subprocessEnvSocket, socket),
+	)
+	cmd.Stdout, cmd.Stderr = os.Stdout, os.Stderr
+	if err := cmd.Start(); err != nil {
+		os.RemoveAll(dir)
+		return nil, NewError("SubprocessError", err.Error(), nil)
+	}
+	LogDebugf(runtime, LogOperationInit, LogStatusProgress, "started subprocess %d for %s", cmd.Process.Pid, runtime.GetName())
+
+	p := newSubprocess(runtime.GetName(), cmd, dir)
+	ctx := RuntimeContext(runtime)
+	go func() {
+		select {
+		case <-ctx.Done():
+			LogDebugf(runtime, LogOperationStop, LogStatusProgress, "context done; terminating subprocess for %s", runtime.GetName())
+			p.terminate(positiveOrDefault(s.StopTimeout, DefaultSubprocessStopTimeout))
+		case <-p.done:
+		}
+	}()
+
+	startTimeout := positiveOrDefault(s.StartTimeout, DefaultSubprocessStartTimeout)
+	accepted := make(chan net.Conn, 1)
+	go func() {
+		if conn, err := listener.Accept(); err == nil {
+			accepted <- conn
+		}
+	}()
+
+	select {
+	case conn := <-accepted:
+		p.serve(conn)
+		s.childMap().Set(runtime.GetName(), p)
+		return p, nil
+	case <-p.done:
+		p.close()
+		return nil, p.exitError()
+	case <-time.After(startTimeout):
+		p.terminate(0)
+		p.close()
+		return nil, NewError("SubprocessError", fmt.Sprintf("subprocess for %s did not connect within %s", runtime.GetName(), startTimeout), nil)
+	}
+}
+
+// DefaultSubprocessStrategy returns a new SubprocessStrategy running the receptors built by the builder registered
+// under receptorKey in child processes.
+func DefaultSubprocessStrategy(receptorKey string) *SubprocessStrategy {
+	return &SubprocessStrategy{
+		ReceptorKey:  receptorKey,
+		StartTimeout: DefaultSubprocessStartTimeout,
+		StopTimeout:  DefaultSubprocessStopTimeout,
+	}
+}
+
+// SubprocessReceptorBuilder returns the Builder to use as the ReceptorFactory of a WorkerFactory whose WorkerStrategy
+// is a SubprocessStrategy. Receptors are built in the child processes: the built runtimes only carry the name and the
+// context of the Worker, and their operations do nothing.
+func SubprocessReceptorBuilder() Builder[Runtime] {
+	return &subprocessReceptorBuilder{}
+}
+
+type subprocessReceptorBuilder struct{}
+
+func (b *subprocessReceptorBuilder) Spawn(name string, ctx context.Context) (Runtime, Error) {
+	return &subprocessReceptor{Name: name, Logger: DefaultLogger(), Context: ctx}, nil
+}
+
+// subprocessReceptor is the parent side placeholder of a receptor running in a child process.
+type subprocessReceptor struct {
+	Name    string
+	Logger  *Logger
+	Context context.Context
+}
+
+func (r *subprocessReceptor) Init() Error {
+	return nil
+}
+
+func (r *subprocessReceptor) Run() Error {
+	return nil
+}
+
+func (r *subprocessReceptor) HandleError(err Error) Error {
+	return err
+}
+
+func (r *subprocessReceptor) Stop() Error {
+	return nil
+}
+
+func (r *subprocessReceptor) GetName() string {
+	return r.Name
+}
+
+func (r *subprocessReceptor) GetType() string {
+	return "subprocess-receptor"
+}
+
+func (r *subprocessReceptor) GetLogger() *Logger {
+	return r.Logger
+}
+
+func (r *subprocessReceptor) GetContext() context.Context {
+	return r.Context
+}
+
+//----------------------------------------------------------------------------------------------------------------------
+//- subprocess
+
+// subprocess is the parent side of a child process.
+type subprocess struct {
+	name string
+	cmd  *exec.Cmd
+	dir  string
+
+	conn    net.Conn
+	encoder *json.Encoder
+	pending map[uint64]chan subprocessResponse
+	nextID  uint64
+	waitErr error
+	// done is closed when the process exits.
+	done  chan struct{}
+	mutex *sync.Mutex
+}
+
+// call sends a request to the child and waits for its response, or for the child to exit.
+func (p *subprocess) call(req subprocessRequest) Error {
+	p.mutex.Lock()
+	p.nextID++
+	req.ID = p.nextID
+	response := make(chan subprocessResponse, 1)
+	p.pending[req.ID] = response
+	err := p.encoder.Encode(req)
+	p.mutex.Unlock()
+
+	if err != nil {
+		<-p.done
+		return p.exitError()
+	}
+
+	select {
+	case resp := <-response:
+		return resp.Error
+	case <-p.done:
+		// the response may have been received right before the process exited
+		select {
+		case resp := <-response:
+			return resp.Error
+		default:
+			return p.exitError()
+		}
+	}
+}
+
+// serve reads responses from the child until the connection is closed.
+func (p *subprocess) serve(conn net.Conn) {
+	p.mutex.Lock()
+	p.conn = conn
+	p.encoder = json.NewEncoder(conn)
+	p.mutex.Unlock()
+
+	go func() {
+		decoder := json.NewDecoder(bufio.NewReader(conn))
+		for {
+			var resp subprocessResponse
+			if err := decoder.Decode(&resp); err != nil {
+				return
+			}
+			p.mutex.Lock()
+			response, ok := p.pending[resp.ID]
+			delete(p.pending, resp.ID)
+			p.mutex.Unlock()
+			if ok {
+				response <- resp
+			}
+		}
+	}()
+}
+
+func (p *subprocess) exited() bool {
+	select {
+	case <-p.done:
+		return true
+	default:
+		return false
+	}
+}
+
+func (p *subprocess) exitError() Error {
+	<-p.done
+	msg := fmt.Sprintf("subprocess for %s exited", p.name)
+	if p.waitErr != nil {
+		msg = fmt.Sprintf("%s; %v", msg, p.waitErr)
+	}
+	return NewError(ErrorTypeSubprocessExit, msg, nil)
+}
+
+// terminate closes the connection, so the child exits once its receptor stopped, and kills it after timeout.
+func (p *subprocess) terminate(timeout time.Duration) {
+	p.mutex.Lock()
+	if p.conn != nil {
+		p.conn.Close()
+	}
+	p.mutex.Unlock()
+
+	select {
+	case <-p.done:
+	case <-time.After(timeout):
+		p.cmd.Process.Kill()
+		<-p.done
+	}
+}
+
+func (p *subprocess) close() {
+	p.mutex.Lock()
+	if p.conn != nil {
+		p.conn.Close()
+	}
+	p.mutex.Unlock()
+	os.RemoveAll(p.dir)
+}
+
+func newSubprocess(name string, cmd *exec.Cmd, dir string) *subprocess {
+	p := &subprocess{
+		name:    name,
+		cmd:     cmd,
+		dir:     dir,
+		pending: make(map[uint64]chan subprocessResponse),
+		done:    make(chan struct{}),
+		mutex:   &sync.Mutex{},
+	}
+	go func() {
+		p.waitErr = cmd.Wait()
+		close(p.done)
+	}()
+	return p
+}
+
+//----------------------------------------------------------------------------------------------------------------------
+//- Child bootstrap
+
+// IsSubprocess returns true if the current process was started by a SubprocessStrategy. In that case, main should
+// call ServeSubprocess instead of building an Orchestrator.
+func IsSubprocess() bool {
+	_, ok := subprocessRole()
+	return ok
+}
+
+// ServeSubprocess builds the receptor requested by the parent process and serves its calls until the parent stops it
+// or disconnects, or ctx is done. It returns the exit code of the child process, e.g.
+// os.Exit(bda.ServeSubprocess(ctx)). Once disconnected, calls in progress get DefaultSubprocessStopTimeout to return
+// after the receptor is stopped; ServeSubprocess then returns 1 without waiting for them, so the child exits.
+func ServeSubprocess(ctx context.Context) int {
+	key, ok := subprocessRole()
+	if !ok {
+		fmt.Fprintf(os.Stderr, "process was not started by a SubprocessStrategy; missing %s flag\n", SubprocessRoleFlag)
+		return 2
+	}
+	builder, ok := subprocessReceptors.Get(key)
+	if !ok {
+		fmt.Fprintf(os.Stderr, "no receptor registered for key: %s\n", key)
+		return 2
+	}
+
+	ctx, cancel := context.WithCancel(ctx)
+	defer cancel()
+
+	receptor, err := builder.Spawn(os.Getenv(subprocessEnvName), ctx)
+	if err != nil {
+		fmt.Fprintf(os.Stderr, "cannot spawn receptor %s; %+v\n", key, *err)
+		return 1
+	}
+
+	conn, e := net.Dial("unix", os.Getenv(subprocessEnvSocket))
+	if e != nil {
+		fmt.Fprintf(os.Stderr, "cannot connect to parent process; %v\n", e)
+		return 1
+	}
+	defer conn.Close()
+	go func() {
+		// unblock the decoder once ctx is done
+		<-ctx.Done()
+		conn.Close()
+	}()
+
+	decoder := json.NewDecoder(bufio.NewReader(conn))
+	encoder := json.NewEncoder(conn)
+	mutex := &sync.Mutex{}
+	wg := &sync.WaitGroup{}
+	stopped := false
+
+	for {
+		var req subprocessRequest
+		if err := decoder.Decode(&req); err != nil {
+			// the connection is closed once the parent stopped the receptor, or when the parent exited
+			cancel()
+			mutex.Lock()
+			alreadyStopped := stopped
+			stopped = true
+			mutex.Unlock()
+
+			returned := make(chan struct{})
+			go func() {
+				if !alreadyStopped {
+					receptor.Stop()
+				}
+				wg.Wait()
+				close(returned)
+			}()
+			select {
+			case <-returned:
+				return 0
+			case <-time.After(DefaultSubprocessStopTimeout):
+				fmt.Fprintf(os.Stderr, "receptor %s did not return within %s after being stopped\n", key, DefaultSubprocessStopTimeout)
+				return 1
+			}
+		}
+
+		wg.Add(1)
+		go func(req subprocessRequest) {
+			defer wg.Done()
+			var err Error
+			switch req.Op {
+			case subprocessOpInit:
+				err = receptor.Init()
+			case subprocessOpRun:
+				err = receptor.Run()
+			case subprocessOpHandleError:
+				err = receptor.HandleError(req.Error)
+			case subprocessOpStop:
+				mutex.Lock()
+				stopped = true
+				mutex.Unlock()
+				err = receptor.Stop()
+			default:
+				err = NewError("SubprocessError", fmt.Sprintf("unknown operation: %s", req.Op), nil)
+			}
+
+			mutex.Lock()
+			defer mutex.Unlock()
+			_ = encoder.Encode(subprocessResponse{ID: req.ID, Error: err})
+		}(req)
+	}
+}
+
+func subprocessRole() (string, bool) {
+	for _, arg := range os.Args[1:] {
+		if key, ok := strings.CutPrefix(arg, SubprocessRoleFlag+"="); ok {
+			return key, true
+		}
+	}
+	return "", false
+}
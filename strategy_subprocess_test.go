@@ -0,0 +1,92 @@
+/*
+Copyright 2023 Alexandre Mahdhaoui
+
+Licensed under the Apache License, Version 2.0 (the "License");
+you may not use this file except in compliance with the License.
+You may obtain a copy of the License at
+
+	http://www.apache.org/licenses/LICENSE-2.0
+
+Unless required by applicable law or agreed to in writing, software
+distributed under the License is distributed on an "AS IS" BASIS,
+WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+See the License for the specific language governing permissions and
+limitations under the License.
+*/
+
+package bda
+
+import (
+	"context"
+	"os"
+	"testing"
+	"time"
+)
+
+// TestMain serves the receptors registered below when the test binary is re-executed by a SubprocessStrategy.
+func TestMain(m *testing.M) {
+	RegisterSubprocessReceptor("echo", &fakeRuntimeBuilder{})
+	RegisterSubprocessReceptor("hang", &fakeRuntimeBuilder{run: func() Error {
+		select {}
+	}})
+	if IsSubprocess() {
+		os.Exit(ServeSubprocess(context.Background()))
+	}
+	os.Exit(m.Run())
+}
+
+func TestSubprocessStrategyRoundTrip(t *testing.T) {
+	r, err := SubprocessReceptorBuilder().Spawn("receptor", context.Background())
+	if err != nil {
+		t.Fatalf("Spawn() error = %v", err)
+	}
+	s := &SubprocessStrategy{ReceptorKey: "echo"}
+
+	if err := s.Init(r); err != nil {
+		t.Fatalf("Init() error = %v", err)
+	}
+	if err := s.Run(r); err != nil {
+		t.Fatalf("Run() error = %v", err)
+	}
+	// the receptor of the child returns the error unchanged
+	handled := s.HandleError(r, NewError("TestError", "failed", nil))
+	if handled == nil || handled.Type != "TestError" || handled.Message != "failed" {
+		t.Fatalf("HandleError() = %v; want TestError: failed", handled)
+	}
+	if err := s.Stop(r); err != nil {
+		t.Fatalf("Stop() error = %v", err)
+	}
+	if _, ok := s.childMap().Get("receptor"); ok {
+		t.Fatalf("child of receptor is still tracked after Stop()")
+	}
+}
+
+func TestSubprocessStrategyTerminatesChildWhenContextIsDone(t *testing.T) {
+	ctx, cancel := context.WithCancel(context.Background())
+	defer cancel()
+	r, err := SubprocessReceptorBuilder().Spawn("receptor", ctx)
+	if err != nil {
+		t.Fatalf("Spawn() error = %v", err)
+	}
+	s := &SubprocessStrategy{ReceptorKey: "hang", StopTimeout: 100 * time.Millisecond}
+	t.Cleanup(func() { s.Stop(r) })
+
+	if err := s.Init(r); err != nil {
+		t.Fatalf("Init() error = %v", err)
+	}
+	ran := make(chan Error, 1)
+	go func() {
+		ran <- s.Run(r)
+	}()
+
+	// the receptor of the child never returns: the child is killed once the context is done
+	time.AfterFunc(50*time.Millisecond, cancel)
+	select {
+	case err := <-ran:
+		if err == nil || err.Type != ErrorTypeSubprocessExit {
+			t.Fatalf("Run() error = %v; want %s", err, ErrorTypeSubprocessExit)
+		}
+	case <-time.After(5 * time.Second):
+		t.Fatalf("Run() did not return after the context was done")
+	}
+}
@@ -0,0 +1,79 @@
+/*
+Copyright 2023 Alexandre Mahdhaoui
+
+Licensed under the Apache License, Version 2.0 (the "License");
+you may not use this file except in compliance with the License.
+You may obtain a copy of the License at
+
+	http://www.apache.org/licenses/LICENSE-2.0
+
+Unless required by applicable law or agreed to in writing, software
+distributed under the License is distributed on an "AS IS" BASIS,
+WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+See the License for the specific language governing permissions and
+limitations under the License.
+*/
+
+package bda
+
+import (
+	"context"
+	"testing"
+)
+
+func TestStrategyRegistryLiteral(t *testing.T) {
+	r := &StrategyRegistry{}
+	if _, ok := r.Resolve("receptor", nil); ok {
+		t.Fatalf("Resolve() of an empty registry = true; want false")
+	}
+
+	byName, byLabel := &namedStrategy{name: "name"}, &namedStrategy{name: "label"}
+	r.RegisterName("receptor", byName)
+	r.RegisterLabel("tier", "gold", byLabel)
+	if s, _ := r.Resolve("receptor", map[string]string{"tier": "gold"}); s != byName {
+		t.Fatalf("Resolve() = %v; want the strategy registered by name", s)
+	}
+	if s, _ := r.Resolve("other", map[string]string{"tier": "gold"}); s != byLabel {
+		t.Fatalf("Resolve() = %v; want the strategy registered by label", s)
+	}
+}
+
+func TestWorkerPoolResolvesWorkerStrategiesByLabels(t *testing.T) {
+	gold, silver := &namedStrategy{name: "gold"}, &namedStrategy{name: "silver"}
+	registry := &StrategyRegistry{}
+	registry.RegisterLabel("tier", "gold", gold)
+	registry.RegisterLabel("zone", "eu", silver)
+
+	p := &WorkerPool{
+		Name: "pool",
+		WorkerFactory: WorkerFactory{
+			ReceptorFactory:  &fakeRuntimeBuilder{},
+			WorkerStrategy:   DefaultStrategy(),
+			WorkerStrategies: registry,
+			Labels:           map[string]string{"tier": "gold"},
+		},
+		Replicas: 1,
+		Labels:   map[string]string{"tier": "silver", "zone": "eu"},
+		Logger:   DefaultLogger(),
+		Context:  context.Background(),
+	}
+	p.Workers = DefaultCOWSafeArrayWithSize[*Worker](p.Replicas)
+
+	// the worker is labeled with the labels of the pool, overridden by the labels of the factory
+	w, err := p.spawnWorker(0)
+	if err != nil {
+		t.Fatalf("spawnWorker() error = %v", err)
+	}
+	if w.Labels["tier"] != "gold" || w.Labels["zone"] != "eu" {
+		t.Fatalf("worker labels = %v; want tier=gold and zone=eu", w.Labels)
+	}
+	if w.Strategy != gold {
+		t.Fatalf("worker strategy = %v; want the strategy registered for tier=gold", w.Strategy)
+	}
+}
+
+// namedStrategy is a distinct Strategy that can be compared by identity.
+type namedStrategy struct {
+	Strategy
+	name string
+}
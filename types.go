@@ -16,7 +16,10 @@ limitations under the License.
 
 package bda
 
-import "context"
+import (
+	"context"
+	"time"
+)
 
 type Runtime interface {
 	// Operations
@@ -36,3 +39,11 @@ type Runtime interface {
 type Builder[T any] interface {
 	Spawn(name string, ctx context.Context) (T, Error)
 }
+
+// positiveOrDefault returns d, or def if d is not positive.
+func positiveOrDefault(d, def time.Duration) time.Duration {
+	if d <= 0 {
+		return def
+	}
+	return d
+}
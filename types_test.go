@@ -0,0 +1,75 @@
+/*
+Copyright 2023 Alexandre Mahdhaoui
+
+Licensed under the Apache License, Version 2.0 (the "License");
+you may not use this file except in compliance with the License.
+You may obtain a copy of the License at
+
+	http://www.apache.org/licenses/LICENSE-2.0
+
+Unless required by applicable law or agreed to in writing, software
+distributed under the License is distributed on an "AS IS" BASIS,
+WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+See the License for the specific language governing permissions and
+limitations under the License.
+*/
+
+package bda
+
+import (
+	"context"
+	"sync/atomic"
+)
+
+// fakeRuntime is a configurable Runtime shared by the tests. It counts its runs, and calls the optional run, stop and
+// runBatch functions. It implements BatchReceptor[int].
+type fakeRuntime struct {
+	name     string
+	ctx      context.Context
+	run      func() Error
+	stop     func() Error
+	runBatch func(batch []int) ([]int, Error)
+
+	runs    atomic.Int64
+	running atomic.Int64
+}
+
+func (r *fakeRuntime) Init() Error                 { return nil }
+func (r *fakeRuntime) HandleError(err Error) Error { return err }
+func (r *fakeRuntime) GetName() string             { return r.name }
+func (r *fakeRuntime) GetType() string             { return "receptor" }
+func (r *fakeRuntime) GetLogger() *Logger          { return DefaultLogger() }
+func (r *fakeRuntime) GetContext() context.Context { return r.ctx }
+
+func (r *fakeRuntime) Run() Error {
+	r.runs.Add(1)
+	r.running.Add(1)
+	defer r.running.Add(-1)
+	if r.run == nil {
+		return nil
+	}
+	return r.run()
+}
+
+func (r *fakeRuntime) Stop() Error {
+	if r.stop == nil {
+		return nil
+	}
+	return r.stop()
+}
+
+func (r *fakeRuntime) RunBatch(batch []int) ([]int, Error) {
+	if r.runBatch == nil {
+		return nil, nil
+	}
+	return r.runBatch(batch)
+}
+
+// fakeRuntimeBuilder builds a fakeRuntime calling run.
+type fakeRuntimeBuilder struct {
+	run func() Error
+}
+
+func (b *fakeRuntimeBuilder) Spawn(name string, ctx context.Context) (Runtime, Error) {
+	return &fakeRuntime{name: name, ctx: ctx, run: b.run}, nil
+}
@@ -16,7 +16,10 @@ limitations under the License.
 
 package bda
 
-import "context"
+import (
+	"context"
+	"sync"
+)
 
 // Worker is a wrapper struct around a concrete implementation of a Runtime.
 // A Worker holds a reference to a Strategy. The Strategy is injected in the worker by the WorkerFactory
@@ -24,9 +27,17 @@ type Worker struct {
 	Name     string
 	Strategy Strategy
 	Receptor Runtime
-	Logger   *Logger
+	// Labels are used to resolve the Strategy of the worker (see WorkerFactory).
+	Labels map[string]string
+	Logger *Logger
 
 	Context context.Context
+
+	// keepAlives holds the running keep-alives started by KeepLease. They are created on first use, so a Worker
+	// built as a struct literal can keep leases too.
+	keepAlives      map[*LeaseKeepAlive]struct{}
+	keepAlivesMutex *sync.Mutex
+	keepAlivesInit  sync.Once
 }
 
 func (w *Worker) Init() Error {
@@ -40,9 +51,15 @@ func (w *Worker) Init() Error {
 	return nil
 }
 func (w *Worker) Run() Error {
+	return w.run(w.Receptor)
+}
+
+// run runs runtime on behalf of the worker through its Strategy. runtime is the Receptor, or an adapter over it
+// such as the batchRuntime of WorkerPoolStrategyRunBatchLoop.
+func (w *Worker) run(runtime Runtime) Error {
 	LogDebug(w, LogOperationRun, LogStatusStart)
 
-	if err := w.Strategy.Run(w.Receptor); err != nil {
+	if err := w.Strategy.Run(runtime); err != nil {
 		LogDebugf(w, LogOperationRun, LogStatusFailed, "%+v", err)
 		return err
 	}
@@ -57,7 +74,14 @@ func (w *Worker) HandleError(err Error) Error {
 func (w *Worker) Stop() Error {
 	LogDebug(w, LogOperationStop, LogStatusStart)
 
-	if err := w.Strategy.Stop(w.Receptor); err != nil {
+	err := w.Strategy.Stop(w.Receptor)
+
+	// Release leases kept on behalf of the worker, once the receptor stopped working on the leased resources
+	for _, k := range w.runningKeepAlives() {
+		k.Stop()
+	}
+
+	if err != nil {
 		LogDebugf(w, LogOperationStop, LogStatusFailed, "%+v", err)
 		return err
 	}
@@ -76,3 +100,7 @@ func (w *Worker) GetType() string {
 func (w *Worker) GetLogger() *Logger {
 	return w.Logger
 }
+
+func (w *Worker) GetContext() context.Context {
+	return w.Context
+}
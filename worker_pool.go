@@ -19,6 +19,7 @@ package bda
 import (
 	"context"
 	"fmt"
+	"runtime/debug"
 	"sync"
 )
 
@@ -26,9 +27,12 @@ type WorkerPool struct {
 	Name          string
 	Workers       SafeArray[*Worker]
 	WorkerFactory WorkerFactory
-	StrategyFunc  WorkerPoolStrategyFunc
-	Replicas      int
-	Logger        *Logger
+	// Strategy runs each worker of the pool. Defaults to WorkerPoolStrategyRunLoop.
+	Strategy WorkerPoolStrategy
+	Replicas int
+	// Labels are used to resolve the Strategy of the pool (see StrategyRegistry).
+	Labels map[string]string
+	Logger *Logger
 
 	Context context.Context
 }
@@ -38,21 +42,31 @@ func (p *WorkerPool) Init() Error {
 	errs := DefaultSafeArray[Error]()
 	wg := &sync.WaitGroup{}
 
-	p.Workers = DefaultSafeArrayWithSize[*Worker](p.Replicas)
+	p.Workers = DefaultCOWSafeArrayWithSize[*Worker](p.Replicas)
 
 	for i := 0; i < p.Replicas; i++ {
+		i := i
 		wg.Add(1)
-		go p.spawnWorker(i, wg, errs)
+		go func() {
+			defer wg.Done()
+			if _, err := p.spawnWorker(i); err != nil {
+				errs.Append(err)
+			}
+		}()
 	}
 	wg.Wait()
 
 	for i := 0; i < p.Replicas; i++ {
-		i := i
+		w, _ := p.Workers.Get(i)
+		if w == nil {
+			continue
+		}
 		wg.Add(1)
 		go func() {
-			w, _ := p.Workers.Get(i)
-			w.Init()
-			wg.Done()
+			defer wg.Done()
+			if err := w.Init(); err != nil {
+				errs.Append(err)
+			}
 		}()
 	}
 	wg.Wait()
@@ -60,15 +74,31 @@ func (p *WorkerPool) Init() Error {
 	return HandleErrors(p, LogOperationInit, errs)
 }
 
+// Run runs the Strategy of the pool for every worker, each in its own goroutine, and returns once they all returned.
+// Errors returned or reported by the Strategy, and panics it raised, are collected into the returned Error.
 func (p *WorkerPool) Run() Error {
 	LogDebug(p, LogOperationRun, LogStatusStart)
 	errs := DefaultSafeArray[Error]()
 	wg := &sync.WaitGroup{}
 
+	strategy := p.Strategy
+	if strategy == nil {
+		strategy = WorkerPoolStrategyRunLoop
+	}
+
 	for i := 0; i < p.Workers.Length(); i++ {
-		i := i
+		h := &workerHandle{pool: p, index: i, errs: errs}
 		wg.Add(1)
-		go p.StrategyFunc(p, i, wg, errs)
+		go func() {
+			defer wg.Done()
+			defer func() {
+				if r := recover(); r != nil {
+					LogErrorf(p, LogOperationRun, LogStatusFailed, "strategy of worker-%d panicked: %v\n%s", h.index, r, debug.Stack())
+					errs.Append(NewError(ErrorTypePanic, fmt.Sprintf("strategy of worker-%d panicked: %v", h.index, r), nil))
+				}
+			}()
+			h.Report(strategy.Run(p.Context, h))
+		}()
 	}
 	wg.Wait()
 	return HandleErrors(p, LogOperationRun, errs)
@@ -81,14 +111,16 @@ func (p *WorkerPool) Stop() Error {
 
 	for i := 0; i < p.Workers.Length(); i++ {
 		w, _ := p.Workers.Get(i)
+		if w == nil {
+			continue
+		}
 		wg.Add(1)
-		go func(wg *sync.WaitGroup) {
-			err := w.Stop()
-			if err != nil {
+		go func() {
+			defer wg.Done()
+			if err := w.Stop(); err != nil {
 				errs.Append(err)
 			}
-			wg.Done()
-		}(wg)
+		}()
 	}
 	wg.Wait()
 	return HandleErrors(p, LogOperationStop, errs)
@@ -98,40 +130,49 @@ func (p *WorkerPool) HandleError(err Error) Error {
 	return nil
 }
 
-func (p *WorkerPool) respawnWorker(i int, wg *sync.WaitGroup, errs SafeArray[Error]) {
+// respawnWorker stops worker i if it exists, then spawns and initializes a new one.
+func (p *WorkerPool) respawnWorker(i int) (*Worker, Error) {
 	LogDebugf(p, LogOperationRun, LogStatusProgress, "respawn worker-%d", i)
 
-	w, _ := p.Workers.Get(i)
-
 	// First stop worker if pointer not nil
-	if w != nil {
+	if w, _ := p.Workers.Get(i); w != nil {
 		LogInfof(p, LogOperationRun, LogStatusProgress, "found existing worker-%d; stopping worker before respawn", i)
 		if err := w.Stop(); err != nil {
 			LogErrorf(p, LogOperationRun, LogStatusProgress, "error while stopping worker-%d; %v", i, err)
-			errs.Append(err)
 		}
 	}
-	p.spawnWorker(i, wg, errs)
+
+	w, err := p.spawnWorker(i)
+	if err != nil {
+		return nil, err
+	}
 
 	// Initialize freshly respawned worker
-	w.Init()
+	if err := w.Init(); err != nil {
+		LogErrorf(p, LogOperationRun, LogStatusProgress, "error while initializing worker-%d; %v", i, err)
+		return w, err
+	}
+	return w, nil
 }
 
-func (p *WorkerPool) spawnWorker(i int, wg *sync.WaitGroup, errs SafeArray[Error]) {
+// spawnWorker spawns worker i and stores it in Workers. On error, the slot of the worker is set to nil.
+func (p *WorkerPool) spawnWorker(i int) (*Worker, Error) {
 	LogDebugf(p, LogOperationInit, LogStatusProgress, "spawn worker-%d", i)
 
-	w, err := p.WorkerFactory.Spawn(fmt.Sprintf("%s-%d", p.Name, i), p.Context)
+	w, err := p.WorkerFactory.spawn(fmt.Sprintf("%s-%d", p.Name, i), p.Context, mergeLabels(p.Labels, p.WorkerFactory.Labels))
 	if err != nil {
 		LogErrorf(p, LogOperationInit, LogStatusProgress, "error while spawning worker-%d; %v", i, err)
 		p.HandleError(err)
-		errs.Append(err)
 	}
 
 	if ok := p.Workers.Set(i, w); !ok {
 		LogFatalf(p, LogOperationInit, LogStatusFailed, "failed to spawn worker-%d; unable to WorkerPool.Workers.Set(%d, worker)", i, i)
 	}
-	wg.Done()
+	if err != nil {
+		return nil, err
+	}
 	LogDebugf(p, LogOperationInit, LogStatusProgress, "successfully spawn worker-%d", i)
+	return w, nil
 }
 
 func (p *WorkerPool) GetName() string {
@@ -146,82 +187,154 @@ func (p *WorkerPool) GetLogger() *Logger {
 	return p.Logger
 }
 
+func (p *WorkerPool) GetContext() context.Context {
+	return p.Context
+}
+
 // ----------------------------------------------------------------------------------------------------------------------
-// - WorkerPoolStrategyFunc
-
-type WorkerPoolStrategyFunc func(p *WorkerPool, i int, wg *sync.WaitGroup, errors SafeArray[Error])
-
-func WorkerPoolStrategyRunLoop(p *WorkerPool, i int, wg *sync.WaitGroup, errs SafeArray[Error]) {
-	LogDebugf(p, LogOperationRun, LogStatusProgress, "starting run loop for worker-%d", i)
-
-	for {
-		select {
-		case <-p.Context.Done():
-			wg.Done() //
-			return
-		default:
-			innerWg := &sync.WaitGroup{}
-			innerWg.Add(1)
-
-			w, _ := p.Workers.Get(i)
-			if w == nil {
-				LogDebugf(p, LogOperationRun, LogStatusProgress, "found nil worker-%d; spawning new worker", i)
-				p.respawnWorker(i, innerWg, errs)
-				innerWg.Wait()
-				innerWg.Add(1)
-			}
-			WorkerPoolStrategyRunOnce(p, i, innerWg, errs)
-			innerWg.Wait()
-		}
+// - WorkerPoolStrategy
+
+// WorkerPoolStrategy runs a worker of a WorkerPool. WorkerPool.Run calls Run once per worker, each in its own
+// goroutine, and waits for every call to return: synchronization belongs to the pool, so a strategy only has to
+// return once its context is done or its work is over.
+type WorkerPoolStrategy interface {
+	Run(ctx context.Context, h WorkerHandle) Error
+}
+
+// WorkerPoolStrategyFunc adapts a function to the WorkerPoolStrategy interface.
+type WorkerPoolStrategyFunc func(ctx context.Context, h WorkerHandle) Error
+
+func (f WorkerPoolStrategyFunc) Run(ctx context.Context, h WorkerHandle) Error {
+	return f(ctx, h)
+}
+
+// WorkerHandle gives a WorkerPoolStrategy access to the worker it runs.
+type WorkerHandle interface {
+	// Index method returns the index of the worker in its pool
+	Index() int
+	// Pool method returns the pool of the worker
+	Pool() *WorkerPool
+	// Worker method returns the worker, spawning and initializing it if needed
+	Worker() (*Worker, Error)
+	// Respawn method stops the worker, then spawns and initializes a new one
+	Respawn() (*Worker, Error)
+	// RunOnce method runs the worker once, spawning it if needed, and returns the error left after
+	// Worker.HandleError. The error is not reported to the pool.
+	RunOnce() Error
+	// Report method collects an error into the Error returned by WorkerPool.Run. Nil errors are ignored.
+	Report(err Error)
+}
+
+type workerHandle struct {
+	pool  *WorkerPool
+	index int
+	errs  SafeArray[Error]
+}
+
+func (h *workerHandle) Index() int {
+	return h.index
+}
+
+func (h *workerHandle) Pool() *WorkerPool {
+	return h.pool
+}
+
+func (h *workerHandle) Worker() (*Worker, Error) {
+	if w, _ := h.pool.Workers.Get(h.index); w != nil {
+		return w, nil
 	}
+	LogDebugf(h.pool, LogOperationRun, LogStatusProgress, "found nil worker-%d; spawning new worker", h.index)
+	return h.pool.respawnWorker(h.index)
 }
 
-func WorkerPoolStrategyRunOnce(p *WorkerPool, i int, wg *sync.WaitGroup, errs SafeArray[Error]) {
-	LogDebugf(p, LogOperationRun, LogStatusProgress, "starting run for worker-%d", i)
+func (h *workerHandle) Respawn() (*Worker, Error) {
+	return h.pool.respawnWorker(h.index)
+}
 
-	w, _ := p.Workers.Get(i)
-	if w == nil {
-		LogDebugf(p, LogOperationRun, LogStatusProgress, "found nil worker-%d; worker should be initialized; got: nil; want: &Worker{}", i)
-		errs.Append(NewError(
-			"RuntimeError",
-			"worker should be initialized; got: nil; want: &Worker{}",
-			nil,
-		))
-		return
+func (h *workerHandle) RunOnce() Error {
+	LogDebugf(h.pool, LogOperationRun, LogStatusProgress, "starting run for worker-%d", h.index)
+	w, err := h.Worker()
+	if err != nil {
+		return err
 	}
 
-	err := w.Run()
+	err = w.Run()
 	if err = w.HandleError(err); err != nil {
-		LogDebugf(p, LogOperationRun, LogStatusProgress, "error while running worker-%d; %v", i, err)
-		p.HandleError(err)
-		errs.Append(NewError(
-			"RuntimeError",
-			"worker should be initialized; got: nil; want: &Worker{}",
-			nil,
-		))
+		LogDebugf(h.pool, LogOperationRun, LogStatusProgress, "error while running worker-%d; %v", h.index, err)
+		h.pool.HandleError(err)
 	}
+	return err
+}
 
-	wg.Done() // Unreachable atm; Will be useful when implementing a signal to interrupt the run process.
+func (h *workerHandle) Report(err Error) {
+	if err != nil {
+		h.errs.Append(err)
+	}
 }
 
+// WorkerPoolStrategyRunLoop runs each worker repeatedly until the context is done. Errors are reported to the pool.
+var WorkerPoolStrategyRunLoop WorkerPoolStrategy = WorkerPoolStrategyFunc(func(ctx context.Context, h WorkerHandle) Error {
+	LogDebugf(h.Pool(), LogOperationRun, LogStatusProgress, "starting run loop for worker-%d", h.Index())
+	for ctx.Err() == nil {
+		h.Report(h.RunOnce())
+	}
+	return nil
+})
+
+// WorkerPoolStrategyRunOnce runs each worker once.
+var WorkerPoolStrategyRunOnce WorkerPoolStrategy = WorkerPoolStrategyFunc(func(ctx context.Context, h WorkerHandle) Error {
+	return h.RunOnce()
+})
+
 // ----------------------------------------------------------------------------------------------------------------------
 // - WorkerFactory
 
 type WorkerFactory struct {
 	ReceptorFactory Builder[Runtime]
-	WorkerStrategy  Strategy
+	// WorkerStrategy is the Strategy of the workers that have no Strategy registered in WorkerStrategies.
+	WorkerStrategy Strategy
+	// WorkerStrategies resolves the Strategy of each worker by name, e.g. "<pool name>-0", or by labels. It is
+	// optional.
+	WorkerStrategies *StrategyRegistry
+	// Labels are set on the spawned workers. Workers spawned by a WorkerPool are also labeled with the Labels of the
+	// pool; Labels of the factory take precedence.
+	Labels map[string]string
 }
 
 func (f *WorkerFactory) Spawn(name string, ctx context.Context) (*Worker, Error) {
+	return f.spawn(name, ctx, mergeLabels(f.Labels))
+}
+
+// spawn spawns a worker labeled with labels, and resolves its Strategy by name and labels.
+func (f *WorkerFactory) spawn(name string, ctx context.Context, labels map[string]string) (*Worker, Error) {
 	receptor, err := f.ReceptorFactory.Spawn(fmt.Sprintf("%s-receptor", name), ctx)
 	if err != nil {
 		return nil, err
 	}
+	strategy := f.WorkerStrategy
+	if f.WorkerStrategies != nil {
+		if s, ok := f.WorkerStrategies.Resolve(name, labels); ok {
+			strategy = s
+		}
+	}
+
 	return &Worker{
 		Name:     name,
-		Strategy: f.WorkerStrategy,
+		Strategy: strategy,
 		Receptor: receptor,
+		Labels:   labels,
 		Logger:   DefaultLogger(),
 		Context:  ctx,
 	}, nil
 }
+
+// mergeLabels returns a new map holding the labels of every map. Later maps take precedence.
+func mergeLabels(labels ...map[string]string) map[string]string {
+	merged := make(map[string]string)
+	for _, l := range labels {
+		for key, value := range l {
+			merged[key] = value
+		}
+	}
+	return merged
+}
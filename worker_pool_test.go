@@ -0,0 +1,133 @@
+/*
+Copyright 2023 Alexandre Mahdhaoui
+
+Licensed under the Apache License, Version 2.0 (the "License");
+you may not use this file except in compliance with the License.
+You may obtain a copy of the License at
+
+	http://www.apache.org/licenses/LICENSE-2.0
+
+Unless required by applicable law or agreed to in writing, software
+distributed under the License is distributed on an "AS IS" BASIS,
+WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+See the License for the specific language governing permissions and
+limitations under the License.
+*/
+
+package bda
+
+import (
+	"context"
+	"sync/atomic"
+	"testing"
+	"time"
+)
+
+func TestWorkerPoolRunRecoversStrategyPanics(t *testing.T) {
+	p := newTestWorkerPool(t, context.Background(), nil)
+	p.Strategy = WorkerPoolStrategyFunc(func(ctx context.Context, h WorkerHandle) Error {
+		panic("boom")
+	})
+
+	err := runWorkerPool(t, p)
+	if err == nil || len(err.SubErrors) != 2 {
+		t.Fatalf("Run() error = %v; want one error per worker", err)
+	}
+	for _, sub := range err.SubErrors {
+		if sub.Type != ErrorTypePanic {
+			t.Fatalf("Run() sub-error = %+v; want %s", *sub, ErrorTypePanic)
+		}
+	}
+}
+
+func TestWorkerPoolRunCollectsReportedErrors(t *testing.T) {
+	p := newTestWorkerPool(t, context.Background(), nil)
+	p.Strategy = WorkerPoolStrategyFunc(func(ctx context.Context, h WorkerHandle) Error {
+		h.Report(nil)
+		h.Report(NewError("ReportedError", "reported", nil))
+		return NewError("ReturnedError", "returned", nil)
+	})
+
+	err := runWorkerPool(t, p)
+	if err == nil || len(err.SubErrors) != 4 {
+		t.Fatalf("Run() error = %v; want 2 errors per worker", err)
+	}
+	count := make(map[ErrorType]int)
+	for _, sub := range err.SubErrors {
+		count[sub.Type]++
+	}
+	if count["ReportedError"] != 2 || count["ReturnedError"] != 2 {
+		t.Fatalf("Run() sub-errors = %v; want 2 reported and 2 returned errors", count)
+	}
+}
+
+func TestWorkerPoolRunOnceReturns(t *testing.T) {
+	runs := &atomic.Int64{}
+	p := newTestWorkerPool(t, context.Background(), func() Error {
+		runs.Add(1)
+		return nil
+	})
+	p.Strategy = WorkerPoolStrategyRunOnce
+
+	if err := runWorkerPool(t, p); err != nil {
+		t.Fatalf("Run() error = %v", err)
+	}
+	if got := runs.Load(); got != 2 {
+		t.Fatalf("runs = %d; want one per worker", got)
+	}
+}
+
+func TestWorkerPoolRunDefaultsToRunLoop(t *testing.T) {
+	ctx, cancel := context.WithCancel(context.Background())
+	defer cancel()
+	runs := &atomic.Int64{}
+	p := newTestWorkerPool(t, ctx, func() Error {
+		// the workers run until the context is done
+		if runs.Add(1) >= 10 {
+			cancel()
+		}
+		return nil
+	})
+
+	if err := runWorkerPool(t, p); err != nil {
+		t.Fatalf("Run() error = %v", err)
+	}
+	if got := runs.Load(); got < 10 {
+		t.Fatalf("runs = %d; want at least 10", got)
+	}
+}
+
+// newTestWorkerPool returns an initialized pool of 2 workers whose receptors call run.
+func newTestWorkerPool(t *testing.T, ctx context.Context, run func() Error) *WorkerPool {
+	t.Helper()
+	p := &WorkerPool{
+		Name: "pool",
+		WorkerFactory: WorkerFactory{
+			ReceptorFactory: &fakeRuntimeBuilder{run: run},
+			WorkerStrategy:  DefaultStrategy(),
+		},
+		Replicas: 2,
+		Logger:   DefaultLogger(),
+		Context:  ctx,
+	}
+	if err := p.Init(); err != nil {
+		t.Fatalf("Init() error = %v", err)
+	}
+	return p
+}
+
+// runWorkerPool runs p, failing the test if Run does not return.
+func runWorkerPool(t *testing.T, p *WorkerPool) Error {
+	t.Helper()
+	ran := make(chan Error, 1)
+	go func() {
+		ran <- p.Run()
+	}()
+	select {
+	case err := <-ran:
+		return err
+	case <-time.After(5 * time.Second):
+		t.Fatalf("Run() did not return")
+		return nil
+	}
+}